@@ -3,10 +3,12 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,19 +20,45 @@ import (
 const (
 	tick                  = time.Duration(10) * time.Millisecond // 10ms
 	timeBetweenBlockCheck = time.Duration(10) * time.Second      // 5 second between checking for jobs
+
+	submitMode     = "submit"
+	statusMode     = "status"
+	cancelMode     = "cancel"
+	reportMode     = "report"
+	deadLetterMode = "deadletter"
+	queueMode      = "queue"
+
+	deadLetterList    = "list"
+	deadLetterRequeue = "requeue"
+	deadLetterPurge   = "purge"
+
+	queueDepth   = "depth"
+	queueList    = "list"
+	queuePurge   = "purge"
+	queueRequeue = "requeue"
+	queueJob     = "job"
+	queueWork    = "work"
+
+	// dead letter jobs that are only listed re-appear after this timeout
+	deadLetterVisibilityTimeout = time.Duration(30) * time.Second
 )
 
 func usage(errs ...error) {
 	for _, err := range errs {
 		fmt.Fprintf(os.Stderr, "error: %s\n\n", err.Error())
 	}
-	fmt.Fprintf(os.Stderr, "usage: %s [OPTIONS]\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "       queue the path to warm by writing the config file to the path\n")
+	fmt.Fprintf(os.Stderr, "usage: %s [OPTIONS] [%s|%s|%s|%s|%s %s|%s|%s|%s %s|%s|%s|%s [%s|%s]]\n", os.Args[0], submitMode, statusMode, cancelMode, reportMode, deadLetterMode, deadLetterList, deadLetterRequeue, deadLetterPurge, queueMode, queueDepth, queueList, queuePurge, queueRequeue, queueJob, queueWork)
+	fmt.Fprintf(os.Stderr, "       %s (default) - queue the path to warm and print the job id\n", submitMode)
+	fmt.Fprintf(os.Stderr, "       %s - print the progress of the job specified by -jobId, exit non-zero if reads failed or the job is cancelled\n", statusMode)
+	fmt.Fprintf(os.Stderr, "       %s - cancel the job specified by -jobId, the manager and workers drop its remaining work\n", cancelMode)
+	fmt.Fprintf(os.Stderr, "       %s - write the json and csv report of the job specified by -jobId to the warm target export, and print the json report\n", reportMode)
+	fmt.Fprintf(os.Stderr, "       %s - list, requeue, or purge the dead lettered worker jobs, optionally only those of -jobId\n", deadLetterMode)
+	fmt.Fprintf(os.Stderr, "       %s - print the queue depths, list the %s or %s queue jobs optionally only those of -jobId, purge the %s or %s queues, or requeue the json line jobs read from stdin\n", queueMode, queueJob, queueWork, queueJob, queueWork)
 	fmt.Fprintf(os.Stderr, "options:\n")
 	flag.PrintDefaults()
 }
 
-func initializeApplicationVariables(ctx context.Context) (*cachewarmer.WarmPathJob, *cachewarmer.CacheWarmerQueues, bool) {
+func initializeApplicationVariables(ctx context.Context) (string, *cachewarmer.WarmPathJob, *cachewarmer.CacheWarmerQueues, bool) {
 	var enableDebugging = flag.Bool("enableDebugging", false, "enable debug logging")
 	var warmTargetMountAddresses = flag.String("warmTargetMountAddresses", "", "the warm target cache filer mount addresses separated by commas")
 	var warmTargetExportPath = flag.String("warmTargetExportPath", "", "the warm target export path")
@@ -39,13 +67,54 @@ func initializeApplicationVariables(ctx context.Context) (*cachewarmer.WarmPathJ
 	var inclusionCsv = flag.String("inclusionCsv", "", "the inclusion list of file match strings per https://golang.org/pkg/path/filepath/#Match.  Leave blank to include everything.")
 	var exclusionCsv = flag.String("exclusionCsv", "", "the exclusion list of file match strings per https://golang.org/pkg/path/filepath/#Match.  Leave blank to not exlude anything.")
 
+	var pathInclusionCsv = flag.String("pathInclusionCsv", "", "the inclusion list of path match strings relative to the warm target path, a match of a directory includes everything beneath it.  Leave blank to include everything.")
+	var pathExclusionCsv = flag.String("pathExclusionCsv", "", "the exclusion list of path match strings relative to the warm target path, a match of a directory excludes everything beneath it.  Leave blank to not exclude anything.")
+	var inclusionRegex = flag.String("inclusionRegex", "", "the regular expression the path relative to the warm target path must match.  Leave blank to include everything.")
+	var exclusionRegex = flag.String("exclusionRegex", "", "the regular expression the path relative to the warm target path must not match.  Leave blank to not exclude anything.")
+
+	var minFileSizeBytes = flag.Int64("minFileSizeBytes", 0, "the minimum file size in bytes to warm.")
 	var maxFileSizeBytes = flag.Int64("maxFileSizeBytes", 0, "the maximum file size in bytes to warm.")
+	var modifiedAfter = flag.String("modifiedAfter", "", "only warm files modified at or after this RFC3339 time, or duration ago such as '72h'.")
+	var modifiedBefore = flag.String("modifiedBefore", "", "only warm files modified before this RFC3339 time, or duration ago such as '24h'.")
 
+	var queueType = flag.String("queueType", cachewarmer.QueueTypeAzure, fmt.Sprintf("the queue backend, either '%s' or '%s'", cachewarmer.QueueTypeAzure, cachewarmer.QueueTypeDirectory))
 	var storageAccountResourceGroup = flag.String("storageAccountResourceGroup", "", "the storage account resource group")
 	var storageAccount = flag.String("storageAccountName", "", "the storage account name to host the queue")
 	var queueNamePrefix = flag.String("queueNamePrefix", "", "the queue name to be used for organizing the work. The queues will be created automatically")
+	var queueMountAddress = flag.String("queueMountAddress", "", "the mount address of the NFS export hosting the directory queues")
+	var queueExportPath = flag.String("queueExportPath", "", "the NFS export path hosting the directory queues")
+	var queueDirectoryPath = flag.String("queueDirectoryPath", cachewarmer.DefaultQueueDirectoryPath, "the path on the NFS export of the directory queues")
+
+	var maxMBPerSecond = flag.Int64("maxMBPerSecond", 0, "the maximum MB per second read by all workers for this job, 0 is unlimited")
+	var maxFilesPerSecond = flag.Int64("maxFilesPerSecond", 0, "the maximum files per second read by all workers for this job, 0 is unlimited")
+	var throttleSchedule = flag.String("throttleSchedule", "", "a comma separated schedule of worker local time of day limits overriding maxMBPerSecond and maxFilesPerSecond, for example '08:00-18:00=100/50'")
+
+	var metadataOnly = flag.Bool("metadataOnly", false, "only warm the directory listings and file attributes, without reading the file data")
+	var metadataReadBytes = flag.Int64("metadataReadBytes", 0, "for metadataOnly jobs, also read this many bytes from the start of each file")
+
+	var priority = flag.String("priority", cachewarmer.PriorityNormal, fmt.Sprintf("the job priority, one of '%s', '%s', or '%s', more urgent jobs are dispatched first", cachewarmer.PriorityHigh, cachewarmer.PriorityNormal, cachewarmer.PriorityLow))
+
+	var fileListPath = flag.String("fileListPath", "", "the path on the warm target export of a newline or json list of the files to warm, relative to the warm target path.  The warm target path is not walked, and full must be specified to warm all listed files.")
+	var fileListBlob = flag.String("fileListBlob", "", "the '<container>/<blob name>' in the queue storage account of a newline or json list of the files to warm, relative to the warm target path.  The warm target path is not walked, and full must be specified to warm all listed files.")
+
+	var distributedEnumeration = flag.Bool("distributedEnumeration", false, "the workers enumerate the directories of the warm target path in parallel, instead of the manager.  The incremental manifest is not used, so full must be specified to warm all files.")
+
+	var checksum = flag.Bool("checksum", false, "compute the sha256 checksum of each file read, the checksums of each worker are written below the job directory on the warm target export.  Large files are read whole instead of in parallel parts.")
+	var referenceChecksumPath = flag.String("referenceChecksumPath", "", "the path on the warm target export of a sha256sum format file of the expected checksums, relative to the warm target path.  Mismatched checksums fail the job.")
 
-	var blockUntilWarm = flag.Bool("blockUntilWarm", false, "the job submitter will not return until there are no more jobs")
+	var mountType = flag.String("mountType", "", fmt.Sprintf("the mount type of the warm target export, either '%s' or '%s', a cifs export path is the share name.  Leave blank for '%s'.", cachewarmer.MountTypeNfs, cachewarmer.MountTypeCifs, cachewarmer.MountTypeNfs))
+	var mountOptions = flag.String("mountOptions", "", fmt.Sprintf("the mount options of the warm target export, for example 'vers=4.1,nconnect=8,rsize=1048576,actimeo=600'.  Leave blank for the default options of the manager, or '%s'.", cachewarmer.DefaultNfsMountOptions))
+
+	var reportBlobContainer = flag.String("reportBlobContainer", "", "the blob container in the queue storage account to also upload the json and csv report of the job to when it completes.  The report is always written to the warm target export.")
+	var manifestBlobContainer = flag.String("manifestBlobContainer", "", "the blob container in the queue storage account to keep the incremental manifest of the warm target path in, instead of the warm target export")
+
+	var deadline = flag.String("deadline", "", "drop the remaining work of the job after this RFC3339 time, or duration after submission such as '4h', and report the job as partially warmed.  Leave blank for no deadline.")
+
+	var symlinkPolicy = flag.String("symlinkPolicy", cachewarmer.SymlinkPolicySkip, fmt.Sprintf("either '%s' the symlinks below the warm target path, or '%s' the symlinks to files and directories within the warm target export.  Files reached through several hard links or symlinks are read once.", cachewarmer.SymlinkPolicySkip, cachewarmer.SymlinkPolicyFollow))
+
+	var fullWarm = flag.Bool("full", false, "warm all files, instead of only the files that are new or changed since the previous job for the warm target path")
+	var blockUntilWarm = flag.Bool("blockUntilWarm", false, "the job submitter will not return until the submitted job is complete")
+	var jobId = flag.String("jobId", "", "the job id reported by the status mode, or the job id filter for the deadletter and queue modes")
 
 	flag.Parse()
 
@@ -53,36 +122,254 @@ func initializeApplicationVariables(ctx context.Context) (*cachewarmer.WarmPathJ
 		log.EnableDebugging()
 	}
 
-	if len(*warmTargetMountAddresses) == 0 {
+	mode := submitMode
+	if flag.NArg() > 0 {
+		mode = flag.Arg(0)
+	}
+	if mode != submitMode && mode != statusMode && mode != cancelMode && mode != reportMode && mode != deadLetterMode && mode != queueMode {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown mode '%s'\n", mode)
+		usage()
+		os.Exit(1)
+	}
+
+	if mode == deadLetterMode {
+		action := flag.Arg(1)
+		if action != deadLetterList && action != deadLetterRequeue && action != deadLetterPurge {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown deadletter action '%s'\n", action)
+			usage()
+			os.Exit(1)
+		}
+	}
+
+	if mode == queueMode {
+		action := flag.Arg(1)
+		if action != queueDepth && action != queueList && action != queuePurge && action != queueRequeue {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown queue action '%s'\n", action)
+			usage()
+			os.Exit(1)
+		}
+		if queueKind := flag.Arg(2); action != queueDepth && queueKind != queueJob && queueKind != queueWork {
+			fmt.Fprintf(os.Stderr, "ERROR: the queue action '%s' requires '%s' or '%s', not '%s'\n", action, queueJob, queueWork, queueKind)
+			usage()
+			os.Exit(1)
+		}
+	}
+
+	if mode != deadLetterMode && mode != queueMode && len(*warmTargetMountAddresses) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: warmTargetMountAddresses not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*warmTargetExportPath) == 0 {
+	if mode != deadLetterMode && mode != queueMode && len(*warmTargetExportPath) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: warmTargetExportPath not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*warmTargetPath) == 0 {
+	modifiedAfterTime, err := cachewarmer.ParseModifiedTime(*modifiedAfter, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: modifiedAfter is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	modifiedBeforeTime, err := cachewarmer.ParseModifiedTime(*modifiedBefore, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: modifiedBefore is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	fileFilter, err := cachewarmer.InitializeFileFilter(
+		*warmTargetPath,
+		*inclusionCsv,
+		*exclusionCsv,
+		*pathInclusionCsv,
+		*pathExclusionCsv,
+		*inclusionRegex,
+		*exclusionRegex,
+		*minFileSizeBytes,
+		*maxFileSizeBytes,
+		modifiedAfterTime,
+		modifiedBeforeTime)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: file filter is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	if *metadataReadBytes < 0 || (*metadataReadBytes > 0 && !*metadataOnly) {
+		fmt.Fprintf(os.Stderr, "ERROR: metadataReadBytes must not be negative, and requires metadataOnly\n")
+		usage()
+		os.Exit(1)
+	}
+
+	throttleSettings, err := cachewarmer.InitializeThrottleSettings(*maxMBPerSecond, *maxFilesPerSecond, *throttleSchedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: throttle is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	if !cachewarmer.IsValidPriority(*priority) {
+		fmt.Fprintf(os.Stderr, "ERROR: priority '%s' is not valid\n", *priority)
+		usage()
+		os.Exit(1)
+	}
+
+	if len(*fileListPath) > 0 && len(*fileListBlob) > 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: only one of fileListPath or fileListBlob may be specified\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if *distributedEnumeration && (len(*fileListPath) > 0 || len(*fileListBlob) > 0) {
+		fmt.Fprintf(os.Stderr, "ERROR: distributedEnumeration may not be combined with a file list\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if len(*fileListBlob) > 0 && *queueType != cachewarmer.QueueTypeAzure {
+		fmt.Fprintf(os.Stderr, "ERROR: fileListBlob requires the '%s' queue storage account\n", cachewarmer.QueueTypeAzure)
+		usage()
+		os.Exit(1)
+	}
+
+	if *checksum && *metadataOnly {
+		fmt.Fprintf(os.Stderr, "ERROR: checksum may not be combined with metadataOnly\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if len(*referenceChecksumPath) > 0 && !*checksum {
+		fmt.Fprintf(os.Stderr, "ERROR: referenceChecksumPath requires checksum\n")
+		usage()
+		os.Exit(1)
+	}
+	checksumSettings := cachewarmer.ChecksumSettings{
+		Enabled:       *checksum,
+		ReferencePath: *referenceChecksumPath,
+	}
+
+	mountSettings, err := cachewarmer.InitializeMountSettings(*mountType, *mountOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: mount settings are not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	if len(*reportBlobContainer) > 0 && *queueType != cachewarmer.QueueTypeAzure {
+		fmt.Fprintf(os.Stderr, "ERROR: reportBlobContainer requires the '%s' queue storage account\n", cachewarmer.QueueTypeAzure)
+		usage()
+		os.Exit(1)
+	}
+
+	if len(*reportBlobContainer) > 0 {
+		if isValid, errorMessage := azure.ValidateContainerName(*reportBlobContainer); !isValid {
+			fmt.Fprintf(os.Stderr, "ERROR: reportBlobContainer is not valid: %s\n", errorMessage)
+			usage()
+			os.Exit(1)
+		}
+	}
+
+	if len(*manifestBlobContainer) > 0 && *queueType != cachewarmer.QueueTypeAzure {
+		fmt.Fprintf(os.Stderr, "ERROR: manifestBlobContainer requires the '%s' queue storage account\n", cachewarmer.QueueTypeAzure)
+		usage()
+		os.Exit(1)
+	}
+
+	if len(*manifestBlobContainer) > 0 {
+		if isValid, errorMessage := azure.ValidateContainerName(*manifestBlobContainer); !isValid {
+			fmt.Fprintf(os.Stderr, "ERROR: manifestBlobContainer is not valid: %s\n", errorMessage)
+			usage()
+			os.Exit(1)
+		}
+	}
+
+	deadlineTime, err := cachewarmer.ParseDeadline(*deadline, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: deadline is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	if mode == submitMode && !deadlineTime.IsZero() && deadlineTime.Before(time.Now()) {
+		fmt.Fprintf(os.Stderr, "ERROR: deadline '%s' has already passed\n", *deadline)
+		usage()
+		os.Exit(1)
+	}
+
+	if err := cachewarmer.ValidateSymlinkPolicy(*symlinkPolicy); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	jobSettings := cachewarmer.JobSettings{
+		Priority:              *priority,
+		FullWarm:              *fullWarm,
+		ManifestBlobContainer: *manifestBlobContainer,
+		MetadataOnly:          *metadataOnly,
+		MetadataReadBytes:     *metadataReadBytes,
+		Throttle:              throttleSettings,
+		Checksum:              checksumSettings,
+		Mount:                 mountSettings,
+		Deadline:              deadlineTime,
+		SymlinkPolicy:         *symlinkPolicy,
+		FileFilter:            *fileFilter,
+	}
+
+	if mode == statusMode || mode == cancelMode || mode == reportMode {
+		if len(*jobId) == 0 {
+			fmt.Fprintf(os.Stderr, "ERROR: jobId is not specified\n")
+			usage()
+			os.Exit(1)
+		}
+		warmPathJob := cachewarmer.InitializeWarmPathJob(
+			*warmTargetMountAddresses,
+			*warmTargetExportPath,
+			*warmTargetPath,
+			*fileListPath,
+			*fileListBlob,
+			*distributedEnumeration,
+			*reportBlobContainer,
+			jobSettings)
+		warmPathJob.JobID = *jobId
+		cachewarmer.RegisterMountSettings(warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmPathJob.Mount)
+		return mode, warmPathJob, nil, false
+	}
+
+	if mode == submitMode && len(*warmTargetPath) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: warmTargetPath is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*storageAccountResourceGroup) == 0 {
+	if *queueType != cachewarmer.QueueTypeAzure && *queueType != cachewarmer.QueueTypeDirectory {
+		fmt.Fprintf(os.Stderr, "ERROR: queueType '%s' is not valid\n", *queueType)
+		usage()
+		os.Exit(1)
+	}
+
+	if *queueType == cachewarmer.QueueTypeAzure && len(*storageAccountResourceGroup) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: storageAccountResourceGroup is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*storageAccount) == 0 {
+	if *queueType == cachewarmer.QueueTypeAzure && len(*storageAccount) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: storageAccount is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
+	if *queueType == cachewarmer.QueueTypeDirectory && (len(*queueMountAddress) == 0 || len(*queueExportPath) == 0) {
+		fmt.Fprintf(os.Stderr, "ERROR: queueMountAddress and queueExportPath must be specified for the directory queue\n")
+		usage()
+		os.Exit(1)
+	}
+
 	if len(*queueNamePrefix) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: queueNamePrefix is not specified\n")
 		usage()
@@ -95,34 +382,105 @@ func initializeApplicationVariables(ctx context.Context) (*cachewarmer.WarmPathJ
 		os.Exit(1)
 	}
 
-	primaryKey, err := cachewarmer.GetPrimaryStorageKey(ctx, *storageAccountResourceGroup, *storageAccount)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: unable to get storage account key: %s", err)
-		os.Exit(1)
-	}
-
 	warmJobPath := cachewarmer.InitializeWarmPathJob(
 		*warmTargetMountAddresses,
 		*warmTargetExportPath,
 		*warmTargetPath,
-		*inclusionCsv,
-		*exclusionCsv,
-		*maxFileSizeBytes)
+		*fileListPath,
+		*fileListBlob,
+		*distributedEnumeration,
+		*reportBlobContainer,
+		jobSettings)
+	// the submitter mounts the warm target export with the settings of the job
+	cachewarmer.RegisterMountSettings(warmJobPath.WarmTargetMountAddresses, warmJobPath.WarmTargetExportPath, warmJobPath.Mount)
+	if mode == deadLetterMode || mode == queueMode {
+		// the job id filters the dead lettered or listed jobs
+		warmJobPath.JobID = *jobId
+	}
+
+	if mode == submitMode {
+		if err := warmJobPath.ValidateManifest(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			usage()
+			os.Exit(1)
+		}
+		validateReferenceChecksums(warmJobPath)
+	}
+
+	var cacheWarmerQueues *cachewarmer.CacheWarmerQueues
+	if *queueType == cachewarmer.QueueTypeDirectory {
+		if mode == submitMode {
+			validateFileList(ctx, warmJobPath, "", "")
+		}
+		cacheWarmerQueues, err = cachewarmer.InitializeDirectoryCacheWarmerQueues(
+			*queueMountAddress,
+			*queueExportPath,
+			*queueDirectoryPath,
+			*queueNamePrefix)
+	} else {
+		var primaryKey string
+		primaryKey, err = cachewarmer.GetPrimaryStorageKey(ctx, *storageAccountResourceGroup, *storageAccount)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: unable to get storage account key: %s", err)
+			os.Exit(1)
+		}
+
+		if mode == submitMode {
+			validateFileList(ctx, warmJobPath, *storageAccount, primaryKey)
+		}
 
-	cacheWarmerQueues, err := cachewarmer.InitializeCacheWarmerQueues(
-		ctx,
-		*storageAccount,
-		primaryKey,
-		*queueNamePrefix)
+		cacheWarmerQueues, err = cachewarmer.InitializeCacheWarmerQueues(
+			ctx,
+			*storageAccount,
+			primaryKey,
+			*queueNamePrefix)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: error initializing queue %v\n", err)
 		os.Exit(1)
 	}
 
-	return warmJobPath, cacheWarmerQueues, *blockUntilWarm
+	return mode, warmJobPath, cacheWarmerQueues, *blockUntilWarm
 }
 
-func BlockUntilWarm(ctx context.Context, syncWaitGroup *sync.WaitGroup, cacheWarmerQueues *cachewarmer.CacheWarmerQueues) {
+// validateFileList reads and parses the file list of the job before it is submitted, so a missing or malformed
+// file list is reported here rather than retried by the manager
+func validateFileList(ctx context.Context, warmPathJob *cachewarmer.WarmPathJob, storageAccount string, storageKey string) {
+	var entries []cachewarmer.FileListEntry
+	var err error
+	if len(warmPathJob.FileListPath) > 0 {
+		entries, err = cachewarmer.ReadFileListFromExport(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.FileListPath)
+	} else if len(warmPathJob.FileListBlob) > 0 {
+		entries, err = cachewarmer.ReadFileListFromBlob(ctx, storageAccount, storageKey, warmPathJob.FileListBlob)
+	} else {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: file list is not valid: %v\n", err)
+		os.Exit(1)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: file list is empty\n")
+		os.Exit(1)
+	}
+	log.Info.Printf("file list has %d entries", len(entries))
+}
+
+// validateReferenceChecksums reads and parses the reference checksums of the job before it is submitted, since the
+// workers only log a reference they cannot read
+func validateReferenceChecksums(warmPathJob *cachewarmer.WarmPathJob) {
+	if len(warmPathJob.Checksum.ReferencePath) == 0 {
+		return
+	}
+	checksums, err := cachewarmer.ReadChecksumsFromExport(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.Checksum.ReferencePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: reference checksums are not valid: %v\n", err)
+		os.Exit(1)
+	}
+	log.Info.Printf("reference has %d checksums", len(checksums))
+}
+
+func BlockUntilWarm(ctx context.Context, syncWaitGroup *sync.WaitGroup, warmPathJob *cachewarmer.WarmPathJob) {
 	defer syncWaitGroup.Done()
 	log.Debug.Printf("[BlockUntilWarm")
 	defer log.Debug.Printf("BlockUntilWarm]")
@@ -131,7 +489,6 @@ func BlockUntilWarm(ctx context.Context, syncWaitGroup *sync.WaitGroup, cacheWar
 	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
-	jobDirectoryEmpty := false
 	for {
 		select {
 		case <-ctx.Done():
@@ -139,48 +496,322 @@ func BlockUntilWarm(ctx context.Context, syncWaitGroup *sync.WaitGroup, cacheWar
 		case <-ticker.C:
 			if time.Since(lastCheckTime) > timeBetweenBlockCheck {
 				lastCheckTime = time.Now()
-				if !jobDirectoryEmpty {
-					if isEmpty, err := cacheWarmerQueues.IsJobQueueEmpty(); err != nil {
-						log.Error.Printf("error checking if job queue was empty: %v", err)
-					} else if isEmpty == true {
-						log.Status.Printf("job directory empty, now checking worker job directory")
-						jobDirectoryEmpty = true
-					}
-				} else {
-					if isEmpty, err := cacheWarmerQueues.IsWorkQueueEmpty(); err != nil {
-						log.Error.Printf("error checking if work queue was empty: %v", err)
-					} else if isEmpty == true {
-						log.Status.Printf("warming complete")
-						return
-					}
+				status, err := cachewarmer.ReadJobStatus(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.JobID)
+				if err != nil {
+					log.Error.Printf("error reading job status: %v", err)
+					continue
+				}
+				log.Status.Printf("%s", status)
+				if status.IsFailed() {
+					log.Status.Printf("job failed")
+					return
+				}
+				if status.IsComplete() {
+					log.Status.Printf("warming complete")
+					return
+				}
+				if status.Cancelled {
+					log.Status.Printf("job cancelled")
+					return
 				}
 			}
 		}
 	}
 }
 
+func printJobStatus(warmPathJob *cachewarmer.WarmPathJob) {
+	status, err := cachewarmer.ReadJobStatus(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.JobID)
+	if err != nil {
+		log.Error.Printf("ERROR: encountered error while reading job status: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", status)
+	if status.ChecksumMismatches > 0 {
+		mismatches, err := cachewarmer.ReadChecksumMismatches(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.JobID)
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while reading checksum mismatches: %v", err)
+		}
+		for _, mismatch := range mismatches {
+			fmt.Printf("checksum mismatch %s: sha256 %s, expected %s\n", mismatch.Path, mismatch.SHA256, mismatch.ExpectedSHA256)
+		}
+	}
+	if status.HasFailures() || status.Cancelled {
+		os.Exit(1)
+	}
+}
+
+// writeJobReport writes the report of the job to the warm target export, and prints the json report
+func writeJobReport(warmPathJob *cachewarmer.WarmPathJob) {
+	status, err := cachewarmer.ReadJobStatus(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.JobID)
+	if err != nil {
+		log.Error.Printf("ERROR: encountered error while reading job status: %v", err)
+		os.Exit(1)
+	}
+	if !status.IsStarted() {
+		log.Error.Printf("ERROR: job '%s' has not been started", warmPathJob.JobID)
+		os.Exit(1)
+	}
+	report := cachewarmer.InitializeJobReport(warmPathJob.WarmTargetExportPath, warmPathJob.WarmTargetPath, status)
+	if err := cachewarmer.WriteJobReport(warmPathJob.WarmTargetMountAddresses[0], report); err != nil {
+		log.Error.Printf("ERROR: encountered error while writing job report: %v", err)
+		os.Exit(1)
+	}
+	data, err := report.GetJsonContents()
+	if err != nil {
+		log.Error.Printf("ERROR: encountered error while formatting job report: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", data)
+}
+
+// processDeadLetterJobs lists, requeues, or purges the dead lettered worker jobs, filtered by job id if specified
+func processDeadLetterJobs(cacheWarmerQueues *cachewarmer.CacheWarmerQueues, action string, jobID string) {
+	if action == deadLetterList {
+		listDeadLetterJobs(cacheWarmerQueues, jobID)
+		return
+	}
+
+	// a requeued worker job is no longer counted as dead lettered in the job progress
+	progress := cachewarmer.InitializeProgressTracker(cachewarmer.GetWorkerProgressWriter())
+	defer progress.Flush()
+
+	processedCount := 0
+	for {
+		deadLetterJob, err := cacheWarmerQueues.GetDeadLetterJob(deadLetterVisibilityTimeout)
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while reading dead letter queue: %v", err)
+			os.Exit(1)
+		}
+		if deadLetterJob == nil {
+			break
+		}
+		if len(jobID) > 0 && deadLetterJob.JobID != jobID {
+			// leave it for the visibility timeout to expire
+			continue
+		}
+
+		switch action {
+		case deadLetterRequeue:
+			if err := cacheWarmerQueues.RequeueDeadLetterJob(deadLetterJob); err != nil {
+				log.Error.Printf("ERROR: encountered error while requeueing dead letter job: %v", err)
+				continue
+			}
+			if workerJob, err := cachewarmer.InitializeWorkerJobFromString(deadLetterJob.MessageText); err == nil {
+				progress.Register(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath)
+				progress.Update(workerJob.JobID, func(p *cachewarmer.JobProgress) { p.WorkerJobsDeadLettered-- })
+			}
+		case deadLetterPurge:
+			if err := cacheWarmerQueues.DeleteDeadLetterJob(deadLetterJob); err != nil {
+				log.Error.Printf("ERROR: encountered error while purging dead letter job: %v", err)
+				continue
+			}
+		}
+		processedCount++
+	}
+
+	log.Status.Printf("%s: %d dead letter jobs", action, processedCount)
+}
+
+// listDeadLetterJobs prints the visible dead letter jobs as json lines.  The jobs are peeked, so they stay visible and
+// their dequeue count is unchanged.
+func listDeadLetterJobs(cacheWarmerQueues *cachewarmer.CacheWarmerQueues, jobID string) {
+	deadLetterJobs, err := cacheWarmerQueues.PeekDeadLetterJobs(cachewarmer.MaximumMessagesToPeek)
+	if err != nil {
+		log.Error.Printf("ERROR: encountered error while reading dead letter queue: %v", err)
+		os.Exit(1)
+	}
+	listedCount := 0
+	for _, deadLetterJob := range deadLetterJobs {
+		if len(jobID) > 0 && deadLetterJob.JobID != jobID {
+			continue
+		}
+		content, err := deadLetterJob.GetDeadLetterJobFileContents()
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while formatting dead letter job: %v", err)
+			continue
+		}
+		fmt.Printf("%s\n", content)
+		listedCount++
+	}
+	log.Status.Printf("%s: %d dead letter jobs", deadLetterList, listedCount)
+}
+
+// processQueue prints the queue depths, lists or purges the job or work queues, or requeues the jobs read from stdin
+func processQueue(cacheWarmerQueues *cachewarmer.CacheWarmerQueues, action string, queueKind string, jobID string) {
+	switch action {
+	case queueDepth:
+		depths, err := cacheWarmerQueues.GetQueueDepths()
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while reading queue depths: %v", err)
+			os.Exit(1)
+		}
+		for _, depth := range depths {
+			if len(depth.Priority) == 0 {
+				fmt.Printf("%s: %d\n", depth.QueueSuffix, depth.Depth)
+			} else {
+				fmt.Printf("%s %s: %d\n", depth.QueueSuffix, depth.Priority, depth.Depth)
+			}
+		}
+	case queueList:
+		listQueue(cacheWarmerQueues, queueKind, jobID)
+	case queuePurge:
+		var err error
+		if queueKind == queueJob {
+			err = cacheWarmerQueues.PurgeJobQueues()
+		} else {
+			err = cacheWarmerQueues.PurgeWorkQueues()
+		}
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while purging queues: %v", err)
+			os.Exit(1)
+		}
+		log.Status.Printf("purged the %s queues", queueKind)
+	case queueRequeue:
+		requeueJobs(cacheWarmerQueues, queueKind)
+	}
+}
+
+// listQueue prints the visible jobs of the job or work queues as json lines, filtered by job id if specified.  The
+// jobs are peeked, so they stay in the queue.
+func listQueue(cacheWarmerQueues *cachewarmer.CacheWarmerQueues, queueKind string, jobID string) {
+	listedCount := 0
+	if queueKind == queueJob {
+		warmPathJobs, err := cacheWarmerQueues.PeekWarmPathJobs(cachewarmer.MaximumMessagesToPeek)
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while reading job queue: %v", err)
+			os.Exit(1)
+		}
+		for _, warmPathJob := range warmPathJobs {
+			if len(jobID) > 0 && warmPathJob.JobID != jobID {
+				continue
+			}
+			content, err := warmPathJob.GetWarmPathJobFileContents()
+			if err != nil {
+				log.Error.Printf("ERROR: encountered error while formatting job: %v", err)
+				continue
+			}
+			fmt.Printf("%s\n", content)
+			listedCount++
+		}
+	} else {
+		workerJobs, err := cacheWarmerQueues.PeekWorkerJobs(cachewarmer.MaximumMessagesToPeek)
+		if err != nil {
+			log.Error.Printf("ERROR: encountered error while reading work queue: %v", err)
+			os.Exit(1)
+		}
+		for _, workerJob := range workerJobs {
+			if len(jobID) > 0 && workerJob.JobID != jobID {
+				continue
+			}
+			content, err := workerJob.GetWorkerJobFileContents()
+			if err != nil {
+				log.Error.Printf("ERROR: encountered error while formatting worker job: %v", err)
+				continue
+			}
+			fmt.Printf("%s\n", content)
+			listedCount++
+		}
+	}
+	log.Status.Printf("%s: %d %s queue jobs", queueList, listedCount, queueKind)
+}
+
+// requeueJobs writes each json line job read from stdin, such as those printed by listQueue, to the job or work queue
+// of its priority
+func requeueJobs(cacheWarmerQueues *cachewarmer.CacheWarmerQueues, queueKind string) {
+	requeuedCount := 0
+	scanner := bufio.NewScanner(os.Stdin)
+	// a worker job with a file list is up to the queue message limit
+	scanner.Buffer(make([]byte, 0, 64*cachewarmer.KB), 128*cachewarmer.KB)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if queueKind == queueJob {
+			warmPathJob, err := cachewarmer.InitializeWarmPathJobFromString(line)
+			if err != nil {
+				log.Error.Printf("ERROR: encountered error while parsing job '%s': %v", line, err)
+				continue
+			}
+			if err := cacheWarmerQueues.WriteWarmPathJob(*warmPathJob); err != nil {
+				log.Error.Printf("ERROR: encountered error while requeueing job: %v", err)
+				continue
+			}
+		} else {
+			workerJob, err := cachewarmer.InitializeWorkerJobFromString(line)
+			if err != nil {
+				log.Error.Printf("ERROR: encountered error while parsing worker job '%s': %v", line, err)
+				continue
+			}
+			if err := cacheWarmerQueues.WriteWorkerJob(workerJob); err != nil {
+				log.Error.Printf("ERROR: encountered error while requeueing worker job: %v", err)
+				continue
+			}
+		}
+		requeuedCount++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error.Printf("ERROR: encountered error while reading stdin: %v", err)
+		os.Exit(1)
+	}
+	log.Status.Printf("%s: %d %s queue jobs", queueRequeue, requeuedCount, queueKind)
+}
+
 func main() {
 	// setup the shared context
 	ctx := context.Background()
 
 	// initialize the variables
-	warmPathJob, cacheWarmerQueues, blockUntilWarm := initializeApplicationVariables(ctx)
+	mode, warmPathJob, cacheWarmerQueues, blockUntilWarm := initializeApplicationVariables(ctx)
+
+	if mode == statusMode {
+		printJobStatus(warmPathJob)
+		return
+	}
+
+	if mode == reportMode {
+		writeJobReport(warmPathJob)
+		return
+	}
+
+	if mode == cancelMode {
+		if err := cachewarmer.CancelJob(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.JobID); err != nil {
+			log.Error.Printf("ERROR: encountered error while cancelling job: %v", err)
+			os.Exit(1)
+		}
+		log.Status.Printf("cancelled job '%s'", warmPathJob.JobID)
+		return
+	}
+
+	if mode == deadLetterMode {
+		processDeadLetterJobs(cacheWarmerQueues, flag.Arg(1), warmPathJob.JobID)
+		return
+	}
+
+	if mode == queueMode {
+		processQueue(cacheWarmerQueues, flag.Arg(1), flag.Arg(2), warmPathJob.JobID)
+		return
+	}
 
 	// write the job to the warm path
 	if err := cacheWarmerQueues.WriteWarmPathJob(*warmPathJob); err != nil {
 		log.Error.Printf("ERROR: encountered error while writing job: %v", err)
 		os.Exit(1)
 	}
+	log.Status.Printf("submitted job '%s'", warmPathJob.JobID)
+	fmt.Printf("%s\n", warmPathJob.JobID)
 
 	if blockUntilWarm {
 		// initialize the sync wait group
 		syncWaitGroup := sync.WaitGroup{}
 
 		syncWaitGroup.Add(1)
-		go BlockUntilWarm(ctx, &syncWaitGroup, cacheWarmerQueues)
+		go BlockUntilWarm(ctx, &syncWaitGroup, warmPathJob)
 
 		log.Info.Printf("Waiting for all processes to finish")
 		syncWaitGroup.Wait()
+
+		printJobStatus(warmPathJob)
 	}
 
 	log.Status.Printf("job submitter finished")
@@ -21,6 +21,7 @@ func usage(errs ...error) {
 	}
 	fmt.Fprintf(os.Stderr, "usage: %s [OPTIONS]\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "       the manager that watches for warm jobs, and starts VMSS to handle the warm path worker jobs\n")
+	fmt.Fprintf(os.Stderr, "       with -staticWorkerPool, no VMSS is managed and the worker jobs are processed by workers started separately\n")
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "optional env vars (alternatively comes from IMDS):\n")
 	fmt.Fprintf(os.Stderr, "\t%s - Account AD Tenant ID\n", azure.AZURE_TENANT_ID)
@@ -31,21 +32,44 @@ func usage(errs ...error) {
 	flag.PrintDefaults()
 }
 
-func initializeApplicationVariables(ctx context.Context) *cachewarmer.WarmPathManager {
+func initializeApplicationVariables(ctx context.Context) (*cachewarmer.WarmPathManager, *cachewarmer.JobScheduler, *cachewarmer.QueueSasRotator, cachewarmer.Lease, bool, string) {
 	var enableDebugging = flag.Bool("enableDebugging", false, "enable debug logging")
+	var metricsAddress = flag.String("metricsAddress", "", "the address, for example ':9100', to serve Prometheus metrics at /metrics.  Leave blank to not serve metrics.")
+	var staticWorkerPool = flag.Bool("staticWorkerPool", false, "only run the job generator, and do not create or delete the worker VMSS.  The bootstrap and vmss options are ignored")
 	var bootstrapMountAddress = flag.String("bootstrapMountAddress", "", "the mount address that hosts the worker bootstrap script")
 	var bootstrapExportPath = flag.String("bootstrapExportPath", "", "the export path that hosts the worker bootstrap script")
 	var bootstrapScriptPath = flag.String("bootstrapScriptPath", "", "the path to the worker bootstrap script")
-	var workerCount = flag.Int64("workerCount", 12, "the worker count to warm the cache")
+	var workerCount = flag.Int64("workerCount", 12, "the maximum worker count to warm the cache, the VMSS capacity is scaled by the work queue depth")
+	var minWorkerCount = flag.Int64("minWorkerCount", 0, "the minimum worker count kept running, 0 deletes the VMSS when there is no work")
+	var workerMetricsAddress = flag.String("workerMetricsAddress", "", "the address, for example ':9100', for the VMSS workers to serve Prometheus metrics at /metrics.  Leave blank to not serve metrics.")
+	var mountOptions = flag.String("mountOptions", "", fmt.Sprintf("(optional) the nfs mount options of the queue export, the workers, and the jobs submitted without mount settings, for example 'vers=4.1,nconnect=8'.  Leave blank for '%s'.", cachewarmer.DefaultNfsMountOptions))
+	var scheduleFile = flag.String("scheduleFile", "", "(optional) the json file of warm jobs submitted on cron schedules, re-read when it changes")
+	var leaseType = flag.String("leaseType", "", fmt.Sprintf("(optional) the lease held by the leading manager of the queue name prefix, '%s' in the storage account, '%s' on the directory queue or bootstrap export, or '%s' to always lead.  Leave blank for '%s' with the azure queues and '%s' with the directory queues.", cachewarmer.LeaseTypeBlob, cachewarmer.LeaseTypeFile, cachewarmer.LeaseTypeNone, cachewarmer.LeaseTypeBlob, cachewarmer.LeaseTypeFile))
 
+	var queueType = flag.String("queueType", cachewarmer.QueueTypeAzure, fmt.Sprintf("the queue backend, either '%s' or '%s'", cachewarmer.QueueTypeAzure, cachewarmer.QueueTypeDirectory))
 	var storageAccountResourceGroup = flag.String("storageAccountResourceGroup", "", "the storage account resource group")
 	var storageAccount = flag.String("storageAccountName", "", "the storage account name to host the queue")
 	var queueNamePrefix = flag.String("queueNamePrefix", "", "the queue name to be used for organizing the work. The queues will be created automatically")
+	var queueMountAddress = flag.String("queueMountAddress", "", "the mount address of the NFS export hosting the directory queues")
+	var queueExportPath = flag.String("queueExportPath", "", "the NFS export path hosting the directory queues")
+	var queueDirectoryPath = flag.String("queueDirectoryPath", cachewarmer.DefaultQueueDirectoryPath, "the path on the NFS export of the directory queues")
 
 	var vmssUserName = flag.String("vmssUserName", "", "the username for the vmss vms")
 	var vmssPassword = flag.String("vmssPassword", "", "(optional) the password for the vmss vms, this is unused if the public key is specified")
 	var vmssSshPublicKey = flag.String("vmssSshPublicKey", "", "(optional) the ssh public key for the vmss vms, this will be used by default, however if this is blank, the password will be used")
 	var vmssSubnetName = flag.String("vmssSubnetName", "", "(optional) the subnet to use for the VMSS, if not specified use the same subnet as the controller")
+	var vmssSku = flag.String("vmssSku", cachewarmer.VMSSNodeSize, "the VM SKU of the VMSS workers")
+	var vmssImageId = flag.String("vmssImageId", "", "(optional) the resource ID of a custom image or shared image gallery image version for the VMSS workers")
+	var vmssImage = flag.String("vmssImage", "", fmt.Sprintf("(optional) the marketplace image 'publisher:offer:sku[:version]' for the VMSS workers, if neither the image nor the image ID is specified use '%s:%s:%s' with its plan", cachewarmer.MarketPlacePublisher, cachewarmer.MarketPlaceOffer, cachewarmer.MarketPlaceSku))
+	var vmssPlan = flag.String("vmssPlan", "", "(optional) the purchase plan 'name:publisher:product' of the VMSS image")
+	var vmssPriority = flag.String("vmssPriority", cachewarmer.VmssPrioritySpot, fmt.Sprintf("the priority of the VMSS workers, either '%s' or '%s'", cachewarmer.VmssPrioritySpot, cachewarmer.VmssPriorityRegular))
+	var vmssMaxPrice = flag.Float64("vmssMaxPrice", cachewarmer.VmssDefaultMaxPrice, "the maximum hourly price in US dollars of a spot VMSS worker, -1 pays up to the regular price")
+	var vmssAcceleratedNetworking = flag.Bool("vmssAcceleratedNetworking", false, "enable accelerated networking on the VMSS workers, the SKU must support it")
+	var vmssZones = flag.String("vmssZones", "", "(optional) the comma separated availability zones to place the VMSS workers, for example '1,2,3'")
+	var vmssOsDiskSizeGB = flag.Int64("vmssOsDiskSizeGB", 0, "(optional) the os disk size of the VMSS workers, 0 uses the image size")
+	var vmssOsDiskType = flag.String("vmssOsDiskType", "", "(optional) the os disk type of the VMSS workers, one of 'Standard_LRS', 'StandardSSD_LRS', or 'Premium_LRS'")
+	var vmssUserAssignedIdentity = flag.String("vmssUserAssignedIdentity", "", fmt.Sprintf("(optional) the resource ID of the user assigned identity of the VMSS workers, required for the '%s' worker queue authorization", cachewarmer.QueueAuthIdentity))
+	var workerQueueAuth = flag.String("workerQueueAuth", cachewarmer.QueueAuthSas, fmt.Sprintf("the queue authorization of the VMSS workers, either '%s' for queue SAS tokens rotated by the manager on the bootstrap export, or '%s' for the user assigned identity of the VMSS", cachewarmer.QueueAuthSas, cachewarmer.QueueAuthIdentity))
 
 	flag.Parse()
 
@@ -53,36 +77,60 @@ func initializeApplicationVariables(ctx context.Context) *cachewarmer.WarmPathMa
 		log.EnableDebugging()
 	}
 
-	if len(*bootstrapMountAddress) == 0 {
+	if !*staticWorkerPool && len(*bootstrapMountAddress) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: bootstrapMountAddress is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*bootstrapExportPath) == 0 {
+	if !*staticWorkerPool && len(*bootstrapExportPath) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: bootstrapExportPath is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*bootstrapScriptPath) == 0 {
+	if !*staticWorkerPool && len(*bootstrapScriptPath) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: bootstrapScriptPath is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*storageAccountResourceGroup) == 0 {
+	if *workerCount < 1 {
+		fmt.Fprintf(os.Stderr, "ERROR: workerCount must be at least 1\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if *minWorkerCount < 0 || *minWorkerCount > *workerCount {
+		fmt.Fprintf(os.Stderr, "ERROR: minWorkerCount must be between 0 and workerCount\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if *queueType != cachewarmer.QueueTypeAzure && *queueType != cachewarmer.QueueTypeDirectory {
+		fmt.Fprintf(os.Stderr, "ERROR: queueType '%s' is not valid\n", *queueType)
+		usage()
+		os.Exit(1)
+	}
+
+	if *queueType == cachewarmer.QueueTypeAzure && len(*storageAccountResourceGroup) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: storageAccountResourceGroup is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*storageAccount) == 0 {
+	if *queueType == cachewarmer.QueueTypeAzure && len(*storageAccount) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: storageAccount is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
+	if *queueType == cachewarmer.QueueTypeDirectory && (len(*queueMountAddress) == 0 || len(*queueExportPath) == 0) {
+		fmt.Fprintf(os.Stderr, "ERROR: queueMountAddress and queueExportPath must be specified for the directory queue\n")
+		usage()
+		os.Exit(1)
+	}
+
 	if len(*queueNamePrefix) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: queueNamePrefix is not specified\n")
 		usage()
@@ -95,43 +143,176 @@ func initializeApplicationVariables(ctx context.Context) *cachewarmer.WarmPathMa
 		os.Exit(1)
 	}
 
-	if len(*vmssUserName) == 0 {
+	if len(*leaseType) == 0 && *queueType == cachewarmer.QueueTypeDirectory {
+		*leaseType = cachewarmer.LeaseTypeFile
+	} else if len(*leaseType) == 0 {
+		*leaseType = cachewarmer.LeaseTypeBlob
+	}
+
+	if *leaseType != cachewarmer.LeaseTypeBlob && *leaseType != cachewarmer.LeaseTypeFile && *leaseType != cachewarmer.LeaseTypeNone {
+		fmt.Fprintf(os.Stderr, "ERROR: leaseType '%s' is not valid\n", *leaseType)
+		usage()
+		os.Exit(1)
+	}
+
+	if *leaseType == cachewarmer.LeaseTypeBlob && *queueType != cachewarmer.QueueTypeAzure {
+		fmt.Fprintf(os.Stderr, "ERROR: the '%s' lease requires the '%s' queues\n", cachewarmer.LeaseTypeBlob, cachewarmer.QueueTypeAzure)
+		usage()
+		os.Exit(1)
+	}
+
+	if *leaseType == cachewarmer.LeaseTypeFile && *queueType != cachewarmer.QueueTypeDirectory && (len(*bootstrapMountAddress) == 0 || len(*bootstrapExportPath) == 0) {
+		fmt.Fprintf(os.Stderr, "ERROR: the '%s' lease requires the directory queues, or bootstrapMountAddress and bootstrapExportPath\n", cachewarmer.LeaseTypeFile)
+		usage()
+		os.Exit(1)
+	}
+
+	if !*staticWorkerPool && len(*vmssUserName) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: userName for the VMSS is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*vmssPassword) == 0 && len(*vmssSshPublicKey) == 0 {
+	if !*staticWorkerPool && len(*vmssPassword) == 0 && len(*vmssSshPublicKey) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: either password or sshPublicKey must be specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	azureClients, err := cachewarmer.InitializeAzureClients()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: unable to initialize Azure Clients: %s", err)
+	var vmssSettings cachewarmer.VmssSettings
+	var err error
+	if !*staticWorkerPool {
+		vmssSettings, err = cachewarmer.InitializeVmssSettings(
+			*vmssSku,
+			*vmssImageId,
+			*vmssImage,
+			*vmssPlan,
+			*vmssPriority,
+			*vmssMaxPrice,
+			*vmssAcceleratedNetworking,
+			*vmssZones,
+			*vmssOsDiskSizeGB,
+			*vmssOsDiskType,
+			*vmssUserAssignedIdentity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: vmss settings are not valid: %v\n", err)
+			usage()
+			os.Exit(1)
+		}
+	}
+
+	// the workers of the directory queues do not authorize to Azure Storage
+	if *queueType == cachewarmer.QueueTypeDirectory {
+		*workerQueueAuth = ""
+	}
+
+	if !*staticWorkerPool && *queueType == cachewarmer.QueueTypeAzure && *workerQueueAuth != cachewarmer.QueueAuthSas && *workerQueueAuth != cachewarmer.QueueAuthIdentity {
+		fmt.Fprintf(os.Stderr, "ERROR: workerQueueAuth '%s' is neither '%s' nor '%s'\n", *workerQueueAuth, cachewarmer.QueueAuthSas, cachewarmer.QueueAuthIdentity)
+		usage()
 		os.Exit(1)
 	}
 
-	primaryKey, err := cachewarmer.GetPrimaryStorageKey(ctx, *storageAccountResourceGroup, *storageAccount)
+	if !*staticWorkerPool && *workerQueueAuth == cachewarmer.QueueAuthIdentity && len(*vmssUserAssignedIdentity) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: vmssUserAssignedIdentity must be specified for the '%s' worker queue authorization\n", cachewarmer.QueueAuthIdentity)
+		usage()
+		os.Exit(1)
+	}
+
+	// the default settings must be set before the queue export is mounted
+	mountSettings, err := cachewarmer.InitializeMountSettings("", *mountOptions)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: unable to get storage account key: %s", err)
+		fmt.Fprintf(os.Stderr, "ERROR: mountOptions is not valid: %v\n", err)
+		usage()
 		os.Exit(1)
 	}
+	cachewarmer.SetDefaultMountSettings(mountSettings)
 
-	cacheWarmerQueues, err := cachewarmer.InitializeCacheWarmerQueues(
-		ctx,
-		*storageAccount,
-		primaryKey,
-		*queueNamePrefix)
+	// the static worker pool does not require the Azure clients or instance metadata
+	var azureClients *cachewarmer.AzureClients
+	if !*staticWorkerPool {
+		azureClients, err = cachewarmer.InitializeAzureClients()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: unable to initialize Azure Clients: %s", err)
+			os.Exit(1)
+		}
+
+		if err := cachewarmer.ValidateVmssSettings(ctx, azureClients, vmssSettings); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: vmss settings are not valid: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	primaryKey := ""
+	var cacheWarmerQueues *cachewarmer.CacheWarmerQueues
+	if *queueType == cachewarmer.QueueTypeDirectory {
+		cacheWarmerQueues, err = cachewarmer.InitializeDirectoryCacheWarmerQueues(
+			*queueMountAddress,
+			*queueExportPath,
+			*queueDirectoryPath,
+			*queueNamePrefix)
+	} else {
+		primaryKey, err = cachewarmer.GetPrimaryStorageKey(ctx, *storageAccountResourceGroup, *storageAccount)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: unable to get storage account key: %s", err)
+			os.Exit(1)
+		}
+
+		cacheWarmerQueues, err = cachewarmer.InitializeCacheWarmerQueues(
+			ctx,
+			*storageAccount,
+			primaryKey,
+			*queueNamePrefix)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: error initializing queue %v\n", err)
 		os.Exit(1)
 	}
 
+	// the first queue SAS tokens are written before the workers are created, a static worker pool only uses the tokens
+	// if the bootstrap export is specified
+	var queueSasRotator *cachewarmer.QueueSasRotator
+	if *workerQueueAuth == cachewarmer.QueueAuthSas && len(*bootstrapMountAddress) > 0 && len(*bootstrapExportPath) > 0 {
+		queueSasRotator = cachewarmer.InitializeQueueSasRotator(
+			*storageAccount,
+			primaryKey,
+			*queueNamePrefix,
+			*bootstrapMountAddress,
+			*bootstrapExportPath)
+		if err := queueSasRotator.Rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: error writing the queue SAS %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// the lease is held by one manager of the queue name prefix, the lease file is on the export shared by the managers
+	var lease cachewarmer.Lease
+	switch {
+	case *leaseType == cachewarmer.LeaseTypeBlob:
+		lease, err = cachewarmer.InitializeBlobLease(ctx, *storageAccount, primaryKey, *queueNamePrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: error initializing the manager lease %v\n", err)
+			os.Exit(1)
+		}
+	case *leaseType == cachewarmer.LeaseTypeFile && *queueType == cachewarmer.QueueTypeDirectory:
+		lease = cachewarmer.InitializeFileLease(*queueMountAddress, *queueExportPath, *queueNamePrefix)
+	case *leaseType == cachewarmer.LeaseTypeFile:
+		lease = cachewarmer.InitializeFileLease(*bootstrapMountAddress, *bootstrapExportPath, *queueNamePrefix)
+	}
+
+	var jobScheduler *cachewarmer.JobScheduler
+	if len(*scheduleFile) > 0 {
+		jobScheduler, err = cachewarmer.InitializeJobScheduler(cacheWarmerQueues, *scheduleFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: scheduleFile is not valid: %v\n", err)
+			usage()
+			os.Exit(1)
+		}
+	}
+
 	return cachewarmer.InitializeWarmPathManager(
 		azureClients,
 		*workerCount,
+		*minWorkerCount,
 		cacheWarmerQueues,
 		*bootstrapMountAddress,
 		*bootstrapExportPath,
@@ -140,10 +321,17 @@ func initializeApplicationVariables(ctx context.Context) *cachewarmer.WarmPathMa
 		*vmssPassword,
 		*vmssSshPublicKey,
 		*vmssSubnetName,
+		vmssSettings,
 		*storageAccount,
 		primaryKey,
+		*workerQueueAuth,
 		*queueNamePrefix,
-	)
+		*queueType,
+		*queueMountAddress,
+		*queueExportPath,
+		*queueDirectoryPath,
+		*workerMetricsAddress,
+	), jobScheduler, queueSasRotator, lease, *staticWorkerPool, *metricsAddress
 }
 
 func main() {
@@ -151,20 +339,52 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// initialize the variables
-	warmPathManager := initializeApplicationVariables(ctx)
+	warmPathManager, jobScheduler, queueSasRotator, lease, staticWorkerPool, metricsAddress := initializeApplicationVariables(ctx)
 
 	// initialize the sync wait group
 	syncWaitGroup := sync.WaitGroup{}
 
 	log.Status.Printf("cachewarmer manager started")
 
-	log.Status.Printf("create job generator")
-	syncWaitGroup.Add(1)
-	go warmPathManager.RunJobGenerator(ctx, &syncWaitGroup)
+	// only the leading manager of the queue name prefix runs the job generator and manages the vmss
+	lead := func(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+		log.Status.Printf("create job generator")
+		syncWaitGroup.Add(1)
+		go warmPathManager.RunJobGenerator(ctx, syncWaitGroup)
 
-	log.Status.Printf("create the vmss manager")
-	syncWaitGroup.Add(1)
-	go warmPathManager.RunVMSSManager(ctx, &syncWaitGroup)
+		if staticWorkerPool {
+			log.Status.Printf("using a static worker pool, the vmss is not managed")
+		} else {
+			log.Status.Printf("create the vmss manager")
+			syncWaitGroup.Add(1)
+			go warmPathManager.RunVMSSManager(ctx, syncWaitGroup)
+		}
+
+		if queueSasRotator != nil {
+			log.Status.Printf("create the queue SAS rotator")
+			syncWaitGroup.Add(1)
+			go queueSasRotator.RunQueueSasRotator(ctx, syncWaitGroup)
+		}
+
+		if jobScheduler != nil {
+			log.Status.Printf("create the job scheduler")
+			syncWaitGroup.Add(1)
+			go jobScheduler.RunScheduler(ctx, syncWaitGroup)
+		}
+	}
+
+	if lease == nil {
+		lead(ctx, &syncWaitGroup)
+	} else {
+		log.Status.Printf("create the leader election")
+		syncWaitGroup.Add(1)
+		go cachewarmer.InitializeLeaderElector(lease, lead).RunLeaderElection(ctx, &syncWaitGroup)
+	}
+
+	if len(metricsAddress) > 0 {
+		syncWaitGroup.Add(1)
+		go cachewarmer.RunMetricsServer(ctx, &syncWaitGroup, metricsAddress)
+	}
 
 	log.Info.Printf("wait for ctrl-c")
 	// wait on ctrl-c
@@ -26,12 +26,27 @@ func usage(errs ...error) {
 	flag.PrintDefaults()
 }
 
-func initializeApplicationVariables(ctx context.Context) *cachewarmer.Worker {
+func initializeApplicationVariables(ctx context.Context) (*cachewarmer.Worker, *cachewarmer.QueueSasReader, string) {
 	var enableDebugging = flag.Bool("enableDebugging", false, "enable debug logging")
+	var metricsAddress = flag.String("metricsAddress", "", "the address, for example ':9100', to serve Prometheus metrics at /metrics.  Leave blank to not serve metrics.")
+	var queueType = flag.String("queueType", cachewarmer.QueueTypeAzure, fmt.Sprintf("the queue backend, either '%s' or '%s'", cachewarmer.QueueTypeAzure, cachewarmer.QueueTypeDirectory))
 	var storageAccountResourceGroup = flag.String("storageAccountResourceGroup", "", "the storage account resource group")
 	var storageAccount = flag.String("storageAccountName", "", "the storage account name to host the queue")
 	var storageKey = flag.String("storageKey", "", "the storage key to access the queue")
+	var useManagedIdentity = flag.Bool("useManagedIdentity", false, "authorize to the queues with the managed identity of the VM, instead of the storage key")
+	var managedIdentityClientId = flag.String("managedIdentityClientId", "", "(optional) the client ID of the user assigned identity, if the VM has more than one identity")
+	var queueSasMountAddress = flag.String("queueSasMountAddress", "", "the mount address of the NFS export with the queue SAS tokens written by the manager, to authorize to the queues instead of the storage key")
+	var queueSasExportPath = flag.String("queueSasExportPath", "", "the NFS export path with the queue SAS tokens written by the manager")
 	var queueNamePrefix = flag.String("queueNamePrefix", "", "the queue name to be used for organizing the work. The queues will be created automatically")
+	var queueMountAddress = flag.String("queueMountAddress", "", "the mount address of the NFS export hosting the directory queues")
+	var queueExportPath = flag.String("queueExportPath", "", "the NFS export path hosting the directory queues")
+	var queueDirectoryPath = flag.String("queueDirectoryPath", cachewarmer.DefaultQueueDirectoryPath, "the path on the NFS export of the directory queues")
+	var mountOptions = flag.String("mountOptions", "", fmt.Sprintf("(optional) the nfs mount options of the queue export and the jobs submitted without mount settings.  Leave blank for '%s'.", cachewarmer.DefaultNfsMountOptions))
+	var maxDequeueCount = flag.Int64("maxDequeueCount", cachewarmer.DefaultMaxDequeueCount, "the number of times a worker job may fail before it is moved to the dead letter queue")
+	var maxMBPerSecond = flag.Int64("maxMBPerSecond", 0, "the maximum MB per second read by this worker across all jobs, 0 is unlimited")
+	var maxFilesPerSecond = flag.Int64("maxFilesPerSecond", 0, "the maximum files per second read by this worker across all jobs, 0 is unlimited")
+	var scheduledEventsUrl = flag.String("scheduledEventsUrl", cachewarmer.DefaultScheduledEventsUrl, "the Scheduled Events endpoint polled for a spot eviction, the unfinished worker jobs are released when the VM is preempted.  Leave blank to not poll, for example on a worker outside of Azure.")
+	var throttleSchedule = flag.String("throttleSchedule", "", "a comma separated schedule of local time of day limits overriding maxMBPerSecond and maxFilesPerSecond, for example '08:00-18:00=100/50'")
 
 	flag.Parse()
 
@@ -39,14 +54,61 @@ func initializeApplicationVariables(ctx context.Context) *cachewarmer.Worker {
 		log.EnableDebugging()
 	}
 
-	if len(*storageAccount) == 0 {
+	if *maxDequeueCount < 1 {
+		fmt.Fprintf(os.Stderr, "ERROR: maxDequeueCount must be at least 1\n")
+		usage()
+		os.Exit(1)
+	}
+
+	throttleSettings, err := cachewarmer.InitializeThrottleSettings(*maxMBPerSecond, *maxFilesPerSecond, *throttleSchedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: throttle is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+
+	// the default settings must be set before the queue export is mounted
+	mountSettings, err := cachewarmer.InitializeMountSettings("", *mountOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: mountOptions is not valid: %v\n", err)
+		usage()
+		os.Exit(1)
+	}
+	cachewarmer.SetDefaultMountSettings(mountSettings)
+
+	if *queueType != cachewarmer.QueueTypeAzure && *queueType != cachewarmer.QueueTypeDirectory {
+		fmt.Fprintf(os.Stderr, "ERROR: queueType '%s' is not valid\n", *queueType)
+		usage()
+		os.Exit(1)
+	}
+
+	if *queueType == cachewarmer.QueueTypeAzure && len(*storageAccount) == 0 {
 		fmt.Fprintf(os.Stderr, "ERROR: storageAccount is not specified\n")
 		usage()
 		os.Exit(1)
 	}
 
-	if len(*storageAccountResourceGroup) == 0 && len(*storageKey) == 0 {
-		fmt.Fprintf(os.Stderr, "ERROR: storageAccountResourceGroup or storageKey must be specified\n")
+	useQueueSas := len(*queueSasMountAddress) > 0 || len(*queueSasExportPath) > 0
+	if useQueueSas && (len(*queueSasMountAddress) == 0 || len(*queueSasExportPath) == 0) {
+		fmt.Fprintf(os.Stderr, "ERROR: queueSasMountAddress and queueSasExportPath must both be specified\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if useQueueSas && *useManagedIdentity {
+		fmt.Fprintf(os.Stderr, "ERROR: only one of useManagedIdentity or the queue SAS may be specified\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if *queueType == cachewarmer.QueueTypeAzure && len(*storageAccountResourceGroup) == 0 && len(*storageKey) == 0 && !*useManagedIdentity && !useQueueSas {
+		fmt.Fprintf(os.Stderr, "ERROR: storageAccountResourceGroup, storageKey, useManagedIdentity, or the queue SAS must be specified\n")
+		usage()
+		os.Exit(1)
+	}
+
+	if *queueType == cachewarmer.QueueTypeDirectory && (len(*queueMountAddress) == 0 || len(*queueExportPath) == 0) {
+		fmt.Fprintf(os.Stderr, "ERROR: queueMountAddress and queueExportPath must be specified for the directory queue\n")
 		usage()
 		os.Exit(1)
 	}
@@ -63,6 +125,50 @@ func initializeApplicationVariables(ctx context.Context) *cachewarmer.Worker {
 		os.Exit(1)
 	}
 
+	if *queueType == cachewarmer.QueueTypeDirectory {
+		cacheWarmerQueues, err := cachewarmer.InitializeDirectoryCacheWarmerQueues(
+			*queueMountAddress,
+			*queueExportPath,
+			*queueDirectoryPath,
+			*queueNamePrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: error initializing queue %v\n", err)
+			os.Exit(1)
+		}
+		return cachewarmer.InitializeWorker(cacheWarmerQueues, *maxDequeueCount, throttleSettings, *scheduledEventsUrl), nil, *metricsAddress
+	}
+
+	if *useManagedIdentity {
+		cacheWarmerQueues, err := cachewarmer.InitializeManagedIdentityCacheWarmerQueues(
+			ctx,
+			*storageAccount,
+			*managedIdentityClientId,
+			*queueNamePrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: error initializing queue %v\n", err)
+			os.Exit(1)
+		}
+		return cachewarmer.InitializeWorker(cacheWarmerQueues, *maxDequeueCount, throttleSettings, *scheduledEventsUrl), nil, *metricsAddress
+	}
+
+	if useQueueSas {
+		queueSasReader, err := cachewarmer.InitializeQueueSasReader(*queueSasMountAddress, *queueSasExportPath, *queueNamePrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: unable to read the queue SAS: %v\n", err)
+			os.Exit(1)
+		}
+		cacheWarmerQueues, err := cachewarmer.InitializeSasCacheWarmerQueues(
+			ctx,
+			*storageAccount,
+			queueSasReader,
+			*queueNamePrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: error initializing queue %v\n", err)
+			os.Exit(1)
+		}
+		return cachewarmer.InitializeWorker(cacheWarmerQueues, *maxDequeueCount, throttleSettings, *scheduledEventsUrl), queueSasReader, *metricsAddress
+	}
+
 	storageAccountKey := ""
 	if len(*storageKey) != 0 {
 		storageAccountKey = *storageKey
@@ -85,7 +191,7 @@ func initializeApplicationVariables(ctx context.Context) *cachewarmer.Worker {
 		os.Exit(1)
 	}
 
-	return cachewarmer.InitializeWorker(cacheWarmerQueues)
+	return cachewarmer.InitializeWorker(cacheWarmerQueues, *maxDequeueCount, throttleSettings, *scheduledEventsUrl), nil, *metricsAddress
 }
 
 func main() {
@@ -93,7 +199,7 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// initialize the variables
-	warmPathManager := initializeApplicationVariables(ctx)
+	warmPathManager, queueSasReader, metricsAddress := initializeApplicationVariables(ctx)
 
 	// initialize the sync wait group
 	syncWaitGroup := sync.WaitGroup{}
@@ -103,6 +209,16 @@ func main() {
 	syncWaitGroup.Add(1)
 	go warmPathManager.RunWorkerManager(ctx, &syncWaitGroup)
 
+	if queueSasReader != nil {
+		syncWaitGroup.Add(1)
+		go queueSasReader.RunQueueSasReader(ctx, &syncWaitGroup)
+	}
+
+	if len(metricsAddress) > 0 {
+		syncWaitGroup.Add(1)
+		go cachewarmer.RunMetricsServer(ctx, &syncWaitGroup, metricsAddress)
+	}
+
 	log.Info.Printf("wait for ctrl-c")
 	// wait on ctrl-c
 	sigchan := make(chan os.Signal, 10)
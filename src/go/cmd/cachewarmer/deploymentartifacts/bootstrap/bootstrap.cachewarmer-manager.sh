@@ -17,7 +17,30 @@ set -x
 # (OPT)VMSS_SUBNET
 # (OPT)VMSS_SSHPUBLICKEY
 # (OPT)VMSS_PASSWORD
-# (OPT)VMSS_WORKER_COUNT
+# (OPT)VMSS_WORKER_COUNT - the maximum VMSS worker count
+# (OPT)VMSS_MIN_WORKER_COUNT - the minimum VMSS worker count kept running
+# (OPT)VMSS_SKU - the VM SKU of the VMSS workers
+# (OPT)VMSS_IMAGE_ID - the resource ID of a custom image for the VMSS workers
+# (OPT)VMSS_IMAGE - the marketplace image 'publisher:offer:sku[:version]' for the VMSS workers
+# (OPT)VMSS_PLAN - the purchase plan 'name:publisher:product' of the VMSS image
+# (OPT)VMSS_PRIORITY - set to "regular" for regular priority VMSS workers, the default is "spot"
+# (OPT)VMSS_MAX_PRICE - the maximum hourly price of a spot VMSS worker
+# (OPT)VMSS_ACCELERATED_NETWORKING - set to "true" to enable accelerated networking on the VMSS workers
+# (OPT)VMSS_ZONES - the comma separated availability zones of the VMSS workers
+# (OPT)VMSS_OS_DISK_SIZE_GB - the os disk size of the VMSS workers
+# (OPT)VMSS_OS_DISK_TYPE - the os disk type of the VMSS workers, for example "Premium_LRS"
+# (OPT)VMSS_USER_ASSIGNED_IDENTITY - the resource ID of the user assigned identity of the VMSS workers
+# (OPT)WORKER_QUEUE_AUTH - set to "identity" for the workers to use the user assigned identity, the default is "sas"
+# (OPT)STATIC_WORKER_POOL - set to "true" to skip VMSS management, the workers are installed separately
+# (OPT)QUEUE_TYPE - set to "directory" to use the directory queues on an NFS export
+# (OPT)QUEUE_MOUNT_ADDRESS - the mount address of the directory queues
+# (OPT)QUEUE_EXPORT_PATH - the export path of the directory queues
+# (OPT)QUEUE_DIRECTORY_PATH - the path on the export of the directory queues
+# (OPT)METRICS_ADDRESS - the address, for example ':9100', to serve Prometheus metrics at /metrics
+# (OPT)WORKER_METRICS_ADDRESS - the address for the VMSS workers to serve Prometheus metrics at /metrics
+# (OPT)SCHEDULE_FILE - the json file of warm jobs the manager submits on cron schedules
+# (OPT)MOUNT_OPTIONS - the nfs mount options of the queue export, the workers, and the jobs without mount settings
+# (OPT)LEASE_TYPE - the lease of the leading manager, "blob", "file", or "none", the default depends on the queue type
 
 SERVICE_USER=root
 RSYSLOG_FILE="35-cachewarmer-manager.conf"
@@ -74,6 +97,98 @@ function write_system_files() {
         sed -i "s/VMSS_WORKER_COUNT_REPLACE/-workerCount $VMSS_WORKER_COUNT/g" $DST_FILE
     fi
 
+    if [[ -z "${VMSS_MIN_WORKER_COUNT}" ]]; then
+        sed -i "s/VMSS_MIN_WORKER_COUNT_REPLACE//g" $DST_FILE
+    else
+        sed -i "s/VMSS_MIN_WORKER_COUNT_REPLACE/-minWorkerCount $VMSS_MIN_WORKER_COUNT/g" $DST_FILE
+    fi
+
+    VMSS_ARGS=""
+    if [[ ! -z "${VMSS_SKU}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssSku $VMSS_SKU"
+    fi
+    if [[ ! -z "${VMSS_IMAGE_ID}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssImageId $VMSS_IMAGE_ID"
+    fi
+    if [[ ! -z "${VMSS_IMAGE}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssImage $VMSS_IMAGE"
+    fi
+    if [[ ! -z "${VMSS_PLAN}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssPlan $VMSS_PLAN"
+    fi
+    if [[ ! -z "${VMSS_PRIORITY}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssPriority $VMSS_PRIORITY"
+    fi
+    if [[ ! -z "${VMSS_MAX_PRICE}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssMaxPrice $VMSS_MAX_PRICE"
+    fi
+    if [[ "${VMSS_ACCELERATED_NETWORKING}" == "true" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssAcceleratedNetworking"
+    fi
+    if [[ ! -z "${VMSS_ZONES}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssZones $VMSS_ZONES"
+    fi
+    if [[ ! -z "${VMSS_OS_DISK_SIZE_GB}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssOsDiskSizeGB $VMSS_OS_DISK_SIZE_GB"
+    fi
+    if [[ ! -z "${VMSS_OS_DISK_TYPE}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssOsDiskType $VMSS_OS_DISK_TYPE"
+    fi
+    if [[ ! -z "${VMSS_USER_ASSIGNED_IDENTITY}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -vmssUserAssignedIdentity $VMSS_USER_ASSIGNED_IDENTITY"
+    fi
+    if [[ ! -z "${WORKER_QUEUE_AUTH}" ]]; then
+        VMSS_ARGS="$VMSS_ARGS -workerQueueAuth $WORKER_QUEUE_AUTH"
+    fi
+    # the image arguments contain both '/' and ':'
+    sed -i "s#VMSS_ARGS_REPLACE#$VMSS_ARGS#g" $DST_FILE
+
+    if [[ "${STATIC_WORKER_POOL}" == "true" ]]; then
+        sed -i "s/STATIC_WORKER_POOL_REPLACE/-staticWorkerPool/g" $DST_FILE
+    else
+        sed -i "s/STATIC_WORKER_POOL_REPLACE//g" $DST_FILE
+    fi
+
+    if [[ "${QUEUE_TYPE}" == "directory" ]]; then
+        QUEUE_DIRECTORY_ARG=""
+        if [[ ! -z "${QUEUE_DIRECTORY_PATH}" ]]; then
+            QUEUE_DIRECTORY_ARG="-queueDirectoryPath $QUEUE_DIRECTORY_PATH"
+        fi
+        sed -i "s:QUEUE_ARGS_REPLACE:-queueType directory -queueMountAddress $QUEUE_MOUNT_ADDRESS -queueExportPath $QUEUE_EXPORT_PATH $QUEUE_DIRECTORY_ARG:g" $DST_FILE
+    else
+        sed -i "s:QUEUE_ARGS_REPLACE::g" $DST_FILE
+    fi
+
+    if [[ -z "${WORKER_METRICS_ADDRESS}" ]]; then
+        sed -i "s/WORKER_METRICS_ARGS_REPLACE//g" $DST_FILE
+    else
+        sed -i "s/WORKER_METRICS_ARGS_REPLACE/-workerMetricsAddress $WORKER_METRICS_ADDRESS/g" $DST_FILE
+    fi
+
+    if [[ -z "${METRICS_ADDRESS}" ]]; then
+        sed -i "s/METRICS_ARGS_REPLACE//g" $DST_FILE
+    else
+        sed -i "s/METRICS_ARGS_REPLACE/-metricsAddress $METRICS_ADDRESS/g" $DST_FILE
+    fi
+
+    if [[ -z "${SCHEDULE_FILE}" ]]; then
+        sed -i "s:SCHEDULE_ARGS_REPLACE::g" $DST_FILE
+    else
+        sed -i "s:SCHEDULE_ARGS_REPLACE:-scheduleFile $SCHEDULE_FILE:g" $DST_FILE
+    fi
+
+    if [[ -z "${MOUNT_OPTIONS}" ]]; then
+        sed -i "s#MOUNT_ARGS_REPLACE##g" $DST_FILE
+    else
+        sed -i "s#MOUNT_ARGS_REPLACE#-mountOptions $MOUNT_OPTIONS#g" $DST_FILE
+    fi
+
+    if [[ -z "${LEASE_TYPE}" ]]; then
+        sed -i "s/LEASE_ARGS_REPLACE//g" $DST_FILE
+    else
+        sed -i "s/LEASE_ARGS_REPLACE/-leaseType $LEASE_TYPE/g" $DST_FILE
+    fi
+
     # set the proxy information
     if [[ -z "${http_proxy}" ]]; then
         sed -i "s/HTTP_PROXY_REPLACE//g" $DST_FILE
@@ -7,8 +7,18 @@ set -x
 # BOOTSTRAP_PATH
 # BOOTSTRAP_SCRIPT
 # STORAGE_ACCOUNT - the storage account hosting the job queue
-# STORAGE_KEY - the key to the storage account
 # QUEUE_PREFIX - the queue prefix
+# (OPT)QUEUE_AUTH - set to "sas" to use the queue SAS tokens written by the manager, or "identity" to use the managed identity
+# (OPT)QUEUE_SAS_MOUNT_ADDRESS - the mount address of the export with the queue SAS tokens
+# (OPT)QUEUE_SAS_EXPORT_PATH - the export path with the queue SAS tokens
+# (OPT)STORAGE_KEY - the key to the storage account, if neither QUEUE_AUTH nor STORAGE_ACCOUNT_RESOURCE_GROUP is set
+# (OPT)STORAGE_ACCOUNT_RESOURCE_GROUP - the resource group of the storage account, to look up the key
+# (OPT)QUEUE_TYPE - set to "directory" to use the directory queues on an NFS export
+# (OPT)QUEUE_MOUNT_ADDRESS - the mount address of the directory queues
+# (OPT)QUEUE_EXPORT_PATH - the export path of the directory queues
+# (OPT)QUEUE_DIRECTORY_PATH - the path on the export of the directory queues
+# (OPT)METRICS_ADDRESS - the address, for example ':9100', to serve Prometheus metrics at /metrics
+# (OPT)MOUNT_OPTIONS - the nfs mount options of the queue export and the jobs without mount settings
 
 SERVICE_USER=root
 RSYSLOG_FILE="36-cachewarmer-worker.conf"
@@ -32,11 +42,43 @@ function write_system_files() {
     cp $SRC_FILE $DST_FILE
     sed -i "s/USERREPLACE/$SERVICE_USER/g" $DST_FILE
     sed -i "s/GROUPREPLACE/$SERVICE_USER/g" $DST_FILE
-    sed -i "s:STORAGE_RG_REPLACE:$STORAGE_ACCOUNT_RESOURCE_GROUP:g" $DST_FILE
     sed -i "s/STORAGEACCOUNTREPLACE/$STORAGE_ACCOUNT/g" $DST_FILE
-    sed -i "s:STORAGEKEYREPLACE:$STORAGE_KEY:g" $DST_FILE
     sed -i "s:QUEUEPREFIXREPLACE:$QUEUE_PREFIX:g" $DST_FILE
 
+    QUEUE_AUTH_ARGS=""
+    if [[ "${QUEUE_AUTH}" == "sas" ]]; then
+        QUEUE_AUTH_ARGS="-queueSasMountAddress $QUEUE_SAS_MOUNT_ADDRESS -queueSasExportPath $QUEUE_SAS_EXPORT_PATH"
+    elif [[ "${QUEUE_AUTH}" == "identity" ]]; then
+        QUEUE_AUTH_ARGS="-useManagedIdentity"
+    elif [[ ! -z "${STORAGE_KEY}" ]]; then
+        QUEUE_AUTH_ARGS="-storageKey $STORAGE_KEY"
+    elif [[ ! -z "${STORAGE_ACCOUNT_RESOURCE_GROUP}" ]]; then
+        QUEUE_AUTH_ARGS="-storageAccountResourceGroup $STORAGE_ACCOUNT_RESOURCE_GROUP"
+    fi
+    sed -i "s#QUEUE_AUTH_ARGS_REPLACE#$QUEUE_AUTH_ARGS#g" $DST_FILE
+
+    if [[ "${QUEUE_TYPE}" == "directory" ]]; then
+        QUEUE_DIRECTORY_ARG=""
+        if [[ ! -z "${QUEUE_DIRECTORY_PATH}" ]]; then
+            QUEUE_DIRECTORY_ARG="-queueDirectoryPath $QUEUE_DIRECTORY_PATH"
+        fi
+        sed -i "s:QUEUE_ARGS_REPLACE:-queueType directory -queueMountAddress $QUEUE_MOUNT_ADDRESS -queueExportPath $QUEUE_EXPORT_PATH $QUEUE_DIRECTORY_ARG:g" $DST_FILE
+    else
+        sed -i "s:QUEUE_ARGS_REPLACE::g" $DST_FILE
+    fi
+
+    if [[ -z "${METRICS_ADDRESS}" ]]; then
+        sed -i "s/METRICS_ARGS_REPLACE//g" $DST_FILE
+    else
+        sed -i "s/METRICS_ARGS_REPLACE/-metricsAddress $METRICS_ADDRESS/g" $DST_FILE
+    fi
+
+    if [[ -z "${MOUNT_OPTIONS}" ]]; then
+        sed -i "s#MOUNT_ARGS_REPLACE##g" $DST_FILE
+    else
+        sed -i "s#MOUNT_ARGS_REPLACE#-mountOptions $MOUNT_OPTIONS#g" $DST_FILE
+    fi
+
     if [ -f '/etc/centos-release' ]; then 
         sed -i "s/chown syslog:adm/chown root:root/g" $DST_FILE
     fi
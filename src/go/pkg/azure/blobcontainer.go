@@ -201,7 +201,7 @@ func (b *BlobContainer) DownloadBlob(blobname string) ([]byte, error) {
 		log.Error.Printf("encountered error getting blob properties for '%s': '%v'", blobname, err)
 		return nil, err
 	}
-	data := make([]byte, 0, blobProperties.ContentLength())
+	data := make([]byte, blobProperties.ContentLength())
 	if err := azblob.DownloadBlobToBuffer(b.Context, blobURL, 0, 0, data, azblob.DownloadFromBlobOptions{}); err != nil {
 		log.Error.Printf("encountered error downloading blob '%s': '%v'", blobname, err)
 		return nil, err
@@ -218,3 +218,9 @@ func (b *BlobContainer) DeleteBlob(blobname string) error {
 	}
 	return nil
 }
+
+// IsBlobNotFound returns true if the error is the storage error of a missing blob
+func IsBlobNotFound(err error) bool {
+	serr, ok := err.(azblob.StorageError)
+	return ok && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound
+}
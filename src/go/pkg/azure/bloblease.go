@@ -0,0 +1,65 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package azure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// BlobLease is a lease on an empty blob, held by at most one lease ID at a time
+// The lease operations are described at https://docs.microsoft.com/en-us/rest/api/storageservices/lease-blob
+type BlobLease struct {
+	BlobURL azblob.BlockBlobURL
+	LeaseID string
+	Context context.Context
+}
+
+// InitializeBlobLease creates the container and the empty blob of the lease if they do not already exist.  The lease
+// ID must be a GUID.
+func InitializeBlobLease(ctx context.Context, storageAccount string, storageAccountKey string, containerName string, blobName string, leaseID string) (*BlobLease, error) {
+	blobContainer, err := InitializeBlobContainer(ctx, storageAccount, storageAccountKey, containerName)
+	if err != nil {
+		return nil, fmt.Errorf("error creating container '%s': %v", containerName, err)
+	}
+
+	// create the blob if it does not already exist, never overwriting a leased blob
+	blobURL := blobContainer.ContainerURL.NewBlockBlobURL(blobName)
+	accessConditions := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny}}
+	if _, err := blobURL.Upload(ctx, bytes.NewReader([]byte{}), azblob.BlobHTTPHeaders{}, azblob.Metadata{}, accessConditions, azblob.DefaultAccessTier, nil, azblob.ClientProvidedKeyOptions{}); err != nil {
+		if serr, ok := err.(azblob.StorageError); !ok || (serr.ServiceCode() != azblob.ServiceCodeBlobAlreadyExists && serr.ServiceCode() != azblob.ServiceCodeLeaseIDMissing) {
+			return nil, fmt.Errorf("error creating blob '%s': %v", blobName, err)
+		}
+	}
+
+	return &BlobLease{
+		BlobURL: blobURL,
+		LeaseID: leaseID,
+		Context: ctx,
+	}, nil
+}
+
+// Acquire acquires or renews the lease for the duration, between 15 and 60 seconds, and returns false if the lease is
+// held by another lease ID
+func (l *BlobLease) Acquire(duration time.Duration) (bool, error) {
+	// acquiring a lease already held by the same lease ID renews it
+	if _, err := l.BlobURL.AcquireLease(l.Context, l.LeaseID, int32(duration.Seconds()), azblob.ModifiedAccessConditions{}); err != nil {
+		if serr, ok := err.(azblob.StorageError); ok && serr.ServiceCode() == azblob.ServiceCodeLeaseAlreadyPresent {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Release releases the lease, so another lease ID may acquire it without waiting for it to expire
+func (l *BlobLease) Release() error {
+	if _, err := l.BlobURL.ReleaseLease(l.Context, l.LeaseID, azblob.ModifiedAccessConditions{}); err != nil {
+		return err
+	}
+	return nil
+}
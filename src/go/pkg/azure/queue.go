@@ -11,13 +11,17 @@ import (
 	"time"
 
 	"github.com/Azure/Avere/src/go/pkg/log"
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
 const (
 	enqueueVisibilityTimeout   = time.Duration(0) * time.Second  // make the message available immediately
 	enqueueMessageTTL          = time.Duration(-1) * time.Second // never expire
 	productionQueueURLTemplate = "https://%s.queue.core.windows.net"
+	storageResource            = "https://storage.azure.com/"
+	tokenRefreshRetryInterval  = time.Duration(30) * time.Second // retry a failed token refresh after 30 seconds
 )
 
 // Queue represents a single azure storage queue
@@ -74,6 +78,84 @@ func InitializeQueueNonFatal(ctx context.Context, storageAccount string, storage
 
 	p := azqueue.NewPipeline(credential, azqueue.PipelineOptions{})
 
+	return initializeQueueWithPipeline(ctx, storageAccount, queueName, p, true)
+}
+
+// InitializeQueueWithTokenCredential creates a Queue authorized by an OAuth token, such as the token of a managed
+// identity.  The queue is not created, since the identity may only be allowed to process messages.
+func InitializeQueueWithTokenCredential(ctx context.Context, storageAccount string, credential azqueue.TokenCredential, queueName string) (*Queue, error) {
+	p := azqueue.NewPipeline(credential, azqueue.PipelineOptions{})
+
+	return initializeQueueWithPipeline(ctx, storageAccount, queueName, p, false)
+}
+
+// InitializeQueueWithSAS creates a Queue authorized by the SAS token returned by getSAS for each request, so a rotated
+// token is used without creating the Queue again.  The queue is not created, since a queue SAS may not create queues.
+func InitializeQueueWithSAS(ctx context.Context, storageAccount string, queueName string, getSAS func() string) (*Queue, error) {
+	o := azqueue.PipelineOptions{}
+
+	// the factories match azqueue.NewPipeline, with the SAS policy in place of the credential, close to the wire
+	f := []pipeline.Factory{
+		azqueue.NewTelemetryPolicyFactory(o.Telemetry),
+		azqueue.NewUniqueRequestIDPolicyFactory(),
+		azqueue.NewRetryPolicyFactory(o.Retry),
+		newSASPolicyFactory(getSAS),
+		azqueue.NewRequestLogPolicyFactory(o.RequestLog),
+		pipeline.MethodFactoryMarker(),
+	}
+	p := pipeline.NewPipeline(f, pipeline.Options{HTTPSender: nil, Log: o.Log})
+
+	return initializeQueueWithPipeline(ctx, storageAccount, queueName, p, false)
+}
+
+// newSASPolicyFactory adds the query parameters of the current SAS token to each request
+func newSASPolicyFactory(getSAS func() string) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			sasValues, err := url.ParseQuery(getSAS())
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse the SAS token: %v", err)
+			}
+			query := request.URL.Query()
+			for key, values := range sasValues {
+				query[key] = values
+			}
+			request.URL.RawQuery = query.Encode()
+			return next.Do(ctx, request)
+		}
+	})
+}
+
+// NewManagedIdentityTokenCredential returns a token credential of the managed identity of the VM for Azure Storage,
+// refreshed before the token expires.  The client ID selects one of several user assigned identities, and may be blank.
+func NewManagedIdentityTokenCredential(clientID string) (azqueue.TokenCredential, error) {
+	msiConfig := auth.NewMSIConfig()
+	msiConfig.Resource = storageResource
+	msiConfig.ClientID = clientID
+	spToken, err := msiConfig.ServicePrincipalToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := spToken.Refresh(); err != nil {
+		return nil, fmt.Errorf("unable to get the managed identity token: %v", err)
+	}
+
+	return azqueue.NewTokenCredential(spToken.OAuthToken(), func(credential azqueue.TokenCredential) time.Duration {
+		// EnsureFresh only refreshes the token when it is about to expire
+		if err := spToken.EnsureFresh(); err != nil {
+			log.Error.Printf("unable to refresh the managed identity token: %v", err)
+			return tokenRefreshRetryInterval
+		}
+		credential.SetToken(spToken.OAuthToken())
+		nextRefresh := time.Until(spToken.Token().Expires()) / 2
+		if nextRefresh < tokenRefreshRetryInterval {
+			nextRefresh = tokenRefreshRetryInterval
+		}
+		return nextRefresh
+	}), nil
+}
+
+func initializeQueueWithPipeline(ctx context.Context, storageAccount string, queueName string, p pipeline.Pipeline, createQueue bool) (*Queue, error) {
 	u, _ := url.Parse(fmt.Sprintf(productionQueueURLTemplate, storageAccount))
 
 	serviceURL := azqueue.NewServiceURL(*u, p)
@@ -82,7 +164,9 @@ func InitializeQueueNonFatal(ctx context.Context, storageAccount string, storage
 	queueURL := serviceURL.NewQueueURL(queueName) // Queue names require lowercase
 
 	// create the queue if it does not already exist
-	if queueCreateResponse, err := queueURL.Create(ctx, azqueue.Metadata{}); err != nil {
+	if !createQueue {
+		log.Debug.Printf("not creating queue '%s'", queueName)
+	} else if queueCreateResponse, err := queueURL.Create(ctx, azqueue.Metadata{}); err != nil {
 		if serr, ok := err.(azqueue.StorageError); !ok || serr.ServiceCode() != azqueue.ServiceCodeQueueAlreadyExists {
 			return nil, fmt.Errorf("error encountered: %v", serr.ServiceCode())
 		}
@@ -107,6 +191,15 @@ func (q *Queue) IsQueueEmpty() (bool, error) {
 	return response.ApproximateMessagesCount() == 0, nil
 }
 
+// ApproximateMessageCount returns the approximate number of visible and invisible messages in the queue
+func (q *Queue) ApproximateMessageCount() (int64, error) {
+	response, err := q.QueueURL.GetProperties(q.Context)
+	if err != nil {
+		return 0, err
+	}
+	return int64(response.ApproximateMessagesCount()), nil
+}
+
 // Enqueue enqueues the message to the queue
 func (q *Queue) Enqueue(message string) error {
 	_, err := q.MessagesURL.Enqueue(q.Context, message, enqueueVisibilityTimeout, enqueueMessageTTL)
@@ -122,6 +215,12 @@ func (q *Queue) Peek(maxMessages int32) (*azqueue.PeekedMessagesResponse, error)
 	return q.MessagesURL.Peek(q.Context, maxMessages)
 }
 
+// Clear deletes all visible and invisible messages from the queue
+func (q *Queue) Clear() error {
+	_, err := q.MessagesURL.Clear(q.Context)
+	return err
+}
+
 // DeleteMessage deletes the message from the queue
 func (q *Queue) DeleteMessage(messageID azqueue.MessageID, popReceipt azqueue.PopReceipt) (*azqueue.MessageIDDeleteResponse, error) {
 	msgIDURL := q.MessagesURL.NewMessageIDURL(messageID)
@@ -0,0 +1,115 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// CancelJob writes the cancel marker of the job to the warm target export, the manager and workers drop the
+// remaining work of the job once they see the marker
+func CancelJob(mountAddress string, exportPath string, jobID string) error {
+	jobPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, true)
+	if err != nil {
+		return fmt.Errorf("error creating job directory '%s': %v", jobPath, err)
+	}
+	cancelTime := []byte(time.Now().Format(time.RFC3339))
+	if err := ioutil.WriteFile(path.Join(jobPath, JobCancelledFilename), cancelTime, 0644); err != nil {
+		return fmt.Errorf("error writing cancel marker for job '%s': %v", jobID, err)
+	}
+	return nil
+}
+
+// IsJobCancelled returns true if the cancel marker of the job exists on the warm target export
+func IsJobCancelled(mountAddress string, exportPath string, jobID string) (bool, error) {
+	jobPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := os.Stat(path.Join(jobPath, JobCancelledFilename)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type jobCancellation struct {
+	mountAddress string
+	exportPath   string
+	cancelled    bool
+	lastCheck    time.Time
+}
+
+// JobCancellations caches the cancel state of the jobs, so the cancel marker is not checked for every file
+type JobCancellations struct {
+	mux  sync.Mutex
+	jobs map[string]*jobCancellation
+}
+
+// InitializeJobCancellations initializes the cache of the cancel state of the jobs
+func InitializeJobCancellations() *JobCancellations {
+	return &JobCancellations{
+		jobs: make(map[string]*jobCancellation),
+	}
+}
+
+// Register records where the cancel marker of the job is written, jobs without an id are never cancelled
+func (c *JobCancellations) Register(jobID string, mountAddresses []string, exportPath string) {
+	if len(jobID) == 0 || len(mountAddresses) == 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := c.jobs[jobID]; ok {
+		return
+	}
+	c.jobs[jobID] = &jobCancellation{
+		mountAddress: mountAddresses[0],
+		exportPath:   exportPath,
+	}
+}
+
+// Remove forgets the cancel state of the job
+func (c *JobCancellations) Remove(jobID string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.jobs, jobID)
+}
+
+// IsCancelled returns true if the registered job is cancelled, checking the cancel marker at most once per interval
+func (c *JobCancellations) IsCancelled(jobID string) bool {
+	c.mux.Lock()
+	job, ok := c.jobs[jobID]
+	if !ok || job.cancelled || time.Since(job.lastCheck) < cancelCheckInterval {
+		cancelled := ok && job.cancelled
+		c.mux.Unlock()
+		return cancelled
+	}
+	job.lastCheck = time.Now()
+	c.mux.Unlock()
+
+	cancelled, err := IsJobCancelled(job.mountAddress, job.exportPath, jobID)
+	if err != nil {
+		log.Error.Printf("error checking if job '%s' is cancelled: %v", jobID, err)
+		return false
+	}
+	if cancelled {
+		log.Info.Printf("job '%s' is cancelled", jobID)
+		c.mux.Lock()
+		job.cancelled = true
+		c.mux.Unlock()
+	}
+	return cancelled
+}
@@ -0,0 +1,284 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// ChecksumSettings enables the SHA-256 checksum of each file read by the workers.  The optional reference is
+// the path on the warm target export of a file in the sha256sum format, with paths relative to the warm target path.
+type ChecksumSettings struct {
+	Enabled       bool
+	ReferencePath string
+}
+
+// ChecksumMismatch records a file whose checksum does not match the reference checksum
+type ChecksumMismatch struct {
+	Path           string
+	SHA256         string
+	ExpectedSHA256 string
+}
+
+// ParseChecksums parses the sha256sum format, one '<hex checksum>  <path>' per line, where the path is
+// relative to the warm target path
+func ParseChecksums(data []byte) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*KB), MB)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			return nil, fmt.Errorf("line %d: expected a sha256 checksum followed by a path", lineNumber)
+		}
+		// a leading '*' marks a file checksummed in binary mode
+		filePath := strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*")
+		checksums[normalizeChecksumPath(filePath)] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checksums: %v", err)
+	}
+	return checksums, nil
+}
+
+func normalizeChecksumPath(filePath string) string {
+	return strings.TrimPrefix(path.Clean(path.Join("/", filePath)), "/")
+}
+
+// ReadChecksumMismatches reads the checksum mismatches recorded by all workers of the job
+func ReadChecksumMismatches(mountAddress string, exportPath string, jobID string) ([]ChecksumMismatch, error) {
+	progressPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	dirEntries, err := ioutil.ReadDir(progressPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading progress directory '%s': %v", progressPath, err)
+	}
+	mismatches := []ChecksumMismatch{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ChecksumMismatchFileSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(progressPath, dirEntry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading checksum mismatch file '%s': %v", dirEntry.Name(), err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if len(line) == 0 {
+				continue
+			}
+			var mismatch ChecksumMismatch
+			if err := json.Unmarshal([]byte(line), &mismatch); err != nil {
+				return nil, fmt.Errorf("error parsing checksum mismatch '%s': %v", line, err)
+			}
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches, nil
+}
+
+type trackedJobChecksums struct {
+	mountAddress      string
+	exportPath        string
+	reference         map[string]string
+	pendingChecksums  []string
+	pendingMismatches []string
+}
+
+// ChecksumTracker accumulates the checksums computed by a worker in memory, compares them to the reference
+// checksums of the job, and periodically appends them to the files of the worker on the warm target export
+type ChecksumTracker struct {
+	mux      sync.Mutex
+	flushMux sync.Mutex
+	writer   string
+	jobs     map[string]*trackedJobChecksums
+}
+
+// InitializeChecksumTracker initializes the checksum tracker for the writer
+func InitializeChecksumTracker(writer string) *ChecksumTracker {
+	return &ChecksumTracker{
+		writer: writer,
+		jobs:   make(map[string]*trackedJobChecksums),
+	}
+}
+
+// Register records where the checksums of the job are written, and loads the reference checksums of the job once
+func (t *ChecksumTracker) Register(jobID string, mountAddresses []string, exportPath string, settings ChecksumSettings) {
+	if len(jobID) == 0 || len(mountAddresses) == 0 || !settings.Enabled {
+		return
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.jobs[jobID]; ok {
+		return
+	}
+	job := &trackedJobChecksums{
+		mountAddress: mountAddresses[0],
+		exportPath:   exportPath,
+	}
+	if len(settings.ReferencePath) > 0 {
+		reference, err := ReadChecksumsFromExport(mountAddresses[0], exportPath, settings.ReferencePath)
+		if err != nil {
+			log.Error.Printf("error reading reference checksums of job '%s', checksums are not compared: %v", jobID, err)
+		} else {
+			log.Info.Printf("read %d reference checksums of job '%s'", len(reference), jobID)
+			job.reference = reference
+		}
+	}
+	t.jobs[jobID] = job
+}
+
+// ReadChecksumsFromExport reads the checksums at the path on the export
+func ReadChecksumsFromExport(mountAddress string, exportPath string, referencePath string) (map[string]string, error) {
+	localMountPath := GetLocalMountPath(mountAddress, exportPath)
+	if err := MountPath(mountAddress, exportPath, localMountPath); err != nil {
+		return nil, fmt.Errorf("error trying to mount %s:%s: %v", mountAddress, exportPath, err)
+	}
+	data, err := ioutil.ReadFile(path.Join(localMountPath, referencePath))
+	if err != nil {
+		return nil, fmt.Errorf("error reading reference checksums '%s': %v", referencePath, err)
+	}
+	return ParseChecksums(data)
+}
+
+// Record adds the checksum of the file, and returns true if it does not match the reference checksum.  Files
+// missing from the reference are recorded but not compared.
+func (t *ChecksumTracker) Record(jobID string, filePath string, checksum string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	job, ok := t.jobs[jobID]
+	if !ok {
+		return false
+	}
+	filePath = normalizeChecksumPath(filePath)
+	job.pendingChecksums = append(job.pendingChecksums, fmt.Sprintf("%s  %s\n", checksum, filePath))
+	expected, ok := job.reference[filePath]
+	if !ok || expected == checksum {
+		return false
+	}
+	data, err := json.Marshal(ChecksumMismatch{Path: filePath, SHA256: checksum, ExpectedSHA256: expected})
+	if err != nil {
+		log.Error.Printf("BUG BUG: error marshaling checksum mismatch: %v", err)
+	} else {
+		job.pendingMismatches = append(job.pendingMismatches, string(data)+"\n")
+	}
+	return true
+}
+
+// Remove appends the checksums and mismatches recorded for the job since the last flush, and forgets the job
+func (t *ChecksumTracker) Remove(jobID string) {
+	t.Flush()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if job, ok := t.jobs[jobID]; ok && len(job.pendingChecksums) == 0 && len(job.pendingMismatches) == 0 {
+		delete(t.jobs, jobID)
+	}
+}
+
+// Flush appends the checksums and mismatches recorded since the last flush
+func (t *ChecksumTracker) Flush() {
+	t.flushMux.Lock()
+	defer t.flushMux.Unlock()
+
+	type pendingWrite struct {
+		jobID        string
+		mountAddress string
+		exportPath   string
+		checksums    []string
+		mismatches   []string
+	}
+	t.mux.Lock()
+	pendingWrites := []pendingWrite{}
+	for jobID, job := range t.jobs {
+		if len(job.pendingChecksums) == 0 && len(job.pendingMismatches) == 0 {
+			continue
+		}
+		pendingWrites = append(pendingWrites, pendingWrite{
+			jobID:        jobID,
+			mountAddress: job.mountAddress,
+			exportPath:   job.exportPath,
+			checksums:    job.pendingChecksums,
+			mismatches:   job.pendingMismatches,
+		})
+		job.pendingChecksums = nil
+		job.pendingMismatches = nil
+	}
+	t.mux.Unlock()
+
+	for _, w := range pendingWrites {
+		if err := t.appendLines(w.mountAddress, w.exportPath, w.jobID, ChecksumFileSuffix, w.checksums); err != nil {
+			log.Error.Printf("error writing checksums for job '%s': %v", w.jobID, err)
+		}
+		if err := t.appendLines(w.mountAddress, w.exportPath, w.jobID, ChecksumMismatchFileSuffix, w.mismatches); err != nil {
+			log.Error.Printf("error writing checksum mismatches for job '%s': %v", w.jobID, err)
+		}
+	}
+}
+
+// appendLines appends to the file of the writer, there is never more than one writer per file
+func (t *ChecksumTracker) appendLines(mountAddress string, exportPath string, jobID string, suffix string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	jobPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, true)
+	if err != nil {
+		return fmt.Errorf("error creating job directory '%s': %v", jobPath, err)
+	}
+	f, err := os.OpenFile(path.Join(jobPath, fmt.Sprintf("%s%s", t.writer, suffix)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	for _, line := range lines {
+		if _, err := writer.WriteString(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RunChecksumFlusher periodically flushes the checksums until cancelled
+func (t *ChecksumTracker) RunChecksumFlusher(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	defer syncWaitGroup.Done()
+	log.Debug.Printf("[ChecksumTracker.RunChecksumFlusher")
+	defer log.Debug.Printf("ChecksumTracker.RunChecksumFlusher]")
+
+	ticker := time.NewTicker(progressFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Flush()
+			return
+		case <-ticker.C:
+			t.Flush()
+		}
+	}
+}
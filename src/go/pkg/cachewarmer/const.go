@@ -20,18 +20,102 @@ const (
 	allFilesOrBytes       = int64(-1)
 	MaximumFilesToRead    = 200
 
+	// keep the file list of an incremental worker job well within the 64KB queue message limit
+	MaximumFileListBytes = 32 * KB
+
 	// golang uses an 8192 buffer passed to getdents64 so we'll choose 128 because we get these on the first call anyway
 	MinimumJobsOnDirRead = 128
 
 	WarmPathJobQueueSuffix = "job"
 	WorkQueueSuffix        = "work"
+	DeadLetterQueueSuffix  = "deadletter"
+
+	// worker jobs failing this many times are moved to the dead letter queue
+	DefaultMaxDequeueCount = 5
+
+	// the queue backends, the directory queues are on an NFS export shared by all cache warmer hosts
+	QueueTypeAzure            = "azure"
+	QueueTypeDirectory        = "directory"
+	DefaultQueueDirectoryPath = "/.cachewarmjob/queues"
+
+	// the queue authorization of the VMSS workers, the storage key is never passed to the workers
+	QueueAuthSas      = "sas"
+	QueueAuthIdentity = "identity"
+
+	// the queue SAS tokens of the workers are written below the job progress directory of the bootstrap export
+	QueueSasDirectory  = "sas"
+	QueueSasFileSuffix = ".json"
+
+	// the lease of the leading manager of a queue name prefix, a blob lease in the storage account of the queues, or a
+	// lease file below the job progress directory of an export
+	LeaseTypeBlob      = "blob"
+	LeaseTypeFile      = "file"
+	LeaseTypeNone      = "none"
+	LeaseContainerName = "cachewarmer-leases"
+	LeaseDirectory     = "leases"
+	LeaseFileSuffix    = ".json"
 
 	// the base mount path
 	DefaultCacheWarmerMountPath = "/mnt/cachewarmer"
 
+	// the job progress records are written below this directory on the warm target export
+	JobProgressDirectory  = ".cachewarmjob"
+	ManagerProgressWriter = "manager"
+	JobProgressFileSuffix = ".json"
+
+	// the checksums and checksum mismatches of each worker are appended to the job progress directory
+	ChecksumFileSuffix         = ".sha256"
+	ChecksumMismatchFileSuffix = ".mismatch"
+
+	// the cancel marker of a job is written to the job progress directory
+	JobCancelledFilename = "cancelled"
+
+	// the files with several links claimed by the workers of a distributed enumeration are recorded below the job
+	// progress directory, so each file is only read once across the workers
+	FileClaimDirectory = "claims"
+
+	// the priorities of the jobs, each has its own job and work queue, and the normal queues have no suffix
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+
+	// the manifests of previously warmed files are written below the job progress directory, and the manifest of a
+	// job is pending until the job completes
+	ManifestDirectory     = "manifests"
+	PendingManifestSuffix = ".pending"
+
+	// the reports of completed jobs are written below the job progress directory
+	ReportDirectory  = "reports"
+	ReportJsonSuffix = ".json"
+	ReportCsvSuffix  = ".csv"
+
+	// the last run of each scheduled job is written below the job progress directory
+	ScheduleDirectory = "schedules"
+
+	// the Scheduled Events endpoint of the instance metadata service, and the event type of a spot eviction
+	DefaultScheduledEventsUrl = "http://169.254.169.254/metadata/scheduledevents?api-version=2020-07-01"
+	ScheduledEventPreempt     = "Preempt"
+
 	NumberOfMessagesToDequeue    = 1
 	CacheWarmerVisibilityTimeout = time.Duration(60) * time.Second // 1 minute visibility timeout
 
+	// the most messages an Azure Storage queue returns from a single peek
+	MaximumMessagesToPeek = 32
+
+	// the mount types and their default options
+	MountTypeNfs            = "nfs"
+	MountTypeCifs           = "cifs"
+	DefaultNfsMountOptions  = "hard,nointr,proto=tcp,mountproto=tcp,retry=30"
+	DefaultCifsMountOptions = "vers=3.0"
+
+	// the hex digits of the hashes naming the mount point of the mount settings of a job, and the file system of a
+	// file claim
+	shortHashLength = 12
+
+	// the symlink policies of a job, the symlinks below the warm target path are skipped unless followed
+	SymlinkPolicySkip   = "skip"
+	SymlinkPolicyFollow = "follow"
+
 	// retry mounting for 10 minutes
 	MountRetryCount        = 60
 	MountRetrySleepSeconds = 10
@@ -60,9 +144,57 @@ const (
 	tick                        = time.Duration(1) * time.Millisecond   // 1ms
 	timeBetweenJobCheck         = time.Duration(2) * time.Second        // 2 seconds between checking for jobs
 	refreshWorkInterval         = time.Duration(10) * time.Second       // update job timestamp every 30s
+	progressFlushInterval       = time.Duration(10) * time.Second       // write job progress every 10s
+	cancelCheckInterval         = time.Duration(10) * time.Second       // check for a job cancel marker every 10s
 	timeBetweenWorkerJobCheck   = time.Duration(100) * time.Millisecond // 100ms between checking for jobs
 	timeToDeleteVMSSAfterNoJobs = time.Duration(20) * time.Second       // 20 seconds before deleting the VMSS
 	failureTimeToDeleteVMSS     = time.Duration(15) * time.Minute       // after 15 minutes of failure, ensure vmss deleted
+	timeBetweenScaleCheck       = time.Duration(1) * time.Minute        // 1 minute between checking the vmss capacity
+	timeBetweenReportCheck      = time.Duration(30) * time.Second       // 30 seconds between checking for completed jobs to report
+	timeBetweenScheduleCheck    = time.Duration(10) * time.Second       // 10 seconds between checking for the next scheduled minute
+	scheduleCatchUp             = time.Duration(10) * time.Minute       // scheduled runs missed by more than 10 minutes are skipped
+	scheduledRunMaxAge          = time.Duration(24) * time.Hour         // a scheduled run not done after 24 hours is abandoned
+	queueSasLifetime            = time.Duration(4) * time.Hour          // the queue SAS tokens expire after 4 hours
+	queueSasRotateInterval      = time.Duration(1) * time.Hour          // the manager mints new queue SAS tokens every hour
+	queueSasReadInterval        = time.Duration(5) * time.Minute        // the workers read the queue SAS tokens every 5 minutes
+	queueSasClockSkew           = time.Duration(15) * time.Minute       // the queue SAS tokens start 15 minutes early for clock skew
+
+	// spot eviction settings of the worker
+	timeBetweenScheduledEventsCheck = time.Duration(1) * time.Second // 1 second between checking for a spot eviction
+	instanceMetadataTimeout         = time.Duration(5) * time.Second // the instance metadata requests time out after 5 seconds
+
+	// manager leader election settings
+	leaseDuration      = time.Duration(60) * time.Second // the lease expires 60 seconds after the last renewal, the longest blob lease
+	leaseRenewInterval = time.Duration(15) * time.Second // the leader renews the lease, and the standby managers try to acquire it, every 15 seconds
+	leaseSettleTime    = time.Duration(2) * time.Second  // the wait before a new holder of a lease file checks that its write was the last
+
+	// mount address health settings of the worker
+	mountUnhealthyFailureCount = 3                               // consecutive failures before an address is skipped
+	mountRetryBackoff          = time.Duration(30) * time.Second // the first backoff before an unhealthy address is probed again
+	mountMaxRetryBackoff       = time.Duration(10) * time.Minute // the backoff doubles with each failed probe up to 10 minutes
+	mountProbeTimeout          = time.Duration(30) * time.Second // an address is unhealthy if its mount and stat take longer
+	mountUnhealthyLatency      = time.Duration(5) * time.Second  // an address is unhealthy if its average stat latency is longer
+	mountMinimumLatency        = time.Duration(1) * time.Millisecond
+	mountLatencySamples        = 10 // the stat latency is a moving average of about 10 samples
+
+	// job throttle settings of the worker
+	throttleRefreshInterval = time.Duration(30) * time.Second // recompute the share of each job limit every 30 seconds
+	activeWorkerInterval    = time.Duration(2) * time.Minute  // a worker reads a job if it wrote the job progress within 2 minutes
+	throttleJobIdleTimeout  = time.Duration(10) * time.Minute // forget the throttle of a job not read for 10 minutes
+
+	// job state settings of the worker
+	timeBetweenIdleJobCheck = time.Duration(1) * time.Minute  // 1 minute between checking for idle jobs
+	jobIdleTimeout          = time.Duration(10) * time.Minute // forget the state of a job without work for 10 minutes
+
+	// worker scaling settings
+	initialWorkerJobsPerWorker = 10                              // the worker jobs per worker before the throughput is observed
+	scaleTargetDrainTime       = time.Duration(15) * time.Minute // size the workers to drain the work queue in 15 minutes
+	scaleSampleInterval        = time.Duration(1) * time.Minute  // sample the worker throughput at most once per minute
+	scaleDownDelay             = time.Duration(5) * time.Minute  // the capacity must be too large for 5 minutes before scaling down
+	throughputSmoothing        = 0.3                             // the weight of the newest throughput sample
+
+	// the failed paths recorded in the job report by each manager or worker process
+	maxReportedFailures = 100
 
 	// file read settings
 	ReadPageSize           = 10 * MB
@@ -0,0 +1,143 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DeadLetterJob is a worker job message that could not be processed, along with the reason for the failure
+type DeadLetterJob struct {
+	JobID          string
+	Reason         string
+	DequeueCount   int64
+	DeadLetterTime time.Time
+	// MessageText is the original worker job message, kept verbatim so malformed messages can be inspected
+	MessageText     string
+	queueMessageID  string
+	queuePopReceipt string
+}
+
+// InitializeDeadLetterJobFromString reads deadLetterJobContents
+func InitializeDeadLetterJobFromString(deadLetterJobContents string) (*DeadLetterJob, error) {
+	var result DeadLetterJob
+	if err := json.Unmarshal([]byte(deadLetterJobContents), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetDeadLetterJobFileContents returns the contents of the file
+func (j *DeadLetterJob) GetDeadLetterJobFileContents() (string, error) {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (j *DeadLetterJob) SetQueueMessageInfo(id string, popReceipt string) {
+	j.queueMessageID = id
+	j.queuePopReceipt = popReceipt
+}
+
+func (j *DeadLetterJob) GetQueueMessageInfo() (string, string) {
+	return j.queueMessageID, j.queuePopReceipt
+}
+
+// DeadLetterWorkerJob moves the worker job from the work queue to the dead letter queue
+func (q *CacheWarmerQueues) DeadLetterWorkerJob(workerJob *WorkerJob, reason string) error {
+	id, popReceipt := workerJob.GetQueueMessageInfo()
+	if len(id) == 0 || len(popReceipt) == 0 {
+		return fmt.Errorf("queue message id incorrectly set for workerjob")
+	}
+	message, err := workerJob.GetWorkerJobFileContents()
+	if err != nil {
+		return err
+	}
+	return q.deadLetterMessage(
+		q.getWorkQueue(workerJob.Priority),
+		&QueueMessage{
+			ID:           id,
+			PopReceipt:   popReceipt,
+			Text:         message,
+			DequeueCount: workerJob.GetDequeueCount(),
+		},
+		workerJob.JobID,
+		reason)
+}
+
+// deadLetterMessage writes the message to the dead letter queue before deleting it from the work queue,
+// so a failure in between results in a duplicate rather than a lost message
+func (q *CacheWarmerQueues) deadLetterMessage(workQueue CacheWarmerQueue, msg *QueueMessage, jobID string, reason string) error {
+	deadLetterJob := &DeadLetterJob{
+		JobID:          jobID,
+		Reason:         reason,
+		DequeueCount:   msg.DequeueCount,
+		DeadLetterTime: time.Now(),
+		MessageText:    msg.Text,
+	}
+	content, err := deadLetterJob.GetDeadLetterJobFileContents()
+	if err != nil {
+		return err
+	}
+	if err := q.deadLetterQueue.Enqueue(content); err != nil {
+		return fmt.Errorf("error writing dead letter job: %v", err)
+	}
+	if err := workQueue.DeleteMessage(msg.ID, msg.PopReceipt); err != nil {
+		return fmt.Errorf("error removing dead lettered message from the work queue: %v", err)
+	}
+	return nil
+}
+
+// GetDeadLetterJob dequeues the next dead letter job, it re-appears after the visibility timeout unless it is deleted
+func (q *CacheWarmerQueues) GetDeadLetterJob(visibilityTimeout time.Duration) (*DeadLetterJob, error) {
+	msg, err := q.deadLetterQueue.Dequeue(visibilityTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error dequeueing message %v", err)
+	}
+	if msg != nil {
+		return parseDeadLetterMessage(msg), nil
+	}
+	return nil, nil
+}
+
+// parseDeadLetterMessage returns the dead letter job of the message
+func parseDeadLetterMessage(msg *QueueMessage) *DeadLetterJob {
+	deadLetterJob, err := InitializeDeadLetterJobFromString(msg.Text)
+	if err != nil {
+		// keep the unparseable text so it can still be listed or purged
+		deadLetterJob = &DeadLetterJob{
+			Reason:      fmt.Sprintf("error parsing dead letter message: '%v'", err),
+			MessageText: msg.Text,
+		}
+	}
+	deadLetterJob.SetQueueMessageInfo(msg.ID, msg.PopReceipt)
+	return deadLetterJob
+}
+
+// RequeueDeadLetterJob writes the original worker job back to the work queue of its priority and deletes the dead letter job
+func (q *CacheWarmerQueues) RequeueDeadLetterJob(deadLetterJob *DeadLetterJob) error {
+	workerJob, err := InitializeWorkerJobFromString(deadLetterJob.MessageText)
+	if err != nil {
+		return fmt.Errorf("unable to requeue malformed worker job: %v", err)
+	}
+	if err := q.getWorkQueue(workerJob.Priority).Enqueue(deadLetterJob.MessageText); err != nil {
+		return fmt.Errorf("Error writing job: %v", err)
+	}
+	return q.DeleteDeadLetterJob(deadLetterJob)
+}
+
+func (q *CacheWarmerQueues) DeleteDeadLetterJob(deadLetterJob *DeadLetterJob) error {
+	id, popReceipt := deadLetterJob.GetQueueMessageInfo()
+	if len(id) == 0 || len(popReceipt) == 0 {
+		return fmt.Errorf("queue message id incorrectly set for deadletterjob")
+	}
+	if err := q.deadLetterQueue.DeleteMessage(id, popReceipt); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// ParseDeadline parses an RFC3339 time, or a duration after now, the empty string is no deadline
+func ParseDeadline(s string, now time.Time) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("'%s' is neither an RFC3339 time nor a positive duration", s)
+	}
+	return now.Add(d), nil
+}
+
+// isDeadlineExpired returns true once the deadline has passed, the zero deadline never expires
+func isDeadlineExpired(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// JobDeadlines holds the deadlines of the jobs of a worker, so the work items queued for a job are dropped once its
+// deadline passes
+type JobDeadlines struct {
+	mux       sync.Mutex
+	deadlines map[string]time.Time
+	expired   map[string]bool
+}
+
+// InitializeJobDeadlines initializes the deadlines of the jobs
+func InitializeJobDeadlines() *JobDeadlines {
+	return &JobDeadlines{
+		deadlines: make(map[string]time.Time),
+		expired:   make(map[string]bool),
+	}
+}
+
+// Register records the deadline of the job, jobs without an id or a deadline never expire
+func (d *JobDeadlines) Register(jobID string, deadline time.Time) {
+	if len(jobID) == 0 || deadline.IsZero() {
+		return
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.deadlines[jobID] = deadline
+}
+
+// Remove forgets the deadline of the job
+func (d *JobDeadlines) Remove(jobID string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	delete(d.deadlines, jobID)
+	delete(d.expired, jobID)
+}
+
+// IsExpired returns true if the deadline of the registered job has passed
+func (d *JobDeadlines) IsExpired(jobID string) bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	deadline, ok := d.deadlines[jobID]
+	if !ok || !isDeadlineExpired(deadline) {
+		return false
+	}
+	if !d.expired[jobID] {
+		d.expired[jobID] = true
+		log.Info.Printf("the deadline %s of job '%s' has passed, dropping its remaining work", deadline.Format(time.RFC3339), jobID)
+	}
+	return true
+}
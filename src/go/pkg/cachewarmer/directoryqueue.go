@@ -0,0 +1,239 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	directoryQueueTempPrefix = ".tmp-"
+)
+
+// DirectoryQueue implements CacheWarmerQueue as a directory on an NFS export shared by all cache warmer hosts.
+//
+// Each message is a file named "<id>.<popReceipt>", where the id sorts by enqueue time, and the pop receipt
+// is "<dequeueCount>.<visibleAtUnixNano>.<random>".  A host claims a message by atomically renaming it to a
+// new pop receipt, so only one host wins a dequeue or a visibility update.  Visibility relies on the
+// clocks of the hosts being reasonably in sync.
+type DirectoryQueue struct {
+	queuePath string
+}
+
+// InitializeDirectoryQueue mounts the export and creates the queue directory if it does not already exist
+func InitializeDirectoryQueue(mountAddress string, exportPath string, directoryPath string, queueName string) (*DirectoryQueue, error) {
+	queuePath, err := ensureJobPath(mountAddress, exportPath, directoryPath, queueName, true)
+	if err != nil {
+		return nil, fmt.Errorf("error creating queue directory '%s': %v", queuePath, err)
+	}
+	return &DirectoryQueue{queuePath: queuePath}, nil
+}
+
+func (q *DirectoryQueue) Enqueue(message string) error {
+	id := fmt.Sprintf("%020d-%s", time.Now().UnixNano(), uuid.New().String()[:8])
+	return q.writeMessage(getDirectoryQueueFilename(id, getDirectoryQueuePopReceipt(0, time.Time{})), message)
+}
+
+func (q *DirectoryQueue) Dequeue(visibilityTimeout time.Duration) (*QueueMessage, error) {
+	filenames, err := q.listMessages()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, filename := range filenames {
+		id, dequeueCount, visibleAt, err := parseDirectoryQueueFilename(filename)
+		if err != nil || visibleAt.After(now) {
+			continue
+		}
+		popReceipt := getDirectoryQueuePopReceipt(dequeueCount+1, now.Add(visibilityTimeout))
+		if err := os.Rename(path.Join(q.queuePath, filename), path.Join(q.queuePath, getDirectoryQueueFilename(id, popReceipt))); err != nil {
+			// another host has claimed the message
+			continue
+		}
+		text, err := ioutil.ReadFile(path.Join(q.queuePath, getDirectoryQueueFilename(id, popReceipt)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading message '%s': %v", id, err)
+		}
+		return &QueueMessage{
+			ID:           id,
+			PopReceipt:   popReceipt,
+			Text:         string(text),
+			DequeueCount: dequeueCount + 1,
+		}, nil
+	}
+	return nil, nil
+}
+
+func (q *DirectoryQueue) Peek() (*QueueMessage, error) {
+	messages, err := q.PeekMessages(1)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+func (q *DirectoryQueue) PeekMessages(maxMessages int32) ([]*QueueMessage, error) {
+	filenames, err := q.listMessages()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	messages := make([]*QueueMessage, 0)
+	for _, filename := range filenames {
+		if int32(len(messages)) >= maxMessages {
+			break
+		}
+		id, dequeueCount, visibleAt, err := parseDirectoryQueueFilename(filename)
+		if err != nil || visibleAt.After(now) {
+			continue
+		}
+		text, err := ioutil.ReadFile(path.Join(q.queuePath, filename))
+		if err != nil {
+			// the message was dequeued or deleted since listing
+			continue
+		}
+		messages = append(messages, &QueueMessage{
+			ID:           id,
+			Text:         string(text),
+			DequeueCount: dequeueCount,
+		})
+	}
+	return messages, nil
+}
+
+// UpdateVisibilityTimeout writes the new text to a temporary file, claims the message by renaming it to a hidden
+// file, and renames the temporary file to the new pop receipt, so the message only becomes visible again with its new
+// text, and a host holding a stale pop receipt cannot update it
+func (q *DirectoryQueue) UpdateVisibilityTimeout(id string, popReceipt string, visibilityTimeout time.Duration, message string) (string, error) {
+	_, dequeueCount, _, err := parseDirectoryQueueFilename(getDirectoryQueueFilename(id, popReceipt))
+	if err != nil {
+		return "", err
+	}
+	tmpFilename := q.getTempFilename()
+	if err := ioutil.WriteFile(tmpFilename, []byte(message), 0644); err != nil {
+		os.Remove(tmpFilename)
+		return "", fmt.Errorf("error writing message: %v", err)
+	}
+	filename := path.Join(q.queuePath, getDirectoryQueueFilename(id, popReceipt))
+	claimFilename := q.getTempFilename()
+	if err := os.Rename(filename, claimFilename); err != nil {
+		os.Remove(tmpFilename)
+		return "", fmt.Errorf("message '%s' not found with pop receipt '%s': %v", id, popReceipt, err)
+	}
+	newPopReceipt := getDirectoryQueuePopReceipt(dequeueCount, time.Now().Add(visibilityTimeout))
+	if err := os.Rename(tmpFilename, path.Join(q.queuePath, getDirectoryQueueFilename(id, newPopReceipt))); err != nil {
+		// restore the message with its previous pop receipt, so it is not lost
+		os.Rename(claimFilename, filename)
+		os.Remove(tmpFilename)
+		return "", fmt.Errorf("error writing message: %v", err)
+	}
+	os.Remove(claimFilename)
+	return newPopReceipt, nil
+}
+
+func (q *DirectoryQueue) DeleteMessage(id string, popReceipt string) error {
+	if err := os.Remove(path.Join(q.queuePath, getDirectoryQueueFilename(id, popReceipt))); err != nil {
+		return fmt.Errorf("message '%s' not found with pop receipt '%s': %v", id, popReceipt, err)
+	}
+	return nil
+}
+
+func (q *DirectoryQueue) IsQueueEmpty() (bool, error) {
+	filenames, err := q.listMessages()
+	if err != nil {
+		return false, err
+	}
+	return len(filenames) == 0, nil
+}
+
+func (q *DirectoryQueue) ApproximateMessageCount() (int64, error) {
+	filenames, err := q.listMessages()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(filenames)), nil
+}
+
+// Clear removes the message files, a message dequeued concurrently by another host may survive
+func (q *DirectoryQueue) Clear() error {
+	filenames, err := q.listMessages()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if err := os.Remove(path.Join(q.queuePath, filename)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing message '%s': %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// listMessages returns the message filenames sorted by enqueue time
+func (q *DirectoryQueue) listMessages() ([]string, error) {
+	dirEntries, err := ioutil.ReadDir(q.queuePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue directory '%s': %v", q.queuePath, err)
+	}
+	filenames := make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+		filenames = append(filenames, dirEntry.Name())
+	}
+	return filenames, nil
+}
+
+// writeMessage writes the message to a temporary file and renames it so readers never see a partial message
+func (q *DirectoryQueue) writeMessage(filename string, message string) error {
+	tmpFilename := q.getTempFilename()
+	if err := ioutil.WriteFile(tmpFilename, []byte(message), 0644); err != nil {
+		return fmt.Errorf("error writing message: %v", err)
+	}
+	if err := os.Rename(tmpFilename, path.Join(q.queuePath, filename)); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("error writing message: %v", err)
+	}
+	return nil
+}
+
+// getTempFilename returns a unique hidden filename in the queue directory, never listed as a message
+func (q *DirectoryQueue) getTempFilename() string {
+	return path.Join(q.queuePath, fmt.Sprintf("%s%s", directoryQueueTempPrefix, uuid.New().String()))
+}
+
+func getDirectoryQueueFilename(id string, popReceipt string) string {
+	return fmt.Sprintf("%s.%s", id, popReceipt)
+}
+
+func getDirectoryQueuePopReceipt(dequeueCount int64, visibleAt time.Time) string {
+	visibleAtUnixNano := int64(0)
+	if !visibleAt.IsZero() {
+		visibleAtUnixNano = visibleAt.UnixNano()
+	}
+	return fmt.Sprintf("%d.%d.%s", dequeueCount, visibleAtUnixNano, uuid.New().String()[:8])
+}
+
+func parseDirectoryQueueFilename(filename string) (string, int64, time.Time, error) {
+	parts := strings.Split(filename, ".")
+	if len(parts) != 4 {
+		return "", 0, time.Time{}, fmt.Errorf("invalid queue message filename '%s'", filename)
+	}
+	dequeueCount, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("invalid dequeue count in queue message filename '%s'", filename)
+	}
+	visibleAtUnixNano, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("invalid visibility in queue message filename '%s'", filename)
+	}
+	return parts[0], dequeueCount, time.Unix(0, visibleAtUnixNano), nil
+}
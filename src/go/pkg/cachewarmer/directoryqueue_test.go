@@ -0,0 +1,76 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// initializeTestDirectoryQueue returns a directory queue in a temporary directory, instead of on a mounted export
+func initializeTestDirectoryQueue(t *testing.T) *DirectoryQueue {
+	queuePath, err := ioutil.TempDir("", "directoryqueue")
+	if err != nil {
+		t.Fatalf("error creating queue directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(queuePath) })
+	return &DirectoryQueue{queuePath: queuePath}
+}
+
+func TestDirectoryQueueVisibilityTimeout(t *testing.T) {
+	testQueueVisibilityTimeout(t, initializeTestDirectoryQueue(t))
+}
+
+func TestDirectoryQueueDequeueCount(t *testing.T) {
+	testQueueDequeueCount(t, initializeTestDirectoryQueue(t))
+}
+
+func TestDirectoryQueueDeleteWithStalePopReceipt(t *testing.T) {
+	testQueueDeleteWithStalePopReceipt(t, initializeTestDirectoryQueue(t))
+}
+
+func TestDirectoryQueueOrder(t *testing.T) {
+	q := initializeTestDirectoryQueue(t)
+	for _, message := range []string{"first", "second"} {
+		if err := q.Enqueue(message); err != nil {
+			t.Fatalf("error enqueuing message: %v", err)
+		}
+	}
+	for _, expected := range []string{"first", "second"} {
+		msg, err := q.Dequeue(testVisibilityTimeout)
+		if err != nil || msg == nil {
+			t.Fatalf("expected a message, got %v, error %v", msg, err)
+		}
+		if msg.Text != expected {
+			t.Errorf("expected '%s', got '%s'", expected, msg.Text)
+		}
+	}
+}
+
+func TestDirectoryQueueReleaseWithNewText(t *testing.T) {
+	q := initializeTestDirectoryQueue(t)
+	if err := q.Enqueue("message"); err != nil {
+		t.Fatalf("error enqueuing message: %v", err)
+	}
+	msg, err := q.Dequeue(testVisibilityTimeout)
+	if err != nil || msg == nil {
+		t.Fatalf("expected a message, got %v, error %v", msg, err)
+	}
+	if _, err := q.UpdateVisibilityTimeout(msg.ID, msg.PopReceipt, 0, "updated"); err != nil {
+		t.Fatalf("error releasing message: %v", err)
+	}
+	if count, err := q.ApproximateMessageCount(); err != nil || count != 1 {
+		t.Fatalf("expected a single message, got %d, error %v", count, err)
+	}
+	released, err := q.Dequeue(testVisibilityTimeout)
+	if err != nil || released == nil {
+		t.Fatalf("expected the released message, got %v, error %v", released, err)
+	}
+	if released.Text != "updated" {
+		t.Errorf("expected text 'updated', got '%s'", released.Text)
+	}
+	if _, err := q.UpdateVisibilityTimeout(msg.ID, msg.PopReceipt, 0, "stale"); err == nil {
+		t.Errorf("expected an error updating with the stale pop receipt")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/Avere/src/go/pkg/azure"
+)
+
+// FileListEntry is a file to warm from an explicit file list, with an optional byte range.  Continued marks the later
+// byte ranges of a split entry, so the file is counted once.
+type FileListEntry struct {
+	Path      string
+	StartByte int64
+	StopByte  int64
+	Continued bool `json:",omitempty"`
+}
+
+// fileListError is an error of a file list that a retry does not fix, like a missing or unparseable file list
+type fileListError struct {
+	err error
+}
+
+func (e *fileListError) Error() string {
+	return e.err.Error()
+}
+
+// isFileListError returns true if the error is an error of the file list that a retry does not fix
+func isFileListError(err error) bool {
+	_, ok := err.(*fileListError)
+	return ok
+}
+
+// fileListJsonEntry is the json form of an entry, where a missing byte range warms the whole file
+type fileListJsonEntry struct {
+	Path      string `json:"path"`
+	StartByte *int64 `json:"startByte"`
+	StopByte  *int64 `json:"stopByte"`
+}
+
+// InitializeFileListEntry initializes an entry, and validates the path is within the warm target path
+func InitializeFileListEntry(filePath string, startByte int64, stopByte int64) (FileListEntry, error) {
+	cleanPath := path.Clean(strings.TrimPrefix(filePath, "/"))
+	if len(filePath) == 0 || cleanPath == "." || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+		return FileListEntry{}, fmt.Errorf("path '%s' is not a file within the warm target path", filePath)
+	}
+	if startByte != allFilesOrBytes || stopByte != allFilesOrBytes {
+		if startByte < 0 || stopByte <= startByte {
+			return FileListEntry{}, fmt.Errorf("byte range [%d,%d) of '%s' is not valid", startByte, stopByte, filePath)
+		}
+	}
+	return FileListEntry{
+		Path:      cleanPath,
+		StartByte: startByte,
+		StopByte:  stopByte,
+	}, nil
+}
+
+// ParseFileList parses either a json list, or one path per line.  The json list contains path strings or
+// objects with a path and an optional startByte and stopByte.  Each line contains a path, optionally followed
+// by a tab separated start and stop byte.  Paths are relative to the warm target path.
+func ParseFileList(data []byte) ([]FileListEntry, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return parseJsonFileList(data)
+	}
+
+	entries := []FileListEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*KB), MB)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		startByte, stopByte := allFilesOrBytes, allFilesOrBytes
+		switch len(fields) {
+		case 1:
+		case 3:
+			var err error
+			if startByte, err = strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: start byte '%s' is not valid: %v", lineNumber, fields[1], err)
+			}
+			if stopByte, err = strconv.ParseInt(strings.TrimSpace(fields[2]), 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: stop byte '%s' is not valid: %v", lineNumber, fields[2], err)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: expected a path, optionally followed by a tab separated start and stop byte", lineNumber)
+		}
+		entry, err := InitializeFileListEntry(fields[0], startByte, stopByte)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file list: %v", err)
+	}
+	return entries, nil
+}
+
+func parseJsonFileList(data []byte) ([]FileListEntry, error) {
+	var rawEntries []json.RawMessage
+	if err := json.Unmarshal(data, &rawEntries); err != nil {
+		return nil, fmt.Errorf("error parsing json file list: %v", err)
+	}
+	entries := make([]FileListEntry, 0, len(rawEntries))
+	for i, rawEntry := range rawEntries {
+		var jsonEntry fileListJsonEntry
+		if bytes.HasPrefix(bytes.TrimSpace(rawEntry), []byte("\"")) {
+			if err := json.Unmarshal(rawEntry, &jsonEntry.Path); err != nil {
+				return nil, fmt.Errorf("entry %d: %v", i, err)
+			}
+		} else if err := json.Unmarshal(rawEntry, &jsonEntry); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		startByte, stopByte := allFilesOrBytes, allFilesOrBytes
+		if jsonEntry.StartByte != nil || jsonEntry.StopByte != nil {
+			if jsonEntry.StartByte == nil || jsonEntry.StopByte == nil {
+				return nil, fmt.Errorf("entry %d: both startByte and stopByte must be specified", i)
+			}
+			startByte, stopByte = *jsonEntry.StartByte, *jsonEntry.StopByte
+		}
+		entry, err := InitializeFileListEntry(jsonEntry.Path, startByte, stopByte)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ReadFileListFromExport reads the file list at the path on the export
+func ReadFileListFromExport(mountAddress string, exportPath string, fileListPath string) ([]FileListEntry, error) {
+	localMountPath := GetLocalMountPath(mountAddress, exportPath)
+	if err := MountPath(mountAddress, exportPath, localMountPath); err != nil {
+		return nil, fmt.Errorf("error trying to mount %s:%s: %v", mountAddress, exportPath, err)
+	}
+	data, err := ioutil.ReadFile(path.Join(localMountPath, fileListPath))
+	if os.IsNotExist(err) {
+		return nil, &fileListError{err: fmt.Errorf("file list '%s' does not exist", fileListPath)}
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading file list '%s': %v", fileListPath, err)
+	}
+	entries, err := ParseFileList(data)
+	if err != nil {
+		return nil, &fileListError{err: fmt.Errorf("file list '%s' is not valid: %v", fileListPath, err)}
+	}
+	return entries, nil
+}
+
+// ReadFileListFromBlob reads the file list from the blob, specified as '<container>/<blob name>'
+func ReadFileListFromBlob(ctx context.Context, storageAccount string, storageKey string, fileListBlob string) ([]FileListEntry, error) {
+	parts := strings.SplitN(strings.TrimPrefix(fileListBlob, "/"), "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, &fileListError{err: fmt.Errorf("file list blob '%s' must be specified as '<container>/<blob name>'", fileListBlob)}
+	}
+	if len(storageAccount) == 0 || len(storageKey) == 0 {
+		return nil, &fileListError{err: fmt.Errorf("the storage account is required to read file list blob '%s'", fileListBlob)}
+	}
+	blobContainer, err := azure.InitializeBlobContainer(ctx, storageAccount, storageKey, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("error initializing blob container '%s': %v", parts[0], err)
+	}
+	data, err := blobContainer.DownloadBlob(parts[1])
+	if azure.IsBlobNotFound(err) {
+		return nil, &fileListError{err: fmt.Errorf("file list blob '%s' does not exist", fileListBlob)}
+	} else if err != nil {
+		return nil, fmt.Errorf("error downloading file list blob '%s': %v", fileListBlob, err)
+	}
+	entries, err := ParseFileList(data)
+	if err != nil {
+		return nil, &fileListError{err: fmt.Errorf("file list blob '%s' is not valid: %v", fileListBlob, err)}
+	}
+	return entries, nil
+}
+
+// splitFileListEntries gives the entries of the large files under the local path the byte range of the whole file,
+// and splits the byte ranges larger than the maximum job size, so each worker job reads at most the maximum job size
+// like the large file jobs of an enumerated directory.  The entries that cannot be read are left for the worker to
+// report.
+func splitFileListEntries(entries []FileListEntry, localPath string) []FileListEntry {
+	result := make([]FileListEntry, 0, len(entries))
+	for _, entry := range entries {
+		startByte, stopByte := entry.StartByte, entry.StopByte
+		if startByte == allFilesOrBytes {
+			fileInfo, err := os.Stat(path.Join(localPath, entry.Path))
+			if err != nil || fileInfo.IsDir() || fileInfo.Size() < MinimumSingleFileSize {
+				result = append(result, entry)
+				continue
+			}
+			startByte, stopByte = 0, fileInfo.Size()
+		}
+		for i := startByte; i < stopByte; i += MaximumJobSize {
+			end := i + MaximumJobSize
+			if end > stopByte {
+				end = stopByte
+			}
+			result = append(result, FileListEntry{
+				Path:      entry.Path,
+				StartByte: i,
+				StopByte:  end,
+				Continued: i > startByte,
+			})
+		}
+	}
+	return result
+}
+
+// groupFileListEntries splits the entries into lists small enough to fit in a worker job, where the byte ranges of
+// a list add up to at most the maximum job size
+func groupFileListEntries(entries []FileListEntry) [][]FileListEntry {
+	result := [][]FileListEntry{}
+	group := []FileListEntry{}
+	groupBytes := 0
+	groupReadBytes := int64(0)
+	for _, entry := range entries {
+		// allow for the field names and byte range of each json object
+		entryBytes := len(entry.Path) + 100
+		entryReadBytes := int64(0)
+		if entry.StartByte != allFilesOrBytes {
+			entryReadBytes = entry.StopByte - entry.StartByte
+		}
+		if len(group) >= MaximumFilesToRead || (len(group) > 0 && (groupBytes+entryBytes > MaximumFileListBytes || groupReadBytes+entryReadBytes > MaximumJobSize)) {
+			result = append(result, group)
+			group = []FileListEntry{}
+			groupBytes = 0
+			groupReadBytes = 0
+		}
+		group = append(group, entry)
+		groupBytes += entryBytes
+		groupReadBytes += entryReadBytes
+	}
+	if len(group) > 0 {
+		result = append(result, group)
+	}
+	return result
+}
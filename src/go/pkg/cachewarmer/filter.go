@@ -0,0 +1,288 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FileFilter selects the files of a job to warm, and is embedded in the JobSettings of the WarmPathJob and WorkerJob.
+//
+// InclusionList and ExclusionList match the base filename.  PathInclusionList and PathExclusionList
+// match the path relative to FilterRootPath one segment at a time per https://golang.org/pkg/path/filepath/#Match,
+// and a pattern matching a directory matches everything beneath it, so an excluded directory is never
+// enumerated.  The regexes match the relative path.  The zero value of each field does not filter anything.
+type FileFilter struct {
+	FilterRootPath    string
+	InclusionList     []string
+	ExclusionList     []string
+	PathInclusionList []string
+	PathExclusionList []string
+	InclusionRegex    string
+	ExclusionRegex    string
+	MinFileSizeBytes  int64
+	MaxFileSizeBytes  int64
+	ModifiedAfter     time.Time
+	ModifiedBefore    time.Time
+	inclusionRegexp   *regexp.Regexp
+	exclusionRegexp   *regexp.Regexp
+}
+
+// the reasons a file is skipped, reported per job
+const (
+	SkipReasonMaxFileSize    = "maxFileSize"
+	SkipReasonFileName       = "fileName"
+	SkipReasonMinFileSize    = "minFileSize"
+	SkipReasonModifiedAfter  = "modifiedAfter"
+	SkipReasonModifiedBefore = "modifiedBefore"
+	SkipReasonPathExclusion  = "pathExclusion"
+	SkipReasonPathInclusion  = "pathInclusion"
+	SkipReasonExclusionRegex = "exclusionRegex"
+	SkipReasonInclusionRegex = "inclusionRegex"
+	SkipReasonInvalidFilter  = "invalidFilter"
+	SkipReasonUnchanged      = "unchanged"
+
+	// the symlinks and hard links skipped by the traversal of the warm target path
+	SkipReasonSymlink              = "symlink"
+	SkipReasonBrokenSymlink        = "brokenSymlink"
+	SkipReasonSymlinkOutsideExport = "symlinkOutsideExport"
+	SkipReasonSymlinkLoop          = "symlinkLoop"
+	SkipReasonDuplicateFile        = "duplicateFile"
+)
+
+// InitializeFileFilter initializes the file filter, and returns an error if a pattern or regex is invalid
+func InitializeFileFilter(
+	filterRootPath string,
+	inclusionCsv string,
+	exclusionCsv string,
+	pathInclusionCsv string,
+	pathExclusionCsv string,
+	inclusionRegex string,
+	exclusionRegex string,
+	minFileSizeBytes int64,
+	maxFileSizeBytes int64,
+	modifiedAfter time.Time,
+	modifiedBefore time.Time) (*FileFilter, error) {
+
+	fileFilter := &FileFilter{
+		FilterRootPath:    filterRootPath,
+		InclusionList:     prepareCsvList(inclusionCsv),
+		ExclusionList:     prepareCsvList(exclusionCsv),
+		PathInclusionList: prepareCsvList(pathInclusionCsv),
+		PathExclusionList: prepareCsvList(pathExclusionCsv),
+		InclusionRegex:    inclusionRegex,
+		ExclusionRegex:    exclusionRegex,
+		MinFileSizeBytes:  minFileSizeBytes,
+		MaxFileSizeBytes:  maxFileSizeBytes,
+		ModifiedAfter:     modifiedAfter,
+		ModifiedBefore:    modifiedBefore,
+	}
+
+	if err := fileFilter.Validate(); err != nil {
+		return nil, err
+	}
+
+	return fileFilter, nil
+}
+
+// ParseModifiedTime parses an RFC3339 time, or a duration before now, the empty string is the zero time
+func ParseModifiedTime(s string, now time.Time) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither an RFC3339 time nor a duration", s)
+	}
+	return now.Add(-d), nil
+}
+
+// Validate returns an error if a pattern, regex, or range of the filter is invalid
+func (f *FileFilter) Validate() error {
+	for _, list := range [][]string{f.InclusionList, f.ExclusionList, f.PathInclusionList, f.PathExclusionList} {
+		for _, pattern := range list {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+			}
+		}
+	}
+	if err := f.compileRegexes(); err != nil {
+		return err
+	}
+	if f.MaxFileSizeBytes != 0 && f.MinFileSizeBytes > f.MaxFileSizeBytes {
+		return fmt.Errorf("minimum file size %d is larger than the maximum file size %d", f.MinFileSizeBytes, f.MaxFileSizeBytes)
+	}
+	if !f.ModifiedAfter.IsZero() && !f.ModifiedBefore.IsZero() && !f.ModifiedAfter.Before(f.ModifiedBefore) {
+		return fmt.Errorf("modified after time %v is not before the modified before time %v", f.ModifiedAfter, f.ModifiedBefore)
+	}
+	return nil
+}
+
+// DirectoryMatches returns false if the directory and everything beneath it is filtered out
+func (f *FileFilter) DirectoryMatches(dirPath string) bool {
+	relativePath := f.getRelativePath(dirPath)
+	if len(relativePath) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.PathExclusionList {
+		if pathPatternMatches(pattern, relativePath) {
+			return false
+		}
+	}
+
+	if len(f.PathInclusionList) == 0 {
+		return true
+	}
+
+	// descend if the directory is included, or a pattern may match beneath it
+	for _, pattern := range f.PathInclusionList {
+		if pathPatternMatches(pattern, relativePath) || pathPatternPrefixMatches(pattern, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileMatches returns true if the file at filePath is selected by the filter
+func (f *FileFilter) FileMatches(filePath string, filesize int64, modTime time.Time) bool {
+	return len(f.FileSkipReason(filePath, filesize, modTime)) == 0
+}
+
+// FileSkipReason returns the reason the file at filePath is filtered out, or the empty string if selected
+func (f *FileFilter) FileSkipReason(filePath string, filesize int64, modTime time.Time) string {
+	if f.MaxFileSizeBytes != 0 && filesize > f.MaxFileSizeBytes {
+		return SkipReasonMaxFileSize
+	}
+
+	if !FileMatches(f.InclusionList, f.ExclusionList, 0, path.Base(filePath), filesize) {
+		return SkipReasonFileName
+	}
+
+	if f.MinFileSizeBytes != 0 && filesize < f.MinFileSizeBytes {
+		return SkipReasonMinFileSize
+	}
+
+	if !f.ModifiedAfter.IsZero() && modTime.Before(f.ModifiedAfter) {
+		return SkipReasonModifiedAfter
+	}
+
+	if !f.ModifiedBefore.IsZero() && !modTime.Before(f.ModifiedBefore) {
+		return SkipReasonModifiedBefore
+	}
+
+	relativePath := f.getRelativePath(filePath)
+
+	// exclusion takes priority
+	for _, pattern := range f.PathExclusionList {
+		if pathPatternMatches(pattern, relativePath) {
+			return SkipReasonPathExclusion
+		}
+	}
+
+	if len(f.PathInclusionList) > 0 {
+		included := false
+		for _, pattern := range f.PathInclusionList {
+			if pathPatternMatches(pattern, relativePath) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return SkipReasonPathInclusion
+		}
+	}
+
+	if err := f.compileRegexes(); err != nil {
+		// the filter is validated on submission, so only a corrupt job reaches here
+		return SkipReasonInvalidFilter
+	}
+
+	if f.exclusionRegexp != nil && f.exclusionRegexp.MatchString(relativePath) {
+		return SkipReasonExclusionRegex
+	}
+
+	if f.inclusionRegexp != nil && !f.inclusionRegexp.MatchString(relativePath) {
+		return SkipReasonInclusionRegex
+	}
+
+	return ""
+}
+
+func (f *FileFilter) compileRegexes() error {
+	if len(f.InclusionRegex) > 0 && f.inclusionRegexp == nil {
+		r, err := regexp.Compile(f.InclusionRegex)
+		if err != nil {
+			return fmt.Errorf("invalid inclusion regex '%s': %v", f.InclusionRegex, err)
+		}
+		f.inclusionRegexp = r
+	}
+	if len(f.ExclusionRegex) > 0 && f.exclusionRegexp == nil {
+		r, err := regexp.Compile(f.ExclusionRegex)
+		if err != nil {
+			return fmt.Errorf("invalid exclusion regex '%s': %v", f.ExclusionRegex, err)
+		}
+		f.exclusionRegexp = r
+	}
+	return nil
+}
+
+// getRelativePath returns the path relative to the filter root path, without a leading slash
+func (f *FileFilter) getRelativePath(fullPath string) string {
+	root := path.Join("/", f.FilterRootPath)
+	p := path.Join("/", fullPath)
+	if root != "/" {
+		if p == root {
+			return ""
+		}
+		p = strings.TrimPrefix(p, root+"/")
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// pathPatternMatches returns true if the pattern matches the relative path or one of its parent directories
+func pathPatternMatches(pattern string, relativePath string) bool {
+	patternSegments := splitPath(pattern)
+	pathSegments := splitPath(relativePath)
+	if len(patternSegments) == 0 || len(patternSegments) > len(pathSegments) {
+		return false
+	}
+	return segmentsMatch(patternSegments, pathSegments[:len(patternSegments)])
+}
+
+// pathPatternPrefixMatches returns true if the directory path matches the leading segments of the pattern
+func pathPatternPrefixMatches(pattern string, dirPath string) bool {
+	patternSegments := splitPath(pattern)
+	pathSegments := splitPath(dirPath)
+	if len(pathSegments) >= len(patternSegments) {
+		return false
+	}
+	return segmentsMatch(patternSegments[:len(pathSegments)], pathSegments)
+}
+
+func segmentsMatch(patternSegments []string, pathSegments []string) bool {
+	for i := range patternSegments {
+		if matched, err := filepath.Match(patternSegments[i], pathSegments[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(p string) []string {
+	result := []string{}
+	for _, s := range strings.Split(p, "/") {
+		if len(s) > 0 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
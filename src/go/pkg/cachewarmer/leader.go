@@ -0,0 +1,252 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/azure"
+	"github.com/Azure/Avere/src/go/pkg/log"
+	"github.com/google/uuid"
+)
+
+// Lease is held by at most one manager of a queue name prefix, and expires unless renewed
+type Lease interface {
+	// Acquire acquires or renews the lease, and returns false if another manager holds the lease
+	Acquire() (bool, error)
+	// Release releases the lease held by this manager
+	Release() error
+}
+
+// blobLease implements Lease with a blob lease in the storage account of the queues
+type blobLease struct {
+	lease *azure.BlobLease
+}
+
+// InitializeBlobLease initializes the blob lease of the queue name prefix
+func InitializeBlobLease(ctx context.Context, storageAccount string, storageKey string, queueNamePrefix string) (Lease, error) {
+	lease, err := azure.InitializeBlobLease(ctx, storageAccount, storageKey, LeaseContainerName, queueNamePrefix, uuid.New().String())
+	if err != nil {
+		return nil, err
+	}
+	return &blobLease{lease: lease}, nil
+}
+
+func (l *blobLease) Acquire() (bool, error) {
+	return l.lease.Acquire(leaseDuration)
+}
+
+func (l *blobLease) Release() error {
+	return l.lease.Release()
+}
+
+// leaseRecord is the holder of a file lease
+type leaseRecord struct {
+	Holder     string    `json:"holder"`
+	ExpiryTime time.Time `json:"expiryTime"`
+}
+
+// fileLease implements Lease with a lease file on an NFS export shared by the managers, or a local path.  The expiry
+// time is compared with the clock of each manager, so the clocks of the managers must be synchronized.
+type fileLease struct {
+	mountAddress    string
+	exportPath      string
+	queueNamePrefix string
+	holder          string
+}
+
+// InitializeFileLease initializes the file lease of the queue name prefix, written below the job progress directory
+// of the export
+func InitializeFileLease(mountAddress string, exportPath string, queueNamePrefix string) Lease {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "manager"
+	}
+	return &fileLease{
+		mountAddress:    mountAddress,
+		exportPath:      exportPath,
+		queueNamePrefix: queueNamePrefix,
+		holder:          fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+	}
+}
+
+func (l *fileLease) getLeaseFilename() (string, error) {
+	leasePath, err := ensureJobPath(l.mountAddress, l.exportPath, JobProgressDirectory, LeaseDirectory, true)
+	if err != nil {
+		return "", fmt.Errorf("error creating lease directory '%s': %v", leasePath, err)
+	}
+	return path.Join(leasePath, fmt.Sprintf("%s%s", l.queueNamePrefix, LeaseFileSuffix)), nil
+}
+
+// readLeaseRecord returns the holder of the lease, or nil if the lease was never acquired or was released
+func readLeaseRecord(filename string) (*leaseRecord, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record leaseRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("error parsing lease file '%s': %v", filename, err)
+	}
+	return &record, nil
+}
+
+func (l *fileLease) writeLeaseRecord(filename string) error {
+	data, err := json.Marshal(leaseRecord{Holder: l.holder, ExpiryTime: time.Now().Add(leaseDuration)})
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename so readers never see a partial record
+	tmpFilename := fmt.Sprintf("%s.%s.tmp", filename, l.holder)
+	if err := ioutil.WriteFile(tmpFilename, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func (l *fileLease) Acquire() (bool, error) {
+	filename, err := l.getLeaseFilename()
+	if err != nil {
+		return false, err
+	}
+	record, err := readLeaseRecord(filename)
+	if err != nil {
+		return false, err
+	}
+	held := record != nil && record.Holder == l.holder
+	if record != nil && !held && time.Now().Before(record.ExpiryTime) {
+		return false, nil
+	}
+	if err := l.writeLeaseRecord(filename); err != nil {
+		return false, err
+	}
+	if held {
+		return true, nil
+	}
+	// managers taking over the same expired lease each write the lease file, and the last write wins
+	time.Sleep(leaseSettleTime)
+	record, err = readLeaseRecord(filename)
+	if err != nil {
+		return false, err
+	}
+	return record != nil && record.Holder == l.holder, nil
+}
+
+func (l *fileLease) Release() error {
+	filename, err := l.getLeaseFilename()
+	if err != nil {
+		return err
+	}
+	record, err := readLeaseRecord(filename)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Holder != l.holder {
+		return nil
+	}
+	return os.Remove(filename)
+}
+
+// LeaderElector runs the leader functions of the manager while it holds the lease of the queue name prefix.  The
+// standby managers wait, and one of them takes over when the lease of the leader expires.
+type LeaderElector struct {
+	lease Lease
+	// lead starts the goroutines of the leader, which stop when the context is cancelled
+	lead            func(ctx context.Context, syncWaitGroup *sync.WaitGroup)
+	leaderCancel    context.CancelFunc
+	leaderWaitGroup sync.WaitGroup
+	lastRenewal     time.Time
+	// set to 1 while this manager is the leader
+	leader int64
+}
+
+// InitializeLeaderElector initializes the leader election of the manager
+func InitializeLeaderElector(lease Lease, lead func(ctx context.Context, syncWaitGroup *sync.WaitGroup)) *LeaderElector {
+	return &LeaderElector{
+		lease: lease,
+		lead:  lead,
+	}
+}
+
+func (e *LeaderElector) isLeader() bool {
+	return e.leaderCancel != nil
+}
+
+// renew acquires or renews the lease, and starts or stops leading
+func (e *LeaderElector) renew(ctx context.Context) {
+	acquired, err := e.lease.Acquire()
+	if err != nil {
+		log.Error.Printf("error acquiring the manager lease: %v", err)
+		Metrics.LeaseFailures.Inc()
+		// keep leading until the lease may have expired
+		if e.isLeader() && time.Since(e.lastRenewal) > leaseDuration-leaseRenewInterval {
+			log.Warning.Printf("unable to renew the manager lease since %v, no longer leading", e.lastRenewal)
+			e.stopLeading()
+		}
+		return
+	}
+	if !acquired {
+		if e.isLeader() {
+			log.Warning.Printf("the manager lease is held by another manager, no longer leading")
+			e.stopLeading()
+		} else {
+			log.Debug.Printf("the manager lease is held by another manager, waiting")
+		}
+		return
+	}
+	e.lastRenewal = time.Now()
+	if !e.isLeader() {
+		log.Status.Printf("acquired the manager lease, leading")
+		leaderCtx, leaderCancel := context.WithCancel(ctx)
+		e.leaderCancel = leaderCancel
+		atomic.StoreInt64(&e.leader, 1)
+		e.lead(leaderCtx, &e.leaderWaitGroup)
+	}
+}
+
+// stopLeading stops the goroutines of the leader, and waits for them to finish
+func (e *LeaderElector) stopLeading() {
+	e.leaderCancel()
+	e.leaderWaitGroup.Wait()
+	e.leaderCancel = nil
+	atomic.StoreInt64(&e.leader, 0)
+}
+
+// RunLeaderElection acquires and renews the lease until the context is cancelled, and releases the lease on return
+func (e *LeaderElector) RunLeaderElection(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	log.Debug.Printf("[LeaderElector.RunLeaderElection")
+	defer log.Debug.Printf("LeaderElector.RunLeaderElection]")
+	defer syncWaitGroup.Done()
+
+	Metrics.Leader.SetFunc(func() int64 { return atomic.LoadInt64(&e.leader) })
+
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	e.renew(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			if e.isLeader() {
+				e.stopLeading()
+				if err := e.lease.Release(); err != nil {
+					log.Error.Printf("error releasing the manager lease: %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+			e.renew(ctx)
+		}
+	}
+}
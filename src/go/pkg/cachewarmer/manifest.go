@@ -0,0 +1,242 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/azure"
+)
+
+// ManifestEntry records the size and modification time of a file at the time it was queued for warming
+type ManifestEntry struct {
+	Path            string
+	Size            int64
+	ModTimeUnixNano int64
+}
+
+// Manifest records the files previously warmed for a warm target path, so later jobs only warm the new or changed
+// files.  The manifest is stored as one json entry per line on the warm target export, or in a blob container of
+// the queue storage account.  A job saves the files it queued as a pending manifest on the export, and the pending
+// manifest only replaces the manifest once the job completes without failures or expired work.
+type Manifest struct {
+	mountAddress    string
+	exportPath      string
+	warmTargetPath  string
+	jobID           string
+	blobContainer   *azure.BlobContainer
+	previousEntries map[string]ManifestEntry
+	currentEntries  map[string]ManifestEntry
+}
+
+// LoadManifest reads the manifest of the warm target path of the job, a missing manifest results in an empty manifest
+func LoadManifest(ctx context.Context, storageAccount string, storageKey string, warmPathJob *WarmPathJob) (*Manifest, error) {
+	manifest := &Manifest{
+		mountAddress:    warmPathJob.WarmTargetMountAddresses[0],
+		exportPath:      warmPathJob.WarmTargetExportPath,
+		warmTargetPath:  warmPathJob.WarmTargetPath,
+		jobID:           warmPathJob.JobID,
+		previousEntries: make(map[string]ManifestEntry),
+		currentEntries:  make(map[string]ManifestEntry),
+	}
+
+	manifestPath, err := ensureJobPath(manifest.mountAddress, manifest.exportPath, JobProgressDirectory, ManifestDirectory, true)
+	if err != nil {
+		return nil, fmt.Errorf("error creating manifest directory '%s': %v", manifestPath, err)
+	}
+
+	if len(warmPathJob.ManifestBlobContainer) > 0 {
+		manifest.blobContainer, err = initializeManifestBlobContainer(ctx, storageAccount, storageKey, warmPathJob.ManifestBlobContainer)
+		if err != nil {
+			return nil, err
+		}
+		data, err := manifest.blobContainer.DownloadBlob(getManifestBlobName(manifest.exportPath, manifest.warmTargetPath))
+		if err != nil {
+			if azure.IsBlobNotFound(err) {
+				return manifest, nil
+			}
+			return nil, fmt.Errorf("error downloading manifest: %v", err)
+		}
+		if err := manifest.readEntries(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+
+	f, err := os.Open(path.Join(manifestPath, getManifestFilename(manifest.warmTargetPath)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("error opening manifest: %v", err)
+	}
+	defer f.Close()
+
+	if err := manifest.readEntries(f); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// readEntries reads the json entries of the previous manifest
+func (m *Manifest) readEntries(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*KB), MB)
+	for scanner.Scan() {
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("error parsing manifest entry '%s': %v", scanner.Text(), err)
+		}
+		m.previousEntries[entry.Path] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading manifest: %v", err)
+	}
+	return nil
+}
+
+// IsChanged returns true if the file is not in the previous manifest, or has a different size or modification time
+func (m *Manifest) IsChanged(filePath string, fileInfo os.FileInfo) bool {
+	entry, ok := m.previousEntries[filePath]
+	return !ok || entry.Size != fileInfo.Size() || entry.ModTimeUnixNano != fileInfo.ModTime().UnixNano()
+}
+
+// Record adds the file to the manifest saved at the end of the enumeration
+func (m *Manifest) Record(filePath string, fileInfo os.FileInfo) {
+	m.currentEntries[filePath] = ManifestEntry{
+		Path:            filePath,
+		Size:            fileInfo.Size(),
+		ModTimeUnixNano: fileInfo.ModTime().UnixNano(),
+	}
+}
+
+// SavePending writes the recorded files to the pending manifest of the job, committed by CommitManifest once the
+// job completes.  Previous entries that do not match the filter of the job are kept, since they were not enumerated
+// by the job, and the remaining previous entries are dropped as they no longer exist.
+func (m *Manifest) SavePending(fileFilter *FileFilter) error {
+	manifestPath, err := ensureJobPath(m.mountAddress, m.exportPath, JobProgressDirectory, ManifestDirectory, true)
+	if err != nil {
+		return fmt.Errorf("error creating manifest directory '%s': %v", manifestPath, err)
+	}
+
+	for filePath, entry := range m.previousEntries {
+		if _, ok := m.currentEntries[filePath]; ok {
+			continue
+		}
+		if !fileFilter.FileMatches(filePath, entry.Size, time.Unix(0, entry.ModTimeUnixNano)) {
+			m.currentEntries[filePath] = entry
+		}
+	}
+
+	// write to a temporary file and rename so a partial manifest is never read
+	filename := path.Join(manifestPath, getPendingManifestFilename(m.warmTargetPath, m.jobID))
+	tmpFilename := fmt.Sprintf("%s.tmp", filename)
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("error creating manifest: %v", err)
+	}
+	writer := bufio.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range m.currentEntries {
+		if err := encoder.Encode(entry); err != nil {
+			f.Close()
+			os.Remove(tmpFilename)
+			return fmt.Errorf("error writing manifest: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmpFilename)
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// CommitManifest replaces the manifest of the warm target path of the job with its pending manifest, a job without
+// a pending manifest leaves the manifest unchanged
+func CommitManifest(ctx context.Context, storageAccount string, storageKey string, warmPathJob *WarmPathJob) error {
+	localMountPath := GetLocalMountPath(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath)
+	manifestPath := path.Join(localMountPath, JobProgressDirectory, ManifestDirectory)
+	pendingFilename := path.Join(manifestPath, getPendingManifestFilename(warmPathJob.WarmTargetPath, warmPathJob.JobID))
+	if _, err := os.Stat(pendingFilename); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if len(warmPathJob.ManifestBlobContainer) > 0 {
+		blobContainer, err := initializeManifestBlobContainer(ctx, storageAccount, storageKey, warmPathJob.ManifestBlobContainer)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(pendingFilename)
+		if err != nil {
+			return fmt.Errorf("error reading pending manifest: %v", err)
+		}
+		if err := blobContainer.UploadBlob(getManifestBlobName(warmPathJob.WarmTargetExportPath, warmPathJob.WarmTargetPath), data); err != nil {
+			return err
+		}
+		return os.Remove(pendingFilename)
+	}
+
+	return os.Rename(pendingFilename, path.Join(manifestPath, getManifestFilename(warmPathJob.WarmTargetPath)))
+}
+
+// DiscardManifest removes the pending manifest of the job, so the files of a job that did not complete cleanly are
+// warmed again by the next job
+func DiscardManifest(warmPathJob *WarmPathJob) error {
+	localMountPath := GetLocalMountPath(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath)
+	pendingFilename := path.Join(localMountPath, JobProgressDirectory, ManifestDirectory, getPendingManifestFilename(warmPathJob.WarmTargetPath, warmPathJob.JobID))
+	if err := os.Remove(pendingFilename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// initializeManifestBlobContainer initializes the blob container of the manifests in the queue storage account
+func initializeManifestBlobContainer(ctx context.Context, storageAccount string, storageKey string, containerName string) (*azure.BlobContainer, error) {
+	if len(storageAccount) == 0 || len(storageKey) == 0 {
+		return nil, fmt.Errorf("the storage account is required to store the manifest in blob container '%s'", containerName)
+	}
+	blobContainer, err := azure.InitializeBlobContainer(ctx, storageAccount, storageKey, containerName)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing blob container '%s': %v", containerName, err)
+	}
+	return blobContainer, nil
+}
+
+// getManifestHash returns the hash of the path that names the manifest
+func getManifestHash(manifestPath string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(path.Join("/", manifestPath))))
+}
+
+// getManifestFilename returns the manifest filename on the export, a hash of the warm target path
+func getManifestFilename(warmTargetPath string) string {
+	return fmt.Sprintf("%s%s", getManifestHash(warmTargetPath), JobProgressFileSuffix)
+}
+
+// getPendingManifestFilename returns the filename of the pending manifest of the job
+func getPendingManifestFilename(warmTargetPath string, jobID string) string {
+	return fmt.Sprintf("%s.%s%s", getManifestHash(warmTargetPath), jobID, PendingManifestSuffix)
+}
+
+// getManifestBlobName returns the manifest blob name, a hash of the export path and the warm target path, since a
+// blob container may hold the manifests of several exports
+func getManifestBlobName(exportPath string, warmTargetPath string) string {
+	return fmt.Sprintf("%s%s", getManifestHash(path.Join(exportPath, warmTargetPath)), JobProgressFileSuffix)
+}
@@ -0,0 +1,147 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryQueueMessage struct {
+	id           string
+	popReceipt   string
+	text         string
+	dequeueCount int64
+	visibleAt    time.Time
+}
+
+// MemoryQueue implements CacheWarmerQueue in memory, and is only shared within a single process
+type MemoryQueue struct {
+	mux      sync.Mutex
+	messages []*memoryQueueMessage
+}
+
+// InitializeMemoryQueue initializes an empty in-memory queue
+func InitializeMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (q *MemoryQueue) Enqueue(message string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.messages = append(q.messages, &memoryQueueMessage{
+		id:        uuid.New().String(),
+		text:      message,
+		visibleAt: time.Now(),
+	})
+	return nil
+}
+
+func (q *MemoryQueue) Dequeue(visibilityTimeout time.Duration) (*QueueMessage, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	now := time.Now()
+	for _, msg := range q.messages {
+		if msg.visibleAt.After(now) {
+			continue
+		}
+		msg.popReceipt = uuid.New().String()
+		msg.dequeueCount++
+		msg.visibleAt = now.Add(visibilityTimeout)
+		return &QueueMessage{
+			ID:           msg.id,
+			PopReceipt:   msg.popReceipt,
+			Text:         msg.text,
+			DequeueCount: msg.dequeueCount,
+		}, nil
+	}
+	return nil, nil
+}
+
+func (q *MemoryQueue) Peek() (*QueueMessage, error) {
+	messages, err := q.PeekMessages(1)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+func (q *MemoryQueue) PeekMessages(maxMessages int32) ([]*QueueMessage, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	now := time.Now()
+	messages := make([]*QueueMessage, 0)
+	for _, msg := range q.messages {
+		if int32(len(messages)) >= maxMessages {
+			break
+		}
+		if msg.visibleAt.After(now) {
+			continue
+		}
+		messages = append(messages, &QueueMessage{
+			ID:           msg.id,
+			Text:         msg.text,
+			DequeueCount: msg.dequeueCount,
+		})
+	}
+	return messages, nil
+}
+
+func (q *MemoryQueue) UpdateVisibilityTimeout(id string, popReceipt string, visibilityTimeout time.Duration, message string) (string, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	i, err := q.find(id, popReceipt)
+	if err != nil {
+		return "", err
+	}
+	msg := q.messages[i]
+	msg.popReceipt = uuid.New().String()
+	msg.text = message
+	msg.visibleAt = time.Now().Add(visibilityTimeout)
+	return msg.popReceipt, nil
+}
+
+func (q *MemoryQueue) DeleteMessage(id string, popReceipt string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	i, err := q.find(id, popReceipt)
+	if err != nil {
+		return err
+	}
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+	return nil
+}
+
+func (q *MemoryQueue) IsQueueEmpty() (bool, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return len(q.messages) == 0, nil
+}
+
+func (q *MemoryQueue) ApproximateMessageCount() (int64, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return int64(len(q.messages)), nil
+}
+
+func (q *MemoryQueue) Clear() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.messages = nil
+	return nil
+}
+
+func (q *MemoryQueue) find(id string, popReceipt string) (int, error) {
+	for i, msg := range q.messages {
+		if msg.id == id {
+			if msg.popReceipt != popReceipt {
+				return -1, fmt.Errorf("pop receipt does not match for message '%s'", id)
+			}
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("message '%s' not found", id)
+}
@@ -0,0 +1,126 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"testing"
+	"time"
+)
+
+const testVisibilityTimeout = time.Duration(100) * time.Millisecond
+
+func TestMemoryQueueVisibilityTimeout(t *testing.T) {
+	testQueueVisibilityTimeout(t, InitializeMemoryQueue())
+}
+
+func TestMemoryQueueDequeueCount(t *testing.T) {
+	testQueueDequeueCount(t, InitializeMemoryQueue())
+}
+
+func TestMemoryQueueDeleteWithStalePopReceipt(t *testing.T) {
+	testQueueDeleteWithStalePopReceipt(t, InitializeMemoryQueue())
+}
+
+// testQueueVisibilityTimeout verifies a dequeued message is invisible until its visibility timeout expires
+func testQueueVisibilityTimeout(t *testing.T, q CacheWarmerQueue) {
+	if err := q.Enqueue("message"); err != nil {
+		t.Fatalf("error enqueuing message: %v", err)
+	}
+	msg, err := q.Dequeue(testVisibilityTimeout)
+	if err != nil || msg == nil {
+		t.Fatalf("expected a message, got %v, error %v", msg, err)
+	}
+	if msg.Text != "message" {
+		t.Errorf("expected text 'message', got '%s'", msg.Text)
+	}
+	if hidden, err := q.Dequeue(testVisibilityTimeout); err != nil || hidden != nil {
+		t.Fatalf("expected no visible message, got %v, error %v", hidden, err)
+	}
+	if peeked, err := q.Peek(); err != nil || peeked != nil {
+		t.Fatalf("expected no message to peek, got %v, error %v", peeked, err)
+	}
+	if isEmpty, err := q.IsQueueEmpty(); err != nil || isEmpty {
+		t.Fatalf("expected the invisible message to count, got empty %v, error %v", isEmpty, err)
+	}
+
+	time.Sleep(2 * testVisibilityTimeout)
+	visible, err := q.Dequeue(testVisibilityTimeout)
+	if err != nil || visible == nil {
+		t.Fatalf("expected the message to be visible again, got %v, error %v", visible, err)
+	}
+	if visible.ID != msg.ID {
+		t.Errorf("expected message '%s', got '%s'", msg.ID, visible.ID)
+	}
+
+	// a visibility update hides the message again
+	if _, err := q.UpdateVisibilityTimeout(visible.ID, visible.PopReceipt, testVisibilityTimeout, visible.Text); err != nil {
+		t.Fatalf("error updating visibility timeout: %v", err)
+	}
+	if hidden, err := q.Dequeue(testVisibilityTimeout); err != nil || hidden != nil {
+		t.Fatalf("expected no visible message after the update, got %v, error %v", hidden, err)
+	}
+}
+
+// testQueueDequeueCount verifies each dequeue counts, and a peek or visibility update does not
+func testQueueDequeueCount(t *testing.T, q CacheWarmerQueue) {
+	if err := q.Enqueue("message"); err != nil {
+		t.Fatalf("error enqueuing message: %v", err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		peeked, err := q.Peek()
+		if err != nil || peeked == nil {
+			t.Fatalf("expected a message to peek, got %v, error %v", peeked, err)
+		}
+		if peeked.DequeueCount != i-1 {
+			t.Errorf("expected peeked dequeue count %d, got %d", i-1, peeked.DequeueCount)
+		}
+		msg, err := q.Dequeue(testVisibilityTimeout)
+		if err != nil || msg == nil {
+			t.Fatalf("expected a message, got %v, error %v", msg, err)
+		}
+		if msg.DequeueCount != i {
+			t.Errorf("expected dequeue count %d, got %d", i, msg.DequeueCount)
+		}
+		// release the message, without counting a dequeue
+		if _, err := q.UpdateVisibilityTimeout(msg.ID, msg.PopReceipt, 0, msg.Text); err != nil {
+			t.Fatalf("error releasing message: %v", err)
+		}
+	}
+}
+
+// testQueueDeleteWithStalePopReceipt verifies a message is only deleted with its latest pop receipt
+func testQueueDeleteWithStalePopReceipt(t *testing.T, q CacheWarmerQueue) {
+	if err := q.Enqueue("message"); err != nil {
+		t.Fatalf("error enqueuing message: %v", err)
+	}
+	msg, err := q.Dequeue(testVisibilityTimeout)
+	if err != nil || msg == nil {
+		t.Fatalf("expected a message, got %v, error %v", msg, err)
+	}
+	popReceipt, err := q.UpdateVisibilityTimeout(msg.ID, msg.PopReceipt, testVisibilityTimeout, msg.Text)
+	if err != nil {
+		t.Fatalf("error updating visibility timeout: %v", err)
+	}
+	if err := q.DeleteMessage(msg.ID, msg.PopReceipt); err == nil {
+		t.Fatalf("expected an error deleting with the stale pop receipt")
+	}
+	if isEmpty, err := q.IsQueueEmpty(); err != nil || isEmpty {
+		t.Fatalf("expected the message to remain, got empty %v, error %v", isEmpty, err)
+	}
+
+	// a message dequeued again by another worker has a new pop receipt
+	time.Sleep(2 * testVisibilityTimeout)
+	redequeued, err := q.Dequeue(testVisibilityTimeout)
+	if err != nil || redequeued == nil {
+		t.Fatalf("expected the message to be visible again, got %v, error %v", redequeued, err)
+	}
+	if err := q.DeleteMessage(msg.ID, popReceipt); err == nil {
+		t.Fatalf("expected an error deleting with the pop receipt of the earlier dequeue")
+	}
+	if err := q.DeleteMessage(redequeued.ID, redequeued.PopReceipt); err != nil {
+		t.Fatalf("error deleting with the latest pop receipt: %v", err)
+	}
+	if isEmpty, err := q.IsQueueEmpty(); err != nil || !isEmpty {
+		t.Fatalf("expected an empty queue, got empty %v, error %v", isEmpty, err)
+	}
+}
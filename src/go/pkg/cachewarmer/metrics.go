@@ -0,0 +1,228 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// metricCounter is a monotonically increasing counter
+type metricCounter struct {
+	name  string
+	help  string
+	value int64
+}
+
+func (c *metricCounter) Add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
+}
+
+func (c *metricCounter) Inc() {
+	c.Add(1)
+}
+
+func (c *metricCounter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+func (c *metricCounter) write(buffer *bytes.Buffer) {
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, atomic.LoadInt64(&c.value))
+}
+
+// metricGauge is a value read when the metrics are scraped
+type metricGauge struct {
+	name  string
+	help  string
+	mux   sync.Mutex
+	value func() int64
+}
+
+func (g *metricGauge) SetFunc(value func() int64) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.value = value
+}
+
+func (g *metricGauge) write(buffer *bytes.Buffer) {
+	g.mux.Lock()
+	value := g.value
+	g.mux.Unlock()
+	if value == nil {
+		return
+	}
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, value())
+}
+
+// metricHistogram counts observations into cumulative buckets
+type metricHistogram struct {
+	name    string
+	help    string
+	mux     sync.Mutex
+	buckets []float64
+	counts  []int64
+	sum     float64
+	count   int64
+}
+
+func (h *metricHistogram) Observe(value float64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *metricHistogram) write(buffer *bytes.Buffer) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(buffer, "%s_bucket{le=\"%g\"} %d\n", h.name, bucket, h.counts[i])
+	}
+	fmt.Fprintf(buffer, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", h.name, h.count, h.name, h.sum, h.name, h.count)
+}
+
+// CacheWarmerMetrics holds the metrics of the manager or worker process, served in the Prometheus text format
+type CacheWarmerMetrics struct {
+	DirectoriesWalked       *metricCounter
+	WorkerJobsEnqueued      *metricCounter
+	WorkerJobsProcessed     *metricCounter
+	WorkerJobsFailed        *metricCounter
+	WorkerJobsDeadLettered  *metricCounter
+	WorkerJobsReleased      *metricCounter
+	WorkerJobsExpired       *metricCounter
+	FilesRead               *metricCounter
+	FilesFailed             *metricCounter
+	FilesStatted            *metricCounter
+	FilesChecksummed        *metricCounter
+	FilesExpired            *metricCounter
+	ChecksumMismatches      *metricCounter
+	BytesRead               *metricCounter
+	MountFailures           *metricCounter
+	VmssCreated             *metricCounter
+	VmssDeleted             *metricCounter
+	ScheduledJobsSubmitted  *metricCounter
+	ScheduledJobsSkipped    *metricCounter
+	QueueSasFailures        *metricCounter
+	LeaseFailures           *metricCounter
+	WorkQueueDepth          *metricGauge
+	VmssCapacity            *metricGauge
+	UnhealthyMountAddresses *metricGauge
+	Leader                  *metricGauge
+	FileReadDurationSeconds *metricHistogram
+}
+
+// Metrics are the metrics of this process, only served if the process runs the metrics server
+var Metrics = InitializeCacheWarmerMetrics()
+
+// InitializeCacheWarmerMetrics initializes the metrics with zero values
+func InitializeCacheWarmerMetrics() *CacheWarmerMetrics {
+	readBuckets := []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+	return &CacheWarmerMetrics{
+		DirectoriesWalked:       &metricCounter{name: "cachewarmer_directories_walked_total", help: "The directories enumerated by the manager."},
+		WorkerJobsEnqueued:      &metricCounter{name: "cachewarmer_worker_jobs_enqueued_total", help: "The worker jobs written to the work queue by the manager."},
+		WorkerJobsProcessed:     &metricCounter{name: "cachewarmer_worker_jobs_processed_total", help: "The worker jobs processed successfully by the worker."},
+		WorkerJobsFailed:        &metricCounter{name: "cachewarmer_worker_jobs_failed_total", help: "The failed worker job attempts of the worker."},
+		WorkerJobsDeadLettered:  &metricCounter{name: "cachewarmer_worker_jobs_dead_lettered_total", help: "The worker jobs moved to the dead letter queue by the worker."},
+		WorkerJobsReleased:      &metricCounter{name: "cachewarmer_worker_jobs_released_total", help: "The unfinished worker jobs made visible again by the worker when its VM was preempted."},
+		WorkerJobsExpired:       &metricCounter{name: "cachewarmer_worker_jobs_expired_total", help: "The worker jobs dropped by the worker after the deadline of their job."},
+		FilesRead:               &metricCounter{name: "cachewarmer_files_read_total", help: "The files or file ranges read by the worker."},
+		FilesFailed:             &metricCounter{name: "cachewarmer_files_failed_total", help: "The file reads or stats that failed on the worker."},
+		FilesStatted:            &metricCounter{name: "cachewarmer_files_statted_total", help: "The files statted by the worker for metadata only jobs."},
+		FilesChecksummed:        &metricCounter{name: "cachewarmer_files_checksummed_total", help: "The files checksummed by the worker for checksum jobs."},
+		FilesExpired:            &metricCounter{name: "cachewarmer_files_expired_total", help: "The queued files dropped by the worker after the deadline of their job."},
+		ChecksumMismatches:      &metricCounter{name: "cachewarmer_checksum_mismatches_total", help: "The file checksums that did not match the reference checksums."},
+		BytesRead:               &metricCounter{name: "cachewarmer_bytes_read_total", help: "The bytes read by the worker."},
+		MountFailures:           &metricCounter{name: "cachewarmer_mount_failures_total", help: "The failed mount attempts."},
+		VmssCreated:             &metricCounter{name: "cachewarmer_vmss_created_total", help: "The worker VMSS creations by the manager."},
+		VmssDeleted:             &metricCounter{name: "cachewarmer_vmss_deleted_total", help: "The worker VMSS deletions by the manager."},
+		ScheduledJobsSubmitted:  &metricCounter{name: "cachewarmer_scheduled_jobs_submitted_total", help: "The scheduled jobs submitted by the manager."},
+		ScheduledJobsSkipped:    &metricCounter{name: "cachewarmer_scheduled_jobs_skipped_total", help: "The scheduled runs skipped by the manager."},
+		QueueSasFailures:        &metricCounter{name: "cachewarmer_queue_sas_failures_total", help: "The failed queue SAS rotations by the manager, or reads by the worker."},
+		LeaseFailures:           &metricCounter{name: "cachewarmer_lease_failures_total", help: "The failed manager lease acquisitions and renewals."},
+		WorkQueueDepth:          &metricGauge{name: "cachewarmer_work_queue_depth", help: "The files queued for reading within the worker."},
+		VmssCapacity:            &metricGauge{name: "cachewarmer_vmss_capacity", help: "The worker VMSS capacity last set by the manager."},
+		UnhealthyMountAddresses: &metricGauge{name: "cachewarmer_unhealthy_mount_addresses", help: "The warm target mount addresses skipped by the worker after failures."},
+		Leader:                  &metricGauge{name: "cachewarmer_manager_leader", help: "1 if the manager holds the lease of the queue name prefix, otherwise 0."},
+		FileReadDurationSeconds: &metricHistogram{name: "cachewarmer_file_read_duration_seconds", help: "The time to read a file or file range.", buckets: readBuckets, counts: make([]int64, len(readBuckets))},
+	}
+}
+
+// WriteMetrics writes all metrics in the Prometheus text exposition format
+func (m *CacheWarmerMetrics) WriteMetrics(buffer *bytes.Buffer) {
+	for _, c := range []*metricCounter{
+		m.DirectoriesWalked,
+		m.WorkerJobsEnqueued,
+		m.WorkerJobsProcessed,
+		m.WorkerJobsFailed,
+		m.WorkerJobsDeadLettered,
+		m.WorkerJobsReleased,
+		m.WorkerJobsExpired,
+		m.FilesRead,
+		m.FilesFailed,
+		m.FilesStatted,
+		m.FilesChecksummed,
+		m.FilesExpired,
+		m.ChecksumMismatches,
+		m.BytesRead,
+		m.MountFailures,
+		m.VmssCreated,
+		m.VmssDeleted,
+		m.ScheduledJobsSubmitted,
+		m.ScheduledJobsSkipped,
+		m.QueueSasFailures,
+		m.LeaseFailures,
+	} {
+		c.write(buffer)
+	}
+	m.WorkQueueDepth.write(buffer)
+	m.VmssCapacity.write(buffer)
+	m.UnhealthyMountAddresses.write(buffer)
+	m.Leader.write(buffer)
+	m.FileReadDurationSeconds.write(buffer)
+}
+
+// RunMetricsServer serves the metrics at /metrics on the address until cancelled
+func RunMetricsServer(ctx context.Context, syncWaitGroup *sync.WaitGroup, address string) {
+	defer syncWaitGroup.Done()
+	log.Debug.Printf("[RunMetricsServer")
+	defer log.Debug.Printf("RunMetricsServer]")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var buffer bytes.Buffer
+		Metrics.WriteMetrics(&buffer)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buffer.Bytes())
+	})
+	server := &http.Server{Addr: address, Handler: mux}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Status.Printf("serving metrics at http://%s/metrics", address)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error.Printf("error shutting down metrics server: %v", err)
+		}
+	case err := <-serverErr:
+		log.Error.Printf("metrics server stopped: %v", err)
+	}
+}
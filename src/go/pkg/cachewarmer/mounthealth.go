@@ -0,0 +1,335 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+type addressHealth struct {
+	// the consecutive failed probes, reads, and stats of the address
+	consecutiveFailures int
+	// the address is skipped until the time, then probed again
+	unhealthyUntil time.Time
+	// a moving average of the stat latency, zero until the first success
+	latency      time.Duration
+	probing      bool
+	hasSucceeded bool
+}
+
+func (a *addressHealth) isHealthy(now time.Time) bool {
+	return a.hasSucceeded && a.consecutiveFailures < mountUnhealthyFailureCount && now.After(a.unhealthyUntil)
+}
+
+type mountedJob struct {
+	mountAddresses []string
+	exportPath     string
+	mount          MountSettings
+}
+
+// MountHealth tracks the health of the warm target mount addresses of a worker from its mount probes, reads, and
+// stats.  An address is unhealthy after consecutive failures or a slow stat latency, and is skipped until it is probed
+// again after a backoff, so the worker makes progress with the healthy addresses of a job.
+type MountHealth struct {
+	mux       sync.Mutex
+	addresses map[string]*addressHealth
+	jobs      map[string]mountedJob
+}
+
+// InitializeMountHealth initializes the mount health tracker
+func InitializeMountHealth() *MountHealth {
+	return &MountHealth{
+		addresses: make(map[string]*addressHealth),
+		jobs:      make(map[string]mountedJob),
+	}
+}
+
+// getMountAddress returns the mount address of a path below the local mount path, without the hash of the mount
+// settings of a job mount path
+func getMountAddress(localPath string) string {
+	address := strings.TrimPrefix(path.Clean(localPath), path.Clean(DefaultCacheWarmerMountPath)+"/")
+	if i := strings.Index(address, "/"); i >= 0 {
+		address = address[:i]
+	}
+	if i := strings.Index(address, "@"); i >= 0 {
+		address = address[:i]
+	}
+	return address
+}
+
+func (h *MountHealth) getAddress(address string) *addressHealth {
+	a, ok := h.addresses[address]
+	if !ok {
+		a = &addressHealth{}
+		h.addresses[address] = a
+	}
+	return a
+}
+
+// RegisterJob records the mount addresses and settings of the job, for the failover of its queued files
+func (h *MountHealth) RegisterJob(jobID string, mountAddresses []string, exportPath string, mount MountSettings) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.jobs[jobID] = mountedJob{mountAddresses: mountAddresses, exportPath: exportPath, mount: mount}
+}
+
+// RemoveJob forgets the mount addresses of the job
+func (h *MountHealth) RemoveJob(jobID string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	delete(h.jobs, jobID)
+}
+
+// RecordSuccess records a successful operation on the address, and the latency of a file stat if non-zero
+func (h *MountHealth) RecordSuccess(address string, latency time.Duration) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	a := h.getAddress(address)
+	a.hasSucceeded = true
+	if latency > 0 {
+		if a.latency == 0 {
+			a.latency = latency
+		} else {
+			a.latency = (a.latency*(mountLatencySamples-1) + latency) / mountLatencySamples
+		}
+		if a.latency > mountUnhealthyLatency {
+			h.markUnhealthy(address, a, fmt.Errorf("average stat latency %v", a.latency))
+			return
+		}
+	}
+	if a.consecutiveFailures > 0 {
+		log.Status.Printf("mount address '%s' is healthy", address)
+	}
+	a.consecutiveFailures = 0
+	a.unhealthyUntil = time.Time{}
+}
+
+// RecordFailure records a failed operation on the address, a failure of the file itself should not be recorded
+func (h *MountHealth) RecordFailure(address string, err error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	a := h.getAddress(address)
+	if a.consecutiveFailures+1 >= mountUnhealthyFailureCount {
+		h.markUnhealthy(address, a, err)
+		return
+	}
+	a.consecutiveFailures++
+}
+
+// markUnhealthy skips the address for a backoff that doubles with each further failure
+func (h *MountHealth) markUnhealthy(address string, a *addressHealth, err error) {
+	a.consecutiveFailures++
+	if a.consecutiveFailures < mountUnhealthyFailureCount {
+		a.consecutiveFailures = mountUnhealthyFailureCount
+	}
+	backoff := mountRetryBackoff
+	for i := mountUnhealthyFailureCount; i < a.consecutiveFailures && backoff < mountMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > mountMaxRetryBackoff {
+		backoff = mountMaxRetryBackoff
+	}
+	a.unhealthyUntil = time.Now().Add(backoff)
+	// the average restarts from the next probe, so a recovered address is not judged by its slow history
+	a.latency = 0
+	log.Warning.Printf("mount address '%s' is unhealthy, retrying in %v: %v", address, backoff, err)
+}
+
+// IsHealthy returns true if the address has succeeded and is not backing off after failures
+func (h *MountHealth) IsHealthy(address string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.getAddress(address).isHealthy(time.Now())
+}
+
+// UnhealthyCount returns the count of addresses that are backing off after failures
+func (h *MountHealth) UnhealthyCount() int64 {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	count := int64(0)
+	now := time.Now()
+	for _, a := range h.addresses {
+		if a.consecutiveFailures >= mountUnhealthyFailureCount && now.Before(a.unhealthyUntil) {
+			count++
+		}
+	}
+	return count
+}
+
+// getProbeAddresses returns the addresses not backing off, or all addresses if every address is backing off, since
+// retrying early is better than failing the worker job
+func (h *MountHealth) getProbeAddresses(mountAddresses []string) []string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	now := time.Now()
+	result := make([]string, 0, len(mountAddresses))
+	for _, address := range mountAddresses {
+		if now.After(h.getAddress(address).unhealthyUntil) {
+			result = append(result, address)
+		}
+	}
+	if len(result) == 0 {
+		return mountAddresses
+	}
+	return result
+}
+
+type probeResult struct {
+	localPath string
+	err       error
+}
+
+// probe mounts the address and stats the warm path.  A probe that does not finish within the timeout, for example
+// on an unresponsive hard mount, fails, and its result is recorded whenever it finishes.
+func (h *MountHealth) probe(address string, exportPath string, warmPath string, mount MountSettings) (string, error) {
+	h.mux.Lock()
+	a := h.getAddress(address)
+	if a.probing {
+		h.mux.Unlock()
+		return "", fmt.Errorf("the previous probe of mount address '%s' has not finished", address)
+	}
+	a.probing = true
+	h.mux.Unlock()
+
+	results := make(chan probeResult, 1)
+	go func() {
+		localPath, err := EnsureWarmPath(address, exportPath, warmPath, mount)
+		h.mux.Lock()
+		h.getAddress(address).probing = false
+		h.mux.Unlock()
+		// the probe latency includes the first mount, so only the stats of files are averaged
+		if err != nil && !os.IsNotExist(err) {
+			h.RecordFailure(address, err)
+		} else {
+			h.RecordSuccess(address, 0)
+		}
+		results <- probeResult{localPath: localPath, err: err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.localPath, result.err
+	case <-time.After(mountProbeTimeout):
+		err := fmt.Errorf("probe of mount address '%s' did not finish within %v", address, mountProbeTimeout)
+		// an unresponsive address is skipped immediately, instead of delaying each worker job by the timeout
+		h.mux.Lock()
+		h.markUnhealthy(address, h.getAddress(address), err)
+		h.mux.Unlock()
+		return "", err
+	}
+}
+
+// MountHealthyPaths probes the mount addresses of the worker job in parallel, and returns the local warm paths of the
+// healthy addresses, or an error if there are none
+func (h *MountHealth) MountHealthyPaths(workerJob *WorkerJob) ([]string, error) {
+	h.RegisterJob(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath, workerJob.Mount)
+	addresses := h.getProbeAddresses(workerJob.WarmTargetMountAddresses)
+
+	localPaths := make([]string, len(addresses))
+	errs := make([]error, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			localPaths[i], errs[i] = h.probe(address, workerJob.WarmTargetExportPath, workerJob.WarmTargetPath, workerJob.Mount)
+		}(i, address)
+	}
+	wg.Wait()
+
+	healthyPaths := make([]string, 0, len(addresses))
+	for i, address := range addresses {
+		if errs[i] != nil {
+			log.Warning.Printf("skipping mount address '%s' for '%s' '%s': %v", address, workerJob.WarmTargetExportPath, workerJob.WarmTargetPath, errs[i])
+			continue
+		}
+		healthyPaths = append(healthyPaths, localPaths[i])
+	}
+	if len(healthyPaths) == 0 {
+		// the warm path is missing, rather than the addresses unhealthy
+		for _, err := range errs {
+			if os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("none of the mount addresses '%s' is healthy for '%s' '%s'", strings.Join(workerJob.WarmTargetMountAddresses, ","), workerJob.WarmTargetExportPath, workerJob.WarmTargetPath)
+	}
+	return healthyPaths, nil
+}
+
+// SelectPath randomly chooses one of the local paths of the healthy addresses, weighted by the inverse of the stat
+// latency of the address, or any of the local paths if none is healthy
+func (h *MountHealth) SelectPath(localPaths []string) string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	now := time.Now()
+	weights := make([]float64, len(localPaths))
+	totalWeight := float64(0)
+	for i, localPath := range localPaths {
+		a := h.getAddress(getMountAddress(localPath))
+		if !a.isHealthy(now) {
+			continue
+		}
+		latency := a.latency
+		if latency < mountMinimumLatency {
+			latency = mountMinimumLatency
+		}
+		weights[i] = 1 / latency.Seconds()
+		totalWeight += weights[i]
+	}
+	if totalWeight == 0 {
+		return localPaths[rand.Intn(len(localPaths))]
+	}
+	r := rand.Float64() * totalWeight
+	for i, weight := range weights {
+		if r < weight {
+			return localPaths[i]
+		}
+		r -= weight
+	}
+	return localPaths[len(localPaths)-1]
+}
+
+// FailoverPath returns the path on a healthy mount address of the job if the address of the path is unhealthy,
+// otherwise the path is returned unchanged
+func (h *MountHealth) FailoverPath(jobID string, localPath string) string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	address := getMountAddress(localPath)
+	now := time.Now()
+	if h.getAddress(address).isHealthy(now) {
+		return localPath
+	}
+	job, ok := h.jobs[jobID]
+	if !ok {
+		return localPath
+	}
+	mountPrefix := GetJobMountPath(address, job.exportPath, job.mount)
+	if !strings.HasPrefix(localPath, mountPrefix) {
+		return localPath
+	}
+	healthyAddresses := make([]string, 0, len(job.mountAddresses))
+	for _, a := range job.mountAddresses {
+		if a != address && h.getAddress(a).isHealthy(now) {
+			healthyAddresses = append(healthyAddresses, a)
+		}
+	}
+	if len(healthyAddresses) == 0 {
+		return localPath
+	}
+	failoverAddress := healthyAddresses[rand.Intn(len(healthyAddresses))]
+	return GetJobMountPath(failoverAddress, job.exportPath, job.mount) + strings.TrimPrefix(localPath, mountPrefix)
+}
+
+// isMountError returns true if the error of a read or stat may be caused by the mount address, rather than the file
+func isMountError(err error) bool {
+	return err != nil && !os.IsNotExist(err) && !os.IsPermission(err) && err != errReadInterrupted
+}
@@ -4,12 +4,16 @@ package cachewarmer
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -43,9 +47,17 @@ func FileMatches(inclusionList []string, exclusionList []string, maxFileSizeByte
 	return len(inclusionList) == 0 && len(exclusionList) > 0
 }
 
-// EnsureWarmPath ensures that the path is mounted and exists
-func EnsureWarmPath(jobMountAddress string, jobExportPath string, jobBasePath string) (string, error) {
-	return ensureJobPath(jobMountAddress, jobExportPath, jobBasePath, "", false)
+// EnsureWarmPath ensures that the path is mounted on the mount point of the mount settings of the job and exists
+func EnsureWarmPath(jobMountAddress string, jobExportPath string, jobBasePath string, settings MountSettings) (string, error) {
+	localMountPath := GetJobMountPath(jobMountAddress, jobExportPath, settings)
+	if err := MountPath(jobMountAddress, jobExportPath, localMountPath); err != nil {
+		return localMountPath, err
+	}
+	warmPath := path.Join(localMountPath, jobBasePath)
+	if _, err := os.Stat(warmPath); err != nil {
+		return warmPath, err
+	}
+	return warmPath, nil
 }
 
 func ensureJobPath(jobMountAddress string, jobExportPath string, jobBasePath string, jobpath string, createAllDirectories bool) (string, error) {
@@ -73,6 +85,142 @@ func GetLocalMountPath(jobMountAddress string, jobExportPath string) string {
 	return path.Join(DefaultCacheWarmerMountPath, jobMountAddress, jobExportPath)
 }
 
+// GetJobMountPath returns the local mount path where the files of a job with the mount settings are read.  A job
+// with mount settings gets the mount point of a hash of its settings, so jobs with different settings never share a
+// mount, and a mount left by an earlier process has the settings of its path.  A job without mount settings uses the
+// local mount path of the export.
+func GetJobMountPath(jobMountAddress string, jobExportPath string, settings MountSettings) string {
+	if settings.IsEmpty() {
+		return GetLocalMountPath(jobMountAddress, jobExportPath)
+	}
+	localPath := path.Join(DefaultCacheWarmerMountPath, fmt.Sprintf("%s@%s", jobMountAddress, settings.hash()), jobExportPath)
+	mountRegistry.mux.Lock()
+	defer mountRegistry.mux.Unlock()
+	mountRegistry.settings[localPath] = settings
+	return localPath
+}
+
+// getExportFilePath returns the path below the local mount path without the mount address, so the path starts with the export path
+func getExportFilePath(localPath string) string {
+	relativePath := strings.TrimPrefix(path.Clean(localPath), path.Clean(DefaultCacheWarmerMountPath)+"/")
+	if i := strings.Index(relativePath, "/"); i >= 0 {
+		return relativePath[i:]
+	}
+	return localPath
+}
+
+// MountSettings are the file system type, either nfs or cifs, and the mount options of the exports of a job.  The
+// options replace the default options of the type, and the zero value mounts nfs with the default options of the process.
+// A cifs export path is the share name, mounted as //address/share.
+type MountSettings struct {
+	Type    string
+	Options string
+}
+
+var mountOptionsRegexp = regexp.MustCompile(`^[A-Za-z0-9_.,=:/@+-]*$`)
+
+// InitializeMountSettings initializes the mount settings, and returns an error if the type or options are invalid
+func InitializeMountSettings(mountType string, options string) (MountSettings, error) {
+	settings := MountSettings{Type: mountType, Options: options}
+	return settings, settings.Validate()
+}
+
+// Validate returns an error if the type is unknown, or the options could break the mount command.  A password is
+// rejected since the settings of a job travel in the queue messages, a cifs credentials file should be used instead.
+func (s MountSettings) Validate() error {
+	if s.Type != "" && s.Type != MountTypeNfs && s.Type != MountTypeCifs {
+		return fmt.Errorf("mount type '%s' is neither '%s' nor '%s'", s.Type, MountTypeNfs, MountTypeCifs)
+	}
+	if !mountOptionsRegexp.MatchString(s.Options) {
+		return fmt.Errorf("mount options '%s' may only contain letters, digits, and the characters _.,=:/@+-", s.Options)
+	}
+	for _, option := range strings.Split(s.Options, ",") {
+		if key := strings.SplitN(option, "=", 2)[0]; key == "password" || key == "pass" {
+			return fmt.Errorf("mount options may not contain a password, use a credentials file")
+		}
+	}
+	return nil
+}
+
+// IsEmpty returns true if neither the type nor the options are set
+func (s MountSettings) IsEmpty() bool {
+	return len(s.Type) == 0 && len(s.Options) == 0
+}
+
+// hash returns a short hash of the type and options, naming the mount point of the settings
+func (s MountSettings) hash() string {
+	return shortHash(s.Type + "\n" + s.Options)
+}
+
+// shortHash returns the first hex digits of the sha256 hash of the string, to name a file or directory by the string
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:shortHashLength]
+}
+
+// the mount settings of each local mount path
+var mountRegistry = struct {
+	mux             sync.Mutex
+	defaultSettings MountSettings
+	settings        map[string]MountSettings
+}{settings: make(map[string]MountSettings)}
+
+// SetDefaultMountSettings sets the mount settings of the exports without settings of their own, such as the queue
+// export, and the exports of jobs submitted without mount settings
+func SetDefaultMountSettings(settings MountSettings) {
+	mountRegistry.mux.Lock()
+	defer mountRegistry.mux.Unlock()
+	mountRegistry.defaultSettings = settings
+}
+
+// GetDefaultMountSettings returns the mount settings set by SetDefaultMountSettings
+func GetDefaultMountSettings() MountSettings {
+	mountRegistry.mux.Lock()
+	defer mountRegistry.mux.Unlock()
+	return mountRegistry.defaultSettings
+}
+
+// RegisterMountSettings sets the mount settings of the local mount path of the export at each address, unless
+// already set.  The local mount path holds the progress, cancellation, and report of the jobs of the export, so it
+// only needs the settings of any job, for example to mount a cifs share as cifs, and the files of each job are read
+// on the mount point of its own settings, see GetJobMountPath.
+func RegisterMountSettings(addresses []string, exportPath string, settings MountSettings) {
+	if settings.IsEmpty() {
+		return
+	}
+	mountRegistry.mux.Lock()
+	defer mountRegistry.mux.Unlock()
+	for _, address := range addresses {
+		localPath := GetLocalMountPath(address, exportPath)
+		if _, ok := mountRegistry.settings[localPath]; !ok {
+			mountRegistry.settings[localPath] = settings
+		}
+	}
+}
+
+// getMountCommand returns the mount command of the export, using the registered or default settings
+func getMountCommand(address string, exportPath string, localPath string) string {
+	mountRegistry.mux.Lock()
+	settings, ok := mountRegistry.settings[localPath]
+	if !ok {
+		settings = mountRegistry.defaultSettings
+	}
+	mountRegistry.mux.Unlock()
+
+	if settings.Type == MountTypeCifs {
+		options := settings.Options
+		if len(options) == 0 {
+			options = DefaultCifsMountOptions
+		}
+		return fmt.Sprintf("/bin/mount -t cifs -o '%s' //%s%s %s", options, address, path.Join("/", exportPath), localPath)
+	}
+	options := settings.Options
+	if len(options) == 0 {
+		options = DefaultNfsMountOptions
+	}
+	return fmt.Sprintf("/bin/mount -o '%s' %s:%s %s", options, address, exportPath, localPath)
+}
+
 func MountPath(address string, exportPath string, localPath string) error {
 	// is already mounted?
 	if AlreadyMounted(localPath) {
@@ -84,7 +232,7 @@ func MountPath(address string, exportPath string, localPath string) error {
 		return e
 	}
 
-	mountCmd := fmt.Sprintf("/bin/mount -o 'hard,nointr,proto=tcp,mountproto=tcp,retry=30' %s:%s %s", address, exportPath, localPath)
+	mountCmd := getMountCommand(address, exportPath, localPath)
 
 	for retries := 0; ; retries++ {
 		_, stderrBytes, err := BashCommand(mountCmd)
@@ -92,6 +240,7 @@ func MountPath(address string, exportPath string, localPath string) error {
 			return nil
 		}
 		log.Warning.Printf("command '%s' failed with error: '%v', '%s'", mountCmd, err, stderrBytes.String())
+		Metrics.MountFailures.Inc()
 		if retries > MountRetryCount {
 
 			return fmt.Errorf("Failure to mount after %d retries trying to mount %s", MountRetryCount, localPath)
@@ -0,0 +1,571 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+	"github.com/google/uuid"
+)
+
+// JobProgress is the progress record of a single manager or worker process for a WarmPathJob.
+// Each process writes its own record, so there is never more than one writer per file.  WorkerJobsFailed
+// counts failed attempts, since a failed worker job is retried until it is dead lettered.  The files
+// of a metadata only job are counted as statted instead of read, and the files of a checksum job are
+// also counted as checksummed.  The worker jobs, queued files, directories, and file list entries dropped after the
+// deadline of the job are counted as expired, and the worker jobs and files dropped by the workers after the job is
+// cancelled are counted as cancelled.  The skipped files, file sizes, and failed files are summed into the job
+// report, and the failed and expired paths beyond the first of each writer are only counted.  The manager records
+// the error of a job that fails before any of its work is queued, such as a job with invalid mount settings.
+type JobProgress struct {
+	JobID                  string
+	Writer                 string
+	JobError               string
+	EnumerationComplete    bool
+	WorkerJobsEnqueued     int64
+	WorkerJobsCompleted    int64
+	WorkerJobsFailed       int64
+	WorkerJobsDeadLettered int64
+	FilesQueued            int64
+	FilesRead              int64
+	FilesFailed            int64
+	BytesRead              int64
+	DirectoriesListed      int64
+	FilesStatted           int64
+	FilesStatFailed        int64
+	FilesChecksummed       int64
+	ChecksumMismatches     int64
+	DirectoriesSkipped     int64
+	WorkerJobsExpired      int64
+	FilesExpired           int64
+	DirectoriesExpired     int64
+	FileListEntriesExpired int64
+	WorkerJobsCancelled    int64
+	FilesCancelled         int64
+	FilesSkipped           map[string]int64
+	FileSizes              FileSizeHistogram
+	FailedFiles            []FailedFile
+	FailedFilesOmitted     int64
+	ExpiredPaths           []string
+	ExpiredPathsOmitted    int64
+	Deadline               time.Time
+	StartTime              time.Time
+	LastUpdate             time.Time
+}
+
+// RecordSkipped counts the files skipped for the reason
+func (p *JobProgress) RecordSkipped(reason string, count int64) {
+	if count == 0 {
+		return
+	}
+	if p.FilesSkipped == nil {
+		p.FilesSkipped = make(map[string]int64)
+	}
+	p.FilesSkipped[reason] += count
+}
+
+// RecordFailure records the failed path for the job report, only the first failures of each writer are kept
+func (p *JobProgress) RecordFailure(failedPath string, err error) {
+	if len(p.FailedFiles) < maxReportedFailures {
+		p.FailedFiles = append(p.FailedFiles, FailedFile{Path: failedPath, Error: err.Error()})
+	} else {
+		p.FailedFilesOmitted++
+	}
+}
+
+// RecordExpired records the path dropped after the deadline for the job report, only the first paths of each writer
+// are kept
+func (p *JobProgress) RecordExpired(expiredPath string) {
+	if len(p.ExpiredPaths) < maxReportedFailures {
+		p.ExpiredPaths = append(p.ExpiredPaths, expiredPath)
+	} else {
+		p.ExpiredPathsOmitted++
+	}
+}
+
+// JobStatus is the sum of all progress records for a WarmPathJob
+type JobStatus struct {
+	JobID                  string
+	Writers                int
+	Cancelled              bool
+	JobError               string
+	EnumerationComplete    bool
+	WorkerJobsEnqueued     int64
+	WorkerJobsCompleted    int64
+	WorkerJobsFailed       int64
+	WorkerJobsDeadLettered int64
+	FilesQueued            int64
+	FilesRead              int64
+	FilesFailed            int64
+	BytesRead              int64
+	DirectoriesListed      int64
+	FilesStatted           int64
+	FilesStatFailed        int64
+	FilesChecksummed       int64
+	ChecksumMismatches     int64
+	DirectoriesSkipped     int64
+	WorkerJobsExpired      int64
+	FilesExpired           int64
+	DirectoriesExpired     int64
+	FileListEntriesExpired int64
+	WorkerJobsCancelled    int64
+	FilesCancelled         int64
+	FilesSkipped           map[string]int64
+	FileSizes              FileSizeHistogram
+	FailedFiles            []FailedFile
+	FailedFilesOmitted     int64
+	ExpiredPaths           []string
+	ExpiredPathsOmitted    int64
+	Deadline               time.Time
+	StartTime              time.Time
+	LastUpdate             time.Time
+}
+
+// IsStarted returns true once the manager has written its first progress record
+func (s *JobStatus) IsStarted() bool {
+	return s.Writers > 0
+}
+
+// IsComplete returns true when all directories are enumerated, and all worker jobs and files are processed or expired
+func (s *JobStatus) IsComplete() bool {
+	return s.EnumerationComplete &&
+		s.WorkerJobsCompleted+s.WorkerJobsDeadLettered+s.WorkerJobsExpired+s.WorkerJobsCancelled >= s.WorkerJobsEnqueued &&
+		s.FilesRead+s.FilesFailed+s.FilesStatted+s.FilesStatFailed+s.FilesExpired+s.FilesCancelled >= s.FilesQueued
+}
+
+// IsFailed returns true if the job failed before any of its work was queued
+func (s *JobStatus) IsFailed() bool {
+	return len(s.JobError) > 0
+}
+
+// IsPartial returns true if any work of the job was dropped after its deadline
+func (s *JobStatus) IsPartial() bool {
+	return s.WorkerJobsExpired > 0 || s.FilesExpired > 0 || s.DirectoriesExpired > 0 || s.FileListEntriesExpired > 0
+}
+
+// HasFailures returns true if the job failed, any worker job was dead lettered, any file read has failed, or any
+// checksum mismatched
+func (s *JobStatus) HasFailures() bool {
+	return s.IsFailed() || s.WorkerJobsDeadLettered > 0 || s.FilesFailed > 0 || s.FilesStatFailed > 0 || s.ChecksumMismatches > 0
+}
+
+// PercentComplete returns the percentage of completed worker jobs, this never reaches 100 until the job is complete
+func (s *JobStatus) PercentComplete() float64 {
+	if s.IsComplete() {
+		return float64(100)
+	}
+	if s.WorkerJobsEnqueued == 0 {
+		return float64(0)
+	}
+	percent := float64(100) * float64(s.WorkerJobsCompleted+s.WorkerJobsDeadLettered+s.WorkerJobsExpired+s.WorkerJobsCancelled) / float64(s.WorkerJobsEnqueued)
+	if percent > float64(99) {
+		percent = float64(99)
+	}
+	return percent
+}
+
+// State returns one of queued, enumerating, warming, complete, partial, failed, or cancelled, where a partial job
+// completed after dropping its remaining work at the deadline
+func (s *JobStatus) State() string {
+	if s.Cancelled {
+		return "cancelled"
+	} else if s.IsFailed() {
+		return "failed"
+	} else if s.IsComplete() && s.IsPartial() {
+		return "partial"
+	} else if s.IsComplete() {
+		return "complete"
+	} else if s.IsStarted() && !s.EnumerationComplete {
+		return "enumerating"
+	} else if s.IsStarted() {
+		return "warming"
+	}
+	return "queued"
+}
+
+func (s *JobStatus) String() string {
+	state := s.State()
+	result := fmt.Sprintf("job %s %s %.1f%%: worker jobs enqueued %d, completed %d, failed attempts %d, dead lettered %d, files queued %d, read %d, failed %d, bytes read %d",
+		s.JobID,
+		state,
+		s.PercentComplete(),
+		s.WorkerJobsEnqueued,
+		s.WorkerJobsCompleted,
+		s.WorkerJobsFailed,
+		s.WorkerJobsDeadLettered,
+		s.FilesQueued,
+		s.FilesRead,
+		s.FilesFailed,
+		s.BytesRead)
+	if s.FilesStatted > 0 || s.FilesStatFailed > 0 {
+		result = fmt.Sprintf("%s, directories listed %d, files statted %d, stat failed %d", result, s.DirectoriesListed, s.FilesStatted, s.FilesStatFailed)
+	}
+	if s.FilesChecksummed > 0 || s.ChecksumMismatches > 0 {
+		result = fmt.Sprintf("%s, files checksummed %d, checksum mismatches %d", result, s.FilesChecksummed, s.ChecksumMismatches)
+	}
+	if s.IsPartial() {
+		result = fmt.Sprintf("%s, expired at the deadline %s: worker jobs %d, files %d, directories %d, file list entries %d", result, s.Deadline.Format(time.RFC3339), s.WorkerJobsExpired, s.FilesExpired, s.DirectoriesExpired, s.FileListEntriesExpired)
+	}
+	if s.IsFailed() {
+		result = fmt.Sprintf("%s, job error: %s", result, s.JobError)
+	}
+	if s.Cancelled {
+		result = fmt.Sprintf("%s, dropped after the cancel: worker jobs %d, files %d", result, s.WorkerJobsCancelled, s.FilesCancelled)
+	}
+	return result
+}
+
+func (s *JobStatus) add(p *JobProgress) {
+	s.Writers++
+	if len(p.JobError) > 0 {
+		s.JobError = p.JobError
+	}
+	s.EnumerationComplete = s.EnumerationComplete || p.EnumerationComplete
+	s.WorkerJobsEnqueued += p.WorkerJobsEnqueued
+	s.WorkerJobsCompleted += p.WorkerJobsCompleted
+	s.WorkerJobsFailed += p.WorkerJobsFailed
+	s.WorkerJobsDeadLettered += p.WorkerJobsDeadLettered
+	s.FilesQueued += p.FilesQueued
+	s.FilesRead += p.FilesRead
+	s.FilesFailed += p.FilesFailed
+	s.BytesRead += p.BytesRead
+	s.DirectoriesListed += p.DirectoriesListed
+	s.FilesStatted += p.FilesStatted
+	s.FilesStatFailed += p.FilesStatFailed
+	s.FilesChecksummed += p.FilesChecksummed
+	s.ChecksumMismatches += p.ChecksumMismatches
+	s.DirectoriesSkipped += p.DirectoriesSkipped
+	s.WorkerJobsExpired += p.WorkerJobsExpired
+	s.FilesExpired += p.FilesExpired
+	s.DirectoriesExpired += p.DirectoriesExpired
+	s.FileListEntriesExpired += p.FileListEntriesExpired
+	s.WorkerJobsCancelled += p.WorkerJobsCancelled
+	s.FilesCancelled += p.FilesCancelled
+	for reason, count := range p.FilesSkipped {
+		if s.FilesSkipped == nil {
+			s.FilesSkipped = make(map[string]int64)
+		}
+		s.FilesSkipped[reason] += count
+	}
+	s.FileSizes.add(&p.FileSizes)
+	s.FailedFiles = append(s.FailedFiles, p.FailedFiles...)
+	s.FailedFilesOmitted += p.FailedFilesOmitted
+	s.ExpiredPaths = append(s.ExpiredPaths, p.ExpiredPaths...)
+	s.ExpiredPathsOmitted += p.ExpiredPathsOmitted
+	if !p.Deadline.IsZero() {
+		s.Deadline = p.Deadline
+	}
+	if !p.StartTime.IsZero() && (s.StartTime.IsZero() || p.StartTime.Before(s.StartTime)) {
+		s.StartTime = p.StartTime
+	}
+	if p.LastUpdate.After(s.LastUpdate) {
+		s.LastUpdate = p.LastUpdate
+	}
+}
+
+// ReadJobStatus reads and sums all progress records for the job from the warm target export
+func ReadJobStatus(mountAddress string, exportPath string, jobID string) (*JobStatus, error) {
+	jobStatus := &JobStatus{JobID: jobID}
+
+	progressPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the manager has not yet picked up the job
+			return jobStatus, nil
+		}
+		return nil, err
+	}
+
+	dirEntries, err := ioutil.ReadDir(progressPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading progress directory '%s': %v", progressPath, err)
+	}
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name() == JobCancelledFilename {
+			jobStatus.Cancelled = true
+			continue
+		}
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), JobProgressFileSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(progressPath, dirEntry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading progress file '%s': %v", dirEntry.Name(), err)
+		}
+		var jobProgress JobProgress
+		if err := json.Unmarshal(data, &jobProgress); err != nil {
+			return nil, fmt.Errorf("error parsing progress file '%s': %v", dirEntry.Name(), err)
+		}
+		jobStatus.add(&jobProgress)
+	}
+
+	return jobStatus, nil
+}
+
+// countActiveWorkers returns the count of workers reading the job, this worker and the other workers that wrote their
+// progress record of the job within the active worker interval
+func countActiveWorkers(mountAddress string, exportPath string, jobID string, writer string) (int64, error) {
+	workerCount := int64(1)
+	progressPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return workerCount, nil
+		}
+		return 0, err
+	}
+	dirEntries, err := ioutil.ReadDir(progressPath)
+	if err != nil {
+		return 0, fmt.Errorf("error reading progress directory '%s': %v", progressPath, err)
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), JobProgressFileSuffix) {
+			continue
+		}
+		progressWriter := strings.TrimSuffix(dirEntry.Name(), JobProgressFileSuffix)
+		if progressWriter == ManagerProgressWriter || progressWriter == writer {
+			continue
+		}
+		if time.Since(dirEntry.ModTime()) < activeWorkerInterval {
+			workerCount++
+		}
+	}
+	return workerCount, nil
+}
+
+// GetWorkerProgressWriter returns a writer name unique to this worker process
+func GetWorkerProgressWriter() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	return fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
+}
+
+type trackedJobProgress struct {
+	progress     JobProgress
+	mountAddress string
+	exportPath   string
+	dirty        bool
+	lastUsed     time.Time
+}
+
+// ProgressTracker accumulates the job progress of a process in memory and periodically writes it to the warm target export
+type ProgressTracker struct {
+	mux      sync.Mutex
+	flushMux sync.Mutex
+	writer   string
+	jobs     map[string]*trackedJobProgress
+}
+
+// InitializeProgressTracker initializes the progress tracker for the writer
+func InitializeProgressTracker(writer string) *ProgressTracker {
+	return &ProgressTracker{
+		writer: writer,
+		jobs:   make(map[string]*trackedJobProgress),
+	}
+}
+
+// Register records where the progress of the job is written, jobs without an id are not tracked.  The progress
+// continues from the record the writer wrote before a restart, so the completions of the worker jobs written before
+// the restart are never counted against zeroed counters.
+func (t *ProgressTracker) Register(jobID string, mountAddresses []string, exportPath string) {
+	if len(jobID) == 0 || len(mountAddresses) == 0 || t.isRegistered(jobID) {
+		return
+	}
+	progress, err := readJobProgress(mountAddresses[0], exportPath, jobID, t.writer)
+	if err != nil {
+		log.Error.Printf("error reading the previous progress of job '%s', starting from zero: %v", jobID, err)
+	}
+	if progress == nil {
+		progress = &JobProgress{
+			JobID:  jobID,
+			Writer: t.writer,
+		}
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.jobs[jobID]; ok {
+		return
+	}
+	t.jobs[jobID] = &trackedJobProgress{
+		progress:     *progress,
+		mountAddress: mountAddresses[0],
+		exportPath:   exportPath,
+		dirty:        true,
+		lastUsed:     time.Now(),
+	}
+}
+
+func (t *ProgressTracker) isRegistered(jobID string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	_, ok := t.jobs[jobID]
+	return ok
+}
+
+// Update applies the update to the progress of a registered job
+func (t *ProgressTracker) Update(jobID string, update func(p *JobProgress)) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if job, ok := t.jobs[jobID]; ok {
+		update(&job.progress)
+		job.dirty = true
+		job.lastUsed = time.Now()
+	}
+}
+
+// IdleJobs returns the registered jobs not updated within the timeout
+func (t *ProgressTracker) IdleJobs(timeout time.Duration) []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	jobIDs := make([]string, 0)
+	for jobID, job := range t.jobs {
+		if time.Since(job.lastUsed) > timeout {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	return jobIDs
+}
+
+// Remove writes the progress of the job if updated since the last flush, and forgets the job.  The job is kept if
+// the write fails, or it is updated meanwhile, so a job registered again always continues from its latest record.
+func (t *ProgressTracker) Remove(jobID string) {
+	t.flushMux.Lock()
+	defer t.flushMux.Unlock()
+	t.mux.Lock()
+	job, ok := t.jobs[jobID]
+	if !ok {
+		t.mux.Unlock()
+		return
+	}
+	dirty := job.dirty
+	job.dirty = false
+	job.progress.LastUpdate = time.Now()
+	progress := job.progress
+	t.mux.Unlock()
+
+	if dirty {
+		if err := writeJobProgress(job.mountAddress, job.exportPath, &progress); err != nil {
+			log.Error.Printf("error writing progress for job '%s': %v", jobID, err)
+			t.markDirty(jobID)
+			return
+		}
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.jobs[jobID] == job && !job.dirty {
+		delete(t.jobs, jobID)
+	}
+}
+
+func (t *ProgressTracker) markDirty(jobID string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if job, ok := t.jobs[jobID]; ok {
+		job.dirty = true
+	}
+}
+
+// Flush writes the progress of all jobs updated since the last flush.  Flushes are serialized, so an older
+// record never overwrites a newer one.
+func (t *ProgressTracker) Flush() {
+	type pendingWrite struct {
+		progress     JobProgress
+		mountAddress string
+		exportPath   string
+	}
+	t.flushMux.Lock()
+	defer t.flushMux.Unlock()
+	t.mux.Lock()
+	pendingWrites := make([]pendingWrite, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		if job.dirty {
+			job.progress.LastUpdate = time.Now()
+			job.dirty = false
+			pendingWrites = append(pendingWrites, pendingWrite{
+				progress:     job.progress,
+				mountAddress: job.mountAddress,
+				exportPath:   job.exportPath,
+			})
+		}
+	}
+	t.mux.Unlock()
+
+	for i := range pendingWrites {
+		if err := writeJobProgress(pendingWrites[i].mountAddress, pendingWrites[i].exportPath, &pendingWrites[i].progress); err != nil {
+			log.Error.Printf("error writing progress for job '%s': %v", pendingWrites[i].progress.JobID, err)
+			t.markDirty(pendingWrites[i].progress.JobID)
+		}
+	}
+}
+
+// RunProgressFlusher periodically flushes the job progress until cancelled
+func (t *ProgressTracker) RunProgressFlusher(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	defer syncWaitGroup.Done()
+	log.Debug.Printf("[ProgressTracker.RunProgressFlusher")
+	defer log.Debug.Printf("ProgressTracker.RunProgressFlusher]")
+
+	ticker := time.NewTicker(progressFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Flush()
+			return
+		case <-ticker.C:
+			t.Flush()
+		}
+	}
+}
+
+// readJobProgress reads the progress record of the writer for the job, or returns nil if the writer has none
+func readJobProgress(mountAddress string, exportPath string, jobID string, writer string) (*JobProgress, error) {
+	progressPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobID, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path.Join(progressPath, fmt.Sprintf("%s%s", writer, JobProgressFileSuffix)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var jobProgress JobProgress
+	if err := json.Unmarshal(data, &jobProgress); err != nil {
+		return nil, fmt.Errorf("error parsing progress file of writer '%s': %v", writer, err)
+	}
+	return &jobProgress, nil
+}
+
+func writeJobProgress(mountAddress string, exportPath string, jobProgress *JobProgress) error {
+	progressPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, jobProgress.JobID, true)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(jobProgress)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename so readers never see a partial record
+	filename := path.Join(progressPath, fmt.Sprintf("%s%s", jobProgress.Writer, JobProgressFileSuffix))
+	tmpFilename := fmt.Sprintf("%s.tmp", filename)
+	if err := ioutil.WriteFile(tmpFilename, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
@@ -6,9 +6,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/Azure/Avere/src/go/pkg/azure"
 	"github.com/Azure/azure-sdk-for-go/profiles/2020-09-01/storage/mgmt/storage"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
@@ -50,30 +52,301 @@ func GetPrimaryStorageKey(ctx context.Context, resourceGroup string, accountName
 	return *(((*response.Keys)[0]).Value), nil
 }
 
+// QueueMessage is a message dequeued or peeked from a CacheWarmerQueue
+type QueueMessage struct {
+	ID           string
+	PopReceipt   string
+	Text         string
+	DequeueCount int64
+}
+
+// CacheWarmerQueue is the set of queue operations used by the cache warmer.  A dequeued message
+// becomes invisible until the visibility timeout expires, and re-appears unless it is deleted.
+type CacheWarmerQueue interface {
+	Enqueue(message string) error
+	// Dequeue returns nil if there is no visible message
+	Dequeue(visibilityTimeout time.Duration) (*QueueMessage, error)
+	// Peek returns nil if there is no visible message
+	Peek() (*QueueMessage, error)
+	// PeekMessages returns up to maxMessages visible messages, without changing their visibility or dequeue count
+	PeekMessages(maxMessages int32) ([]*QueueMessage, error)
+	// UpdateVisibilityTimeout returns the new pop receipt of the message
+	UpdateVisibilityTimeout(id string, popReceipt string, visibilityTimeout time.Duration, message string) (string, error)
+	DeleteMessage(id string, popReceipt string) error
+	// IsQueueEmpty returns false if there are one or more visible or invisible messages
+	IsQueueEmpty() (bool, error)
+	// ApproximateMessageCount returns the approximate number of visible and invisible messages
+	ApproximateMessageCount() (int64, error)
+	// Clear deletes all visible and invisible messages
+	Clear() error
+}
+
+// azureQueue implements CacheWarmerQueue with an Azure Storage queue
+type azureQueue struct {
+	queue *azure.Queue
+}
+
+func initializeAzureQueue(ctx context.Context, storageAccount string, storageKey string, queueName string) (*azureQueue, error) {
+	queue, err := azure.InitializeQueueNonFatal(ctx, storageAccount, storageKey, queueName)
+	if err != nil {
+		return nil, err
+	}
+	return &azureQueue{queue: queue}, nil
+}
+
+func initializeManagedIdentityAzureQueue(ctx context.Context, storageAccount string, credential azqueue.TokenCredential, queueName string) (*azureQueue, error) {
+	queue, err := azure.InitializeQueueWithTokenCredential(ctx, storageAccount, credential, queueName)
+	if err != nil {
+		return nil, err
+	}
+	return &azureQueue{queue: queue}, nil
+}
+
+func initializeSasAzureQueue(ctx context.Context, storageAccount string, sasReader *QueueSasReader, queueName string) (*azureQueue, error) {
+	queue, err := azure.InitializeQueueWithSAS(ctx, storageAccount, queueName, func() string {
+		return sasReader.GetToken(queueName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &azureQueue{queue: queue}, nil
+}
+
+func (q *azureQueue) Enqueue(message string) error {
+	return q.queue.Enqueue(message)
+}
+
+func (q *azureQueue) Dequeue(visibilityTimeout time.Duration) (*QueueMessage, error) {
+	queueMessage, err := q.queue.Dequeue(NumberOfMessagesToDequeue, visibilityTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if queueMessage.NumMessages() != NumberOfMessagesToDequeue {
+		return nil, nil
+	}
+	msg := queueMessage.Message(0)
+	return &QueueMessage{
+		ID:           string(msg.ID),
+		PopReceipt:   string(msg.PopReceipt),
+		Text:         msg.Text,
+		DequeueCount: msg.DequeueCount,
+	}, nil
+}
+
+func (q *azureQueue) Peek() (*QueueMessage, error) {
+	peekMessage, err := q.queue.Peek(NumberOfMessagesToDequeue)
+	if err != nil {
+		return nil, err
+	}
+	if peekMessage.NumMessages() != NumberOfMessagesToDequeue {
+		return nil, nil
+	}
+	msg := peekMessage.Message(0)
+	return &QueueMessage{
+		ID:           string(msg.ID),
+		Text:         msg.Text,
+		DequeueCount: msg.DequeueCount,
+	}, nil
+}
+
+func (q *azureQueue) PeekMessages(maxMessages int32) ([]*QueueMessage, error) {
+	if maxMessages > MaximumMessagesToPeek {
+		maxMessages = MaximumMessagesToPeek
+	}
+	peekMessage, err := q.queue.Peek(maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*QueueMessage, 0, peekMessage.NumMessages())
+	for i := int32(0); i < peekMessage.NumMessages(); i++ {
+		msg := peekMessage.Message(i)
+		messages = append(messages, &QueueMessage{
+			ID:           string(msg.ID),
+			Text:         msg.Text,
+			DequeueCount: msg.DequeueCount,
+		})
+	}
+	return messages, nil
+}
+
+func (q *azureQueue) UpdateVisibilityTimeout(id string, popReceipt string, visibilityTimeout time.Duration, message string) (string, error) {
+	resp, err := q.queue.UpdateVisibilityTimeout(azqueue.MessageID(id), azqueue.PopReceipt(popReceipt), visibilityTimeout, message)
+	if err != nil {
+		return "", err
+	}
+	return string(resp.PopReceipt), nil
+}
+
+func (q *azureQueue) DeleteMessage(id string, popReceipt string) error {
+	_, err := q.queue.DeleteMessage(azqueue.MessageID(id), azqueue.PopReceipt(popReceipt))
+	return err
+}
+
+func (q *azureQueue) IsQueueEmpty() (bool, error) {
+	return q.queue.IsQueueEmpty()
+}
+
+func (q *azureQueue) ApproximateMessageCount() (int64, error) {
+	return q.queue.ApproximateMessageCount()
+}
+
+func (q *azureQueue) Clear() error {
+	return q.queue.Clear()
+}
+
+// CacheWarmerQueues holds a job queue and a work queue for each priority, and the dead letter queue for failed worker jobs
 type CacheWarmerQueues struct {
-	jobQueue  *azure.Queue
-	workQueue *azure.Queue
+	jobQueues       map[string]CacheWarmerQueue
+	workQueues      map[string]CacheWarmerQueue
+	deadLetterQueue CacheWarmerQueue
 }
 
+// Priorities lists the job priorities from the most to the least urgent
+var Priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// IsValidPriority returns true for the known priorities, and the empty string which is normal priority
+func IsValidPriority(priority string) bool {
+	if len(priority) == 0 {
+		return true
+	}
+	for _, p := range Priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// getPriorityQueueName returns the queue name, the normal priority queues keep the names without a priority
+func getPriorityQueueName(queueNamePrefix string, queueSuffix string, priority string) string {
+	if priority == PriorityNormal {
+		return fmt.Sprintf("%s%s", queueNamePrefix, queueSuffix)
+	}
+	return fmt.Sprintf("%s%s%s", queueNamePrefix, queueSuffix, priority)
+}
+
+// getQueueNames returns the names of the job and work queue of each priority, and the dead letter queue
+func getQueueNames(queueNamePrefix string) []string {
+	queueNames := make([]string, 0, 2*len(Priorities)+1)
+	for _, priority := range Priorities {
+		queueNames = append(queueNames, getPriorityQueueName(queueNamePrefix, WarmPathJobQueueSuffix, priority))
+		queueNames = append(queueNames, getPriorityQueueName(queueNamePrefix, WorkQueueSuffix, priority))
+	}
+	return append(queueNames, fmt.Sprintf("%s%s", queueNamePrefix, DeadLetterQueueSuffix))
+}
+
+// getWorkerQueueNames returns the names of the queues used by the workers, the work queue of each priority, and the
+// dead letter queue
+func getWorkerQueueNames(queueNamePrefix string) []string {
+	queueNames := make([]string, 0, len(Priorities)+1)
+	for _, priority := range Priorities {
+		queueNames = append(queueNames, getPriorityQueueName(queueNamePrefix, WorkQueueSuffix, priority))
+	}
+	return append(queueNames, fmt.Sprintf("%s%s", queueNamePrefix, DeadLetterQueueSuffix))
+}
+
+// initializeCacheWarmerQueues initializes the job and work queue of each priority, and the dead letter queue
+func initializeCacheWarmerQueues(queueNamePrefix string, initializeQueue func(queueName string) (CacheWarmerQueue, error)) (*CacheWarmerQueues, error) {
+	q := &CacheWarmerQueues{
+		jobQueues:  make(map[string]CacheWarmerQueue),
+		workQueues: make(map[string]CacheWarmerQueue),
+	}
+	for _, priority := range Priorities {
+		jobQueue, err := initializeQueue(getPriorityQueueName(queueNamePrefix, WarmPathJobQueueSuffix, priority))
+		if err != nil {
+			return nil, err
+		}
+		q.jobQueues[priority] = jobQueue
+		workQueue, err := initializeQueue(getPriorityQueueName(queueNamePrefix, WorkQueueSuffix, priority))
+		if err != nil {
+			return nil, err
+		}
+		q.workQueues[priority] = workQueue
+	}
+	deadLetterQueue, err := initializeQueue(fmt.Sprintf("%s%s", queueNamePrefix, DeadLetterQueueSuffix))
+	if err != nil {
+		return nil, err
+	}
+	q.deadLetterQueue = deadLetterQueue
+	return q, nil
+}
+
+// InitializeCacheWarmerQueues initializes the queues on Azure Storage
 func InitializeCacheWarmerQueues(
 	ctx context.Context,
 	storageAccount string,
 	storageKey string,
 	queueNamePrefix string) (*CacheWarmerQueues, error) {
 
-	jobQueue, err := azure.InitializeQueueNonFatal(ctx, storageAccount, storageKey, fmt.Sprintf("%s%s", queueNamePrefix, WarmPathJobQueueSuffix))
+	return initializeCacheWarmerQueues(queueNamePrefix, func(queueName string) (CacheWarmerQueue, error) {
+		return initializeAzureQueue(ctx, storageAccount, storageKey, queueName)
+	})
+}
+
+// InitializeManagedIdentityCacheWarmerQueues initializes the queues on Azure Storage authorized by the managed
+// identity of the VM, the queues must already exist
+func InitializeManagedIdentityCacheWarmerQueues(
+	ctx context.Context,
+	storageAccount string,
+	managedIdentityClientID string,
+	queueNamePrefix string) (*CacheWarmerQueues, error) {
+
+	credential, err := azure.NewManagedIdentityTokenCredential(managedIdentityClientID)
 	if err != nil {
 		return nil, err
 	}
-	workQueue, err := azure.InitializeQueueNonFatal(ctx, storageAccount, storageKey, fmt.Sprintf("%s%s", queueNamePrefix, WorkQueueSuffix))
-	if err != nil {
-		return nil, err
+	return initializeCacheWarmerQueues(queueNamePrefix, func(queueName string) (CacheWarmerQueue, error) {
+		return initializeManagedIdentityAzureQueue(ctx, storageAccount, credential, queueName)
+	})
+}
+
+// InitializeSasCacheWarmerQueues initializes the queues on Azure Storage authorized by the queue SAS tokens of the
+// reader, the queues must already exist.  There are only tokens for the work and dead letter queues of the workers.
+func InitializeSasCacheWarmerQueues(
+	ctx context.Context,
+	storageAccount string,
+	sasReader *QueueSasReader,
+	queueNamePrefix string) (*CacheWarmerQueues, error) {
+
+	return initializeCacheWarmerQueues(queueNamePrefix, func(queueName string) (CacheWarmerQueue, error) {
+		return initializeSasAzureQueue(ctx, storageAccount, sasReader, queueName)
+	})
+}
+
+// InitializeDirectoryCacheWarmerQueues initializes the queues as directories on an NFS export shared by all cache warmer hosts
+func InitializeDirectoryCacheWarmerQueues(
+	mountAddress string,
+	exportPath string,
+	directoryPath string,
+	queueNamePrefix string) (*CacheWarmerQueues, error) {
+
+	return initializeCacheWarmerQueues(queueNamePrefix, func(queueName string) (CacheWarmerQueue, error) {
+		return InitializeDirectoryQueue(mountAddress, exportPath, directoryPath, queueName)
+	})
+}
+
+// InitializeMemoryCacheWarmerQueues initializes in-memory queues, these are only shared within a single process
+func InitializeMemoryCacheWarmerQueues() *CacheWarmerQueues {
+	q, _ := initializeCacheWarmerQueues("", func(queueName string) (CacheWarmerQueue, error) {
+		return InitializeMemoryQueue(), nil
+	})
+	return q
+}
+
+// getJobQueue returns the job queue of the priority, unknown priorities use the normal priority queue
+func (q *CacheWarmerQueues) getJobQueue(priority string) CacheWarmerQueue {
+	if jobQueue, ok := q.jobQueues[priority]; ok {
+		return jobQueue
 	}
+	return q.jobQueues[PriorityNormal]
+}
 
-	return &CacheWarmerQueues{
-		jobQueue:  jobQueue,
-		workQueue: workQueue,
-	}, nil
+// getWorkQueue returns the work queue of the priority, unknown priorities use the normal priority queue
+func (q *CacheWarmerQueues) getWorkQueue(priority string) CacheWarmerQueue {
+	if workQueue, ok := q.workQueues[priority]; ok {
+		return workQueue
+	}
+	return q.workQueues[PriorityNormal]
 }
 
 func (q *CacheWarmerQueues) WriteWarmPathJob(job WarmPathJob) error {
@@ -82,7 +355,7 @@ func (q *CacheWarmerQueues) WriteWarmPathJob(job WarmPathJob) error {
 		return err
 	}
 
-	if err := q.jobQueue.Enqueue(content); err != nil {
+	if err := q.getJobQueue(job.Priority).Enqueue(content); err != nil {
 		return fmt.Errorf("Error writing job: %v", err)
 	}
 
@@ -91,31 +364,43 @@ func (q *CacheWarmerQueues) WriteWarmPathJob(job WarmPathJob) error {
 
 // IsJobQueueEmpty returns true if there are one or more visible or invisible items in the queue
 func (q *CacheWarmerQueues) IsJobQueueEmpty() (bool, error) {
-	if isEmpty, err := q.jobQueue.IsQueueEmpty(); err != nil {
-		return false, fmt.Errorf("error checking if job queue was empty: %v", err)
-	} else {
-		return isEmpty, nil
+	for _, priority := range Priorities {
+		if isEmpty, err := q.jobQueues[priority].IsQueueEmpty(); err != nil {
+			return false, fmt.Errorf("error checking if job queue was empty: %v", err)
+		} else if !isEmpty {
+			return false, nil
+		}
 	}
+	return true, nil
 }
 
+// GetWarmPathJob dequeues the next job of the most urgent priority
 func (q *CacheWarmerQueues) GetWarmPathJob() (*WarmPathJob, error) {
-	queueMessage, err := q.jobQueue.Dequeue(NumberOfMessagesToDequeue, CacheWarmerVisibilityTimeout)
-	if err != nil {
-		return nil, fmt.Errorf("error dequeueing message %v", err)
-	}
-	if queueMessage.NumMessages() == NumberOfMessagesToDequeue {
-		msg := queueMessage.Message(0)
-		warmPathJob, err := InitializeWarmPathJobFromString(msg.Text)
+	for _, priority := range Priorities {
+		msg, err := q.jobQueues[priority].Dequeue(CacheWarmerVisibilityTimeout)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing message text '%s': '%v'", msg.Text, err)
+			return nil, fmt.Errorf("error dequeueing message %v", err)
+		}
+		if msg != nil {
+			warmPathJob, err := InitializeWarmPathJobFromString(msg.Text)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing message text '%s': '%v'", msg.Text, err)
+			}
+			// the priority routes the later updates and delete to this queue
+			warmPathJob.Priority = priority
+			warmPathJob.SetQueueMessageInfo(msg.ID, msg.PopReceipt)
+			return warmPathJob, nil
 		}
-		warmPathJob.SetQueueMessageInfo(msg.ID, msg.PopReceipt)
-		return warmPathJob, nil
 	}
 	return nil, nil
 }
 
 func (q *CacheWarmerQueues) StillProcessingWarmPathJob(warmPathJob *WarmPathJob) error {
+	return q.HideWarmPathJob(warmPathJob, CacheWarmerVisibilityTimeout)
+}
+
+// HideWarmPathJob rewrites the message of the job from the job, and hides the message for the visibility timeout
+func (q *CacheWarmerQueues) HideWarmPathJob(warmPathJob *WarmPathJob, visibilityTimeout time.Duration) error {
 	id, popReceipt := warmPathJob.GetQueueMessageInfo()
 	if len(id) == 0 || len(popReceipt) == 0 {
 		return fmt.Errorf("queue message id incorrectly set for warmpathjob")
@@ -124,11 +409,11 @@ func (q *CacheWarmerQueues) StillProcessingWarmPathJob(warmPathJob *WarmPathJob)
 	if err != nil {
 		return err
 	}
-	resp, err := q.jobQueue.UpdateVisibilityTimeout(id, popReceipt, CacheWarmerVisibilityTimeout, message)
+	newPopReceipt, err := q.getJobQueue(warmPathJob.Priority).UpdateVisibilityTimeout(id, popReceipt, visibilityTimeout, message)
 	if err != nil {
 		return err
 	}
-	warmPathJob.SetQueueMessageInfo(id, resp.PopReceipt)
+	warmPathJob.SetQueueMessageInfo(id, newPopReceipt)
 	return nil
 }
 
@@ -137,18 +422,34 @@ func (q *CacheWarmerQueues) DeleteWarmPathJob(warmPathJob *WarmPathJob) error {
 	if len(id) == 0 || len(popReceipt) == 0 {
 		return fmt.Errorf("queue message id incorrectly set for warmpathjob")
 	}
-	if _, err := q.jobQueue.DeleteMessage(id, popReceipt); err != nil {
+	if err := q.getJobQueue(warmPathJob.Priority).DeleteMessage(id, popReceipt); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (q *CacheWarmerQueues) IsWorkQueueEmpty() (bool, error) {
-	if isEmpty, err := q.workQueue.IsQueueEmpty(); err != nil {
-		return false, fmt.Errorf("error checking if work queue was empty: %v", err)
-	} else {
-		return isEmpty, nil
+	for _, priority := range Priorities {
+		if isEmpty, err := q.workQueues[priority].IsQueueEmpty(); err != nil {
+			return false, fmt.Errorf("error checking if work queue was empty: %v", err)
+		} else if !isEmpty {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GetWorkQueueDepth returns the approximate number of worker jobs in the work queues of all priorities
+func (q *CacheWarmerQueues) GetWorkQueueDepth() (int64, error) {
+	depth := int64(0)
+	for _, priority := range Priorities {
+		count, err := q.workQueues[priority].ApproximateMessageCount()
+		if err != nil {
+			return 0, fmt.Errorf("error getting the work queue depth: %v", err)
+		}
+		depth += count
 	}
+	return depth, nil
 }
 
 func (q *CacheWarmerQueues) WriteWorkerJob(workerjob *WorkerJob) error {
@@ -157,7 +458,7 @@ func (q *CacheWarmerQueues) WriteWorkerJob(workerjob *WorkerJob) error {
 		return err
 	}
 
-	if err := q.workQueue.Enqueue(content); err != nil {
+	if err := q.getWorkQueue(workerjob.Priority).Enqueue(content); err != nil {
 		return fmt.Errorf("Error writing job: %v", err)
 	}
 
@@ -165,35 +466,48 @@ func (q *CacheWarmerQueues) WriteWorkerJob(workerjob *WorkerJob) error {
 }
 
 func (q *CacheWarmerQueues) PeekWorkerJob() (*WorkerJob, error) {
-	peekMessage, err := q.workQueue.Peek(NumberOfMessagesToDequeue)
-	if err != nil {
-		return nil, fmt.Errorf("error dequeueing message %v", err)
-	}
-	if peekMessage.NumMessages() == NumberOfMessagesToDequeue {
-		msg := peekMessage.Message(0)
-		workerJob, err := InitializeWorkerJobFromString(msg.Text)
+	for _, priority := range Priorities {
+		msg, err := q.workQueues[priority].Peek()
 		if err != nil {
-			return nil, fmt.Errorf("error parsing message text '%s': '%v'", msg.Text, err)
+			return nil, fmt.Errorf("error dequeueing message %v", err)
+		}
+		if msg != nil {
+			workerJob, err := InitializeWorkerJobFromString(msg.Text)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing message text '%s': '%v'", msg.Text, err)
+			}
+			workerJob.Priority = priority
+			workerJob.SetQueueMessageInfo(msg.ID, "")
+			return workerJob, nil
 		}
-		workerJob.SetQueueMessageInfo(msg.ID, "")
-		return workerJob, nil
 	}
 	return nil, nil
 }
 
+// GetWorkerJob dequeues the next worker job of the most urgent priority
 func (q *CacheWarmerQueues) GetWorkerJob() (*WorkerJob, error) {
-	queueMessage, err := q.workQueue.Dequeue(NumberOfMessagesToDequeue, CacheWarmerVisibilityTimeout)
-	if err != nil {
-		return nil, fmt.Errorf("error dequeueing message %v", err)
-	}
-	if queueMessage.NumMessages() == NumberOfMessagesToDequeue {
-		msg := queueMessage.Message(0)
-		workerJob, err := InitializeWorkerJobFromString(msg.Text)
+	for _, priority := range Priorities {
+		workQueue := q.workQueues[priority]
+		msg, err := workQueue.Dequeue(CacheWarmerVisibilityTimeout)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing message text '%s': '%v'", msg.Text, err)
+			return nil, fmt.Errorf("error dequeueing message %v", err)
+		}
+		if msg != nil {
+			workerJob, err := InitializeWorkerJobFromString(msg.Text)
+			if err != nil {
+				// a malformed message never parses, so move it out of the way immediately
+				reason := fmt.Sprintf("error parsing message text: '%v'", err)
+				if dlErr := q.deadLetterMessage(workQueue, msg, "", reason); dlErr != nil {
+					return nil, fmt.Errorf("error parsing message text '%s': '%v', and moving it to the dead letter queue: '%v'", msg.Text, err, dlErr)
+				}
+				return nil, fmt.Errorf("moved malformed message '%s' to the dead letter queue: '%v'", msg.Text, err)
+			}
+			// the priority routes the later updates and delete to this queue
+			workerJob.Priority = priority
+			workerJob.SetQueueMessageInfo(msg.ID, msg.PopReceipt)
+			workerJob.SetDequeueCount(msg.DequeueCount)
+			return workerJob, nil
 		}
-		workerJob.SetQueueMessageInfo(msg.ID, msg.PopReceipt)
-		return workerJob, nil
 	}
 	return nil, nil
 }
@@ -207,11 +521,27 @@ func (q *CacheWarmerQueues) StillProcessingWorkerJob(workerJob *WorkerJob) error
 	if err != nil {
 		return err
 	}
-	resp, err := q.workQueue.UpdateVisibilityTimeout(id, popReceipt, CacheWarmerVisibilityTimeout, message)
+	newPopReceipt, err := q.getWorkQueue(workerJob.Priority).UpdateVisibilityTimeout(id, popReceipt, CacheWarmerVisibilityTimeout, message)
 	if err != nil {
 		return err
 	}
-	workerJob.SetQueueMessageInfo(id, resp.PopReceipt)
+	workerJob.SetQueueMessageInfo(id, newPopReceipt)
+	return nil
+}
+
+// ReleaseWorkerJob makes the message of the worker job visible immediately, so another worker takes it
+func (q *CacheWarmerQueues) ReleaseWorkerJob(workerJob *WorkerJob) error {
+	id, popReceipt := workerJob.GetQueueMessageInfo()
+	if len(id) == 0 || len(popReceipt) == 0 {
+		return fmt.Errorf("queue message id incorrectly set for workerjob")
+	}
+	message, err := workerJob.GetWorkerJobFileContents()
+	if err != nil {
+		return err
+	}
+	if _, err := q.getWorkQueue(workerJob.Priority).UpdateVisibilityTimeout(id, popReceipt, 0, message); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -220,7 +550,7 @@ func (q *CacheWarmerQueues) DeleteWorkerJob(workerJob *WorkerJob) error {
 	if len(id) == 0 || len(popReceipt) == 0 {
 		return fmt.Errorf("queue message id incorrectly set for workerjob")
 	}
-	if _, err := q.workQueue.DeleteMessage(id, popReceipt); err != nil {
+	if err := q.getWorkQueue(workerJob.Priority).DeleteMessage(id, popReceipt); err != nil {
 		return err
 	}
 	return nil
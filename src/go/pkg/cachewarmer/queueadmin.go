@@ -0,0 +1,122 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// QueueDepth is the approximate number of visible and invisible messages of a queue
+type QueueDepth struct {
+	// QueueSuffix is the suffix of the queue name, one of the job, work, or dead letter suffixes
+	QueueSuffix string
+	// Priority is empty for the dead letter queue
+	Priority string
+	Depth    int64
+}
+
+// GetQueueDepths returns the depth of the job and work queue of each priority, and the dead letter queue
+func (q *CacheWarmerQueues) GetQueueDepths() ([]QueueDepth, error) {
+	depths := make([]QueueDepth, 0, 2*len(Priorities)+1)
+	for _, priority := range Priorities {
+		jobDepth, err := q.jobQueues[priority].ApproximateMessageCount()
+		if err != nil {
+			return nil, fmt.Errorf("error getting the job queue depth: %v", err)
+		}
+		depths = append(depths, QueueDepth{QueueSuffix: WarmPathJobQueueSuffix, Priority: priority, Depth: jobDepth})
+		workDepth, err := q.workQueues[priority].ApproximateMessageCount()
+		if err != nil {
+			return nil, fmt.Errorf("error getting the work queue depth: %v", err)
+		}
+		depths = append(depths, QueueDepth{QueueSuffix: WorkQueueSuffix, Priority: priority, Depth: workDepth})
+	}
+	deadLetterDepth, err := q.deadLetterQueue.ApproximateMessageCount()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the dead letter queue depth: %v", err)
+	}
+	return append(depths, QueueDepth{QueueSuffix: DeadLetterQueueSuffix, Depth: deadLetterDepth}), nil
+}
+
+// PeekWarmPathJobs returns up to maxMessages visible jobs of each priority, from the most to the least urgent.  The
+// jobs stay in the queue, and their dequeue count is unchanged.
+func (q *CacheWarmerQueues) PeekWarmPathJobs(maxMessages int32) ([]*WarmPathJob, error) {
+	warmPathJobs := make([]*WarmPathJob, 0)
+	for _, priority := range Priorities {
+		msgs, err := q.jobQueues[priority].PeekMessages(maxMessages)
+		if err != nil {
+			return nil, fmt.Errorf("error peeking job queue: %v", err)
+		}
+		for _, msg := range msgs {
+			warmPathJob, err := InitializeWarmPathJobFromString(msg.Text)
+			if err != nil {
+				log.Error.Printf("error parsing job message '%s' text '%s': '%v'", msg.ID, msg.Text, err)
+				continue
+			}
+			warmPathJob.Priority = priority
+			warmPathJob.SetQueueMessageInfo(msg.ID, "")
+			warmPathJobs = append(warmPathJobs, warmPathJob)
+		}
+	}
+	return warmPathJobs, nil
+}
+
+// PeekWorkerJobs returns up to maxMessages visible worker jobs of each priority, from the most to the least urgent.
+// Unlike dequeueing, peeking does not count towards the dead letter threshold of the worker jobs.
+func (q *CacheWarmerQueues) PeekWorkerJobs(maxMessages int32) ([]*WorkerJob, error) {
+	workerJobs := make([]*WorkerJob, 0)
+	for _, priority := range Priorities {
+		msgs, err := q.workQueues[priority].PeekMessages(maxMessages)
+		if err != nil {
+			return nil, fmt.Errorf("error peeking work queue: %v", err)
+		}
+		for _, msg := range msgs {
+			workerJob, err := InitializeWorkerJobFromString(msg.Text)
+			if err != nil {
+				log.Error.Printf("error parsing worker job message '%s' text '%s': '%v'", msg.ID, msg.Text, err)
+				continue
+			}
+			workerJob.Priority = priority
+			workerJob.SetQueueMessageInfo(msg.ID, "")
+			workerJob.SetDequeueCount(msg.DequeueCount)
+			workerJobs = append(workerJobs, workerJob)
+		}
+	}
+	return workerJobs, nil
+}
+
+// PeekDeadLetterJobs returns up to maxMessages visible dead letter jobs, leaving them in the dead letter queue
+// without changing their visibility
+func (q *CacheWarmerQueues) PeekDeadLetterJobs(maxMessages int32) ([]*DeadLetterJob, error) {
+	msgs, err := q.deadLetterQueue.PeekMessages(maxMessages)
+	if err != nil {
+		return nil, fmt.Errorf("error peeking dead letter queue: %v", err)
+	}
+	deadLetterJobs := make([]*DeadLetterJob, 0, len(msgs))
+	for _, msg := range msgs {
+		deadLetterJobs = append(deadLetterJobs, parseDeadLetterMessage(msg))
+	}
+	return deadLetterJobs, nil
+}
+
+// PurgeJobQueues deletes the visible and invisible jobs of all priorities, including those being processed by a manager
+func (q *CacheWarmerQueues) PurgeJobQueues() error {
+	for _, priority := range Priorities {
+		if err := q.jobQueues[priority].Clear(); err != nil {
+			return fmt.Errorf("error purging the %s priority job queue: %v", priority, err)
+		}
+	}
+	return nil
+}
+
+// PurgeWorkQueues deletes the visible and invisible worker jobs of all priorities, including those being processed by
+// a worker.  The jobs of the purged worker jobs never complete, so they should also be cancelled.
+func (q *CacheWarmerQueues) PurgeWorkQueues() error {
+	for _, priority := range Priorities {
+		if err := q.workQueues[priority].Clear(); err != nil {
+			return fmt.Errorf("error purging the %s priority work queue: %v", priority, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+	"github.com/Azure/azure-storage-queue-go/azqueue"
+)
+
+// QueueSas is the set of queue scoped SAS tokens the manager mints for the workers, keyed by queue name.  There is a
+// token for each queue used by the workers, the work queues and the dead letter queue, so a worker cannot read or
+// submit jobs.  Each token may read, add, update, and process the messages of its queue, and expires at the expiry time.
+type QueueSas struct {
+	ExpiryTime time.Time         `json:"expiryTime"`
+	Tokens     map[string]string `json:"tokens"`
+}
+
+// MintQueueSas signs a SAS token for each worker queue of the queue name prefix with the storage key
+func MintQueueSas(storageAccount string, storageKey string, queueNamePrefix string, now time.Time) (*QueueSas, error) {
+	credential, err := azqueue.NewSharedKeyCredential(storageAccount, storageKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the credentials: %v", err)
+	}
+	// the SAS times are formatted as UTC without conversion
+	now = now.UTC()
+	sas := &QueueSas{
+		ExpiryTime: now.Add(queueSasLifetime),
+		Tokens:     make(map[string]string),
+	}
+	for _, queueName := range getWorkerQueueNames(queueNamePrefix) {
+		sasQueryParameters := azqueue.QueueSASSignatureValues{
+			Protocol:    azqueue.SASProtocolHTTPS,
+			StartTime:   now.Add(-queueSasClockSkew),
+			ExpiryTime:  sas.ExpiryTime,
+			Permissions: azqueue.QueueSASPermissions{Read: true, Add: true, Update: true, Process: true}.String(),
+			QueueName:   queueName,
+		}.NewSASQueryParameters(credential)
+		sas.Tokens[queueName] = sasQueryParameters.Encode()
+	}
+	return sas, nil
+}
+
+func getQueueSasFilename(queueNamePrefix string) string {
+	return fmt.Sprintf("%s%s", queueNamePrefix, QueueSasFileSuffix)
+}
+
+func writeQueueSas(mountAddress string, exportPath string, queueNamePrefix string, sas *QueueSas) error {
+	sasPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, QueueSasDirectory, true)
+	if err != nil {
+		return fmt.Errorf("error creating queue SAS directory '%s': %v", sasPath, err)
+	}
+	data, err := json.Marshal(sas)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename so readers never see partial tokens, only the owner may read the tokens
+	filename := path.Join(sasPath, getQueueSasFilename(queueNamePrefix))
+	tmpFilename := fmt.Sprintf("%s.tmp", filename)
+	if err := ioutil.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func readQueueSas(mountAddress string, exportPath string, queueNamePrefix string) (*QueueSas, error) {
+	sasPath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, QueueSasDirectory, false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queue SAS directory '%s': %v", sasPath, err)
+	}
+	data, err := ioutil.ReadFile(path.Join(sasPath, getQueueSasFilename(queueNamePrefix)))
+	if err != nil {
+		return nil, err
+	}
+	var sas QueueSas
+	if err := json.Unmarshal(data, &sas); err != nil {
+		return nil, fmt.Errorf("error parsing queue SAS of '%s': %v", queueNamePrefix, err)
+	}
+	return &sas, nil
+}
+
+// QueueSasRotator mints the queue SAS tokens of the workers, and writes them to the bootstrap export, so the workers
+// never receive the storage key.  New tokens are written well before the previous tokens expire.
+type QueueSasRotator struct {
+	storageAccount  string
+	storageKey      string
+	queueNamePrefix string
+	mountAddress    string
+	exportPath      string
+}
+
+// InitializeQueueSasRotator initializes the queue SAS rotator of the manager
+func InitializeQueueSasRotator(
+	storageAccount string,
+	storageKey string,
+	queueNamePrefix string,
+	mountAddress string,
+	exportPath string) *QueueSasRotator {
+	return &QueueSasRotator{
+		storageAccount:  storageAccount,
+		storageKey:      storageKey,
+		queueNamePrefix: queueNamePrefix,
+		mountAddress:    mountAddress,
+		exportPath:      exportPath,
+	}
+}
+
+// Rotate mints and writes new queue SAS tokens
+func (r *QueueSasRotator) Rotate() error {
+	sas, err := MintQueueSas(r.storageAccount, r.storageKey, r.queueNamePrefix, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := writeQueueSas(r.mountAddress, r.exportPath, r.queueNamePrefix, sas); err != nil {
+		return fmt.Errorf("error writing queue SAS: %v", err)
+	}
+	log.Info.Printf("rotated the queue SAS of '%s', expiring at %v", r.queueNamePrefix, sas.ExpiryTime)
+	return nil
+}
+
+// RunQueueSasRotator rotates the queue SAS tokens until the context is cancelled
+func (r *QueueSasRotator) RunQueueSasRotator(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	log.Debug.Printf("[QueueSasRotator.RunQueueSasRotator")
+	defer log.Debug.Printf("QueueSasRotator.RunQueueSasRotator]")
+	defer syncWaitGroup.Done()
+
+	ticker := time.NewTicker(queueSasRotateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Rotate(); err != nil {
+				log.Error.Printf("error rotating queue SAS: %v", err)
+				Metrics.QueueSasFailures.Inc()
+			}
+		}
+	}
+}
+
+// QueueSasReader keeps the latest queue SAS tokens written by the manager to the bootstrap export
+type QueueSasReader struct {
+	mux             sync.Mutex
+	mountAddress    string
+	exportPath      string
+	queueNamePrefix string
+	sas             *QueueSas
+}
+
+// InitializeQueueSasReader initializes the queue SAS reader of a worker, and returns an error if the tokens cannot be read
+func InitializeQueueSasReader(mountAddress string, exportPath string, queueNamePrefix string) (*QueueSasReader, error) {
+	r := &QueueSasReader{
+		mountAddress:    mountAddress,
+		exportPath:      exportPath,
+		queueNamePrefix: queueNamePrefix,
+	}
+	if err := r.Read(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read reads the latest queue SAS tokens, and keeps the previous tokens on error
+func (r *QueueSasReader) Read() error {
+	sas, err := readQueueSas(r.mountAddress, r.exportPath, r.queueNamePrefix)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(sas.ExpiryTime) {
+		log.Warning.Printf("the queue SAS of '%s' expired at %v, the manager is not rotating the queue SAS", r.queueNamePrefix, sas.ExpiryTime)
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.sas = sas
+	return nil
+}
+
+// GetToken returns the SAS token of the queue, or the empty string if there is none
+func (r *QueueSasReader) GetToken(queueName string) string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.sas == nil {
+		return ""
+	}
+	return r.sas.Tokens[queueName]
+}
+
+// RunQueueSasReader reads the queue SAS tokens until the context is cancelled
+func (r *QueueSasReader) RunQueueSasReader(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	log.Debug.Printf("[QueueSasReader.RunQueueSasReader")
+	defer log.Debug.Printf("QueueSasReader.RunQueueSasReader]")
+	defer syncWaitGroup.Done()
+
+	ticker := time.NewTicker(queueSasReadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Read(); err != nil {
+				log.Error.Printf("error reading queue SAS: %v", err)
+				Metrics.QueueSasFailures.Inc()
+			}
+		}
+	}
+}
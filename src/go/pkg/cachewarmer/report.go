@@ -0,0 +1,386 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/azure"
+)
+
+// FileSizeHistogram counts the file sizes in power of two buckets, so the histograms of all writers of a job can
+// be summed.  Counts[0] counts the empty files, and Counts[i] counts the sizes in [2^(i-1), 2^i).
+type FileSizeHistogram struct {
+	Counts     []int64
+	MinSize    int64
+	MaxSize    int64
+	TotalBytes int64
+}
+
+// Record adds the file size to the histogram
+func (h *FileSizeHistogram) Record(size int64) {
+	if size < 0 {
+		return
+	}
+	if len(h.Counts) == 0 {
+		h.Counts = make([]int64, 64)
+		h.MinSize = size
+		h.MaxSize = size
+	}
+	h.Counts[bits.Len64(uint64(size))]++
+	if size < h.MinSize {
+		h.MinSize = size
+	}
+	if size > h.MaxSize {
+		h.MaxSize = size
+	}
+	h.TotalBytes += size
+}
+
+// Count returns the number of file sizes recorded
+func (h *FileSizeHistogram) Count() int64 {
+	count := int64(0)
+	for _, c := range h.Counts {
+		count += c
+	}
+	return count
+}
+
+// Percentile returns the file size at the percentile, rounded up to the bucket bound, P0 and P100 are exact
+func (h *FileSizeHistogram) Percentile(percentile float64) int64 {
+	count := h.Count()
+	if count == 0 {
+		return -1
+	}
+	if percentile <= 0 {
+		return h.MinSize
+	}
+	if percentile >= 100 {
+		return h.MaxSize
+	}
+	rank := int64(math.Ceil(percentile / 100 * float64(count)))
+	cumulative := int64(0)
+	for i, c := range h.Counts {
+		cumulative += c
+		if cumulative < rank {
+			continue
+		}
+		bound := int64(0)
+		if i > 0 {
+			bound = int64(1)<<uint(i) - 1
+		}
+		if bound < h.MinSize {
+			bound = h.MinSize
+		}
+		if bound > h.MaxSize {
+			bound = h.MaxSize
+		}
+		return bound
+	}
+	return h.MaxSize
+}
+
+func (h *FileSizeHistogram) add(other *FileSizeHistogram) {
+	if other.Count() == 0 {
+		return
+	}
+	if h.Count() == 0 {
+		h.Counts = make([]int64, 64)
+		h.MinSize = other.MinSize
+		h.MaxSize = other.MaxSize
+	}
+	for i := 0; i < len(h.Counts) && i < len(other.Counts); i++ {
+		h.Counts[i] += other.Counts[i]
+	}
+	if other.MinSize < h.MinSize {
+		h.MinSize = other.MinSize
+	}
+	if other.MaxSize > h.MaxSize {
+		h.MaxSize = other.MaxSize
+	}
+	h.TotalBytes += other.TotalBytes
+}
+
+// FailedFile records a file, directory, or worker job that failed, the path includes the export path
+type FailedFile struct {
+	Path  string
+	Error string
+}
+
+// FileSizeStats are the file size statistics of a job, the percentiles are -1 if no files matched
+type FileSizeStats struct {
+	FileCount  int64
+	TotalBytes int64
+	P0         int64
+	P10        int64
+	P50        int64
+	P75        int64
+	P90        int64
+	P95        int64
+	P99        int64
+	P100       int64
+}
+
+// JobReport is the machine readable summary of a job, written when the job completes.  The state of a job that dropped
+// its remaining work at the deadline is partial, and the expired paths list the first of the dropped paths.
+type JobReport struct {
+	JobID                  string
+	WarmTargetExportPath   string
+	WarmTargetPath         string
+	State                  string
+	JobError               string
+	StartTime              time.Time
+	EndTime                time.Time
+	DurationSeconds        float64
+	WorkerJobsEnqueued     int64
+	WorkerJobsCompleted    int64
+	WorkerJobsFailed       int64
+	WorkerJobsDeadLettered int64
+	DirectoriesListed      int64
+	DirectoriesSkipped     int64
+	DirectoriesExpired     int64
+	FileListEntriesExpired int64
+	FilesQueued            int64
+	FilesRead              int64
+	FilesFailed            int64
+	FilesStatted           int64
+	FilesStatFailed        int64
+	FilesChecksummed       int64
+	ChecksumMismatches     int64
+	BytesRead              int64
+	BytesPerSecond         float64
+	FilesPerSecond         float64
+	WorkerJobsExpired      int64
+	FilesExpired           int64
+	WorkerJobsCancelled    int64
+	FilesCancelled         int64
+	Deadline               time.Time
+	FilesSkipped           map[string]int64
+	FileSizes              FileSizeStats
+	FailedFiles            []FailedFile
+	FailedFilesOmitted     int64
+	ExpiredPaths           []string
+	ExpiredPathsOmitted    int64
+}
+
+// InitializeJobReport initializes the report of the job from its status
+func InitializeJobReport(warmTargetExportPath string, warmTargetPath string, status *JobStatus) *JobReport {
+	report := &JobReport{
+		JobID:                  status.JobID,
+		WarmTargetExportPath:   warmTargetExportPath,
+		WarmTargetPath:         warmTargetPath,
+		State:                  status.State(),
+		JobError:               status.JobError,
+		StartTime:              status.StartTime,
+		EndTime:                status.LastUpdate,
+		WorkerJobsEnqueued:     status.WorkerJobsEnqueued,
+		WorkerJobsCompleted:    status.WorkerJobsCompleted,
+		WorkerJobsFailed:       status.WorkerJobsFailed,
+		WorkerJobsDeadLettered: status.WorkerJobsDeadLettered,
+		DirectoriesListed:      status.DirectoriesListed,
+		DirectoriesSkipped:     status.DirectoriesSkipped,
+		DirectoriesExpired:     status.DirectoriesExpired,
+		FileListEntriesExpired: status.FileListEntriesExpired,
+		FilesQueued:            status.FilesQueued,
+		FilesRead:              status.FilesRead,
+		FilesFailed:            status.FilesFailed,
+		FilesStatted:           status.FilesStatted,
+		FilesStatFailed:        status.FilesStatFailed,
+		FilesChecksummed:       status.FilesChecksummed,
+		ChecksumMismatches:     status.ChecksumMismatches,
+		BytesRead:              status.BytesRead,
+		WorkerJobsExpired:      status.WorkerJobsExpired,
+		FilesExpired:           status.FilesExpired,
+		WorkerJobsCancelled:    status.WorkerJobsCancelled,
+		FilesCancelled:         status.FilesCancelled,
+		Deadline:               status.Deadline,
+		FilesSkipped:           status.FilesSkipped,
+		FileSizes: FileSizeStats{
+			FileCount:  status.FileSizes.Count(),
+			TotalBytes: status.FileSizes.TotalBytes,
+			P0:         status.FileSizes.Percentile(0),
+			P10:        status.FileSizes.Percentile(10),
+			P50:        status.FileSizes.Percentile(50),
+			P75:        status.FileSizes.Percentile(75),
+			P90:        status.FileSizes.Percentile(90),
+			P95:        status.FileSizes.Percentile(95),
+			P99:        status.FileSizes.Percentile(99),
+			P100:       status.FileSizes.Percentile(100),
+		},
+		FailedFiles:         status.FailedFiles,
+		FailedFilesOmitted:  status.FailedFilesOmitted,
+		ExpiredPaths:        status.ExpiredPaths,
+		ExpiredPathsOmitted: status.ExpiredPathsOmitted,
+	}
+	if report.FilesSkipped == nil {
+		report.FilesSkipped = make(map[string]int64)
+	}
+	if report.FailedFiles == nil {
+		report.FailedFiles = []FailedFile{}
+	}
+	if report.ExpiredPaths == nil {
+		report.ExpiredPaths = []string{}
+	}
+	if !report.StartTime.IsZero() && report.EndTime.After(report.StartTime) {
+		duration := report.EndTime.Sub(report.StartTime).Seconds()
+		report.DurationSeconds = duration
+		report.BytesPerSecond = float64(report.BytesRead) / duration
+		report.FilesPerSecond = float64(report.FilesRead+report.FilesStatted) / duration
+	}
+	return report
+}
+
+// GetJsonContents returns the json form of the report
+func (r *JobReport) GetJsonContents() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// GetCsvContents returns the csv form of the report, with one 'section,name,value' row per value.  The sections
+// are summary, skipped, fileSize, failed, and expired, where the name of a failed row is the path and the value the
+// error, and the name of an expired row is the path dropped at the deadline.
+func (r *JobReport) GetCsvContents() ([]byte, error) {
+	formatInt := func(v int64) string { return strconv.FormatInt(v, 10) }
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	rows := [][]string{
+		{"section", "name", "value"},
+		{"summary", "jobId", r.JobID},
+		{"summary", "warmTargetExportPath", r.WarmTargetExportPath},
+		{"summary", "warmTargetPath", r.WarmTargetPath},
+		{"summary", "state", r.State},
+		{"summary", "jobError", r.JobError},
+		{"summary", "startTime", formatTime(r.StartTime)},
+		{"summary", "endTime", formatTime(r.EndTime)},
+		{"summary", "durationSeconds", formatFloat(r.DurationSeconds)},
+		{"summary", "workerJobsEnqueued", formatInt(r.WorkerJobsEnqueued)},
+		{"summary", "workerJobsCompleted", formatInt(r.WorkerJobsCompleted)},
+		{"summary", "workerJobsFailed", formatInt(r.WorkerJobsFailed)},
+		{"summary", "workerJobsDeadLettered", formatInt(r.WorkerJobsDeadLettered)},
+		{"summary", "directoriesListed", formatInt(r.DirectoriesListed)},
+		{"summary", "directoriesSkipped", formatInt(r.DirectoriesSkipped)},
+		{"summary", "directoriesExpired", formatInt(r.DirectoriesExpired)},
+		{"summary", "fileListEntriesExpired", formatInt(r.FileListEntriesExpired)},
+		{"summary", "filesQueued", formatInt(r.FilesQueued)},
+		{"summary", "filesRead", formatInt(r.FilesRead)},
+		{"summary", "filesFailed", formatInt(r.FilesFailed)},
+		{"summary", "filesStatted", formatInt(r.FilesStatted)},
+		{"summary", "filesStatFailed", formatInt(r.FilesStatFailed)},
+		{"summary", "filesChecksummed", formatInt(r.FilesChecksummed)},
+		{"summary", "checksumMismatches", formatInt(r.ChecksumMismatches)},
+		{"summary", "bytesRead", formatInt(r.BytesRead)},
+		{"summary", "bytesPerSecond", formatFloat(r.BytesPerSecond)},
+		{"summary", "filesPerSecond", formatFloat(r.FilesPerSecond)},
+		{"summary", "workerJobsExpired", formatInt(r.WorkerJobsExpired)},
+		{"summary", "filesExpired", formatInt(r.FilesExpired)},
+		{"summary", "workerJobsCancelled", formatInt(r.WorkerJobsCancelled)},
+		{"summary", "filesCancelled", formatInt(r.FilesCancelled)},
+		{"summary", "deadline", formatTime(r.Deadline)},
+		{"summary", "failedFilesOmitted", formatInt(r.FailedFilesOmitted)},
+		{"summary", "expiredPathsOmitted", formatInt(r.ExpiredPathsOmitted)},
+	}
+
+	reasons := make([]string, 0, len(r.FilesSkipped))
+	for reason := range r.FilesSkipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		rows = append(rows, []string{"skipped", reason, formatInt(r.FilesSkipped[reason])})
+	}
+
+	rows = append(rows,
+		[]string{"fileSize", "fileCount", formatInt(r.FileSizes.FileCount)},
+		[]string{"fileSize", "totalBytes", formatInt(r.FileSizes.TotalBytes)},
+		[]string{"fileSize", "P0", formatInt(r.FileSizes.P0)},
+		[]string{"fileSize", "P10", formatInt(r.FileSizes.P10)},
+		[]string{"fileSize", "P50", formatInt(r.FileSizes.P50)},
+		[]string{"fileSize", "P75", formatInt(r.FileSizes.P75)},
+		[]string{"fileSize", "P90", formatInt(r.FileSizes.P90)},
+		[]string{"fileSize", "P95", formatInt(r.FileSizes.P95)},
+		[]string{"fileSize", "P99", formatInt(r.FileSizes.P99)},
+		[]string{"fileSize", "P100", formatInt(r.FileSizes.P100)})
+
+	for _, failedFile := range r.FailedFiles {
+		rows = append(rows, []string{"failed", failedFile.Path, failedFile.Error})
+	}
+
+	for _, expiredPath := range r.ExpiredPaths {
+		rows = append(rows, []string{"expired", expiredPath, ""})
+	}
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// WriteJobReport writes the json and csv report of the job below the report directory of the warm target export
+func WriteJobReport(mountAddress string, report *JobReport) error {
+	reportPath, err := ensureJobPath(mountAddress, report.WarmTargetExportPath, JobProgressDirectory, ReportDirectory, true)
+	if err != nil {
+		return fmt.Errorf("error creating report directory '%s': %v", reportPath, err)
+	}
+	jsonData, err := report.GetJsonContents()
+	if err != nil {
+		return err
+	}
+	csvData, err := report.GetCsvContents()
+	if err != nil {
+		return err
+	}
+	for suffix, data := range map[string][]byte{ReportJsonSuffix: jsonData, ReportCsvSuffix: csvData} {
+		// write to a temporary file and rename so readers never see a partial report
+		filename := path.Join(reportPath, fmt.Sprintf("%s%s", report.JobID, suffix))
+		tmpFilename := fmt.Sprintf("%s.tmp", filename)
+		if err := ioutil.WriteFile(tmpFilename, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpFilename, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UploadJobReport uploads the json and csv report of the job to the blob container
+func UploadJobReport(ctx context.Context, storageAccount string, storageKey string, containerName string, report *JobReport) error {
+	if len(storageAccount) == 0 || len(storageKey) == 0 {
+		return fmt.Errorf("the storage account is required to upload the report to blob container '%s'", containerName)
+	}
+	blobContainer, err := azure.InitializeBlobContainer(ctx, storageAccount, storageKey, containerName)
+	if err != nil {
+		return fmt.Errorf("error initializing blob container '%s': %v", containerName, err)
+	}
+	jsonData, err := report.GetJsonContents()
+	if err != nil {
+		return err
+	}
+	csvData, err := report.GetCsvContents()
+	if err != nil {
+		return err
+	}
+	if err := blobContainer.UploadBlob(fmt.Sprintf("%s%s", report.JobID, ReportJsonSuffix), jsonData); err != nil {
+		return err
+	}
+	return blobContainer.UploadBlob(fmt.Sprintf("%s%s", report.JobID, ReportCsvSuffix), csvData)
+}
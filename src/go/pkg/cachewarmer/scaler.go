@@ -0,0 +1,97 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"math"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// WorkerScaler sizes the worker VMSS from the work queue depth and the observed worker throughput
+type WorkerScaler struct {
+	MinWorkerCount int64
+	MaxWorkerCount int64
+
+	// the last sample of the work queue
+	lastSampleTime     time.Time
+	lastCompletedCount int64
+	lastWorkerCapacity int64
+
+	// the moving average of the worker jobs completed per worker per second, 0 until observed
+	workerJobsPerWorkerSecond float64
+
+	// the time the capacity was first found to be too large
+	scaleDownTime time.Time
+}
+
+// InitializeWorkerScaler initializes the worker scaler
+func InitializeWorkerScaler(minWorkerCount int64, maxWorkerCount int64) *WorkerScaler {
+	return &WorkerScaler{
+		MinWorkerCount: minWorkerCount,
+		MaxWorkerCount: maxWorkerCount,
+	}
+}
+
+// GetDesiredCapacity returns the worker count that drains the work queue within the target drain time.  The
+// depth is the approximate work queue depth, the completed count is the total worker jobs the workers reported
+// done in the job progress, and the current capacity is the worker count of the VMSS
+func (s *WorkerScaler) GetDesiredCapacity(now time.Time, depth int64, completedCount int64, currentCapacity int64) int64 {
+	s.observeThroughput(now, completedCount, currentCapacity)
+
+	desiredCapacity := s.MinWorkerCount
+	if depth > 0 {
+		workerJobsPerWorker := float64(initialWorkerJobsPerWorker)
+		if s.workerJobsPerWorkerSecond > 0 {
+			workerJobsPerWorker = s.workerJobsPerWorkerSecond * scaleTargetDrainTime.Seconds()
+		}
+		desiredCapacity = int64(math.Ceil(float64(depth) / workerJobsPerWorker))
+		if desiredCapacity < 1 {
+			desiredCapacity = 1
+		}
+	}
+	if desiredCapacity < s.MinWorkerCount {
+		desiredCapacity = s.MinWorkerCount
+	}
+	if desiredCapacity > s.MaxWorkerCount {
+		desiredCapacity = s.MaxWorkerCount
+	}
+
+	// scale up immediately, but only scale down once the capacity has been too large for a while,
+	// since scaling down may stop workers in the middle of a worker job
+	if desiredCapacity < currentCapacity {
+		if s.scaleDownTime.IsZero() {
+			s.scaleDownTime = now
+		}
+		if now.Sub(s.scaleDownTime) < scaleDownDelay {
+			return currentCapacity
+		}
+	}
+	s.scaleDownTime = time.Time{}
+	return desiredCapacity
+}
+
+// observeThroughput updates the worker throughput from the worker jobs the workers reported done since the last
+// sample, which include the worker jobs written by the workers and the requeued dead lettered worker jobs.  Samples
+// are skipped while the capacity changes, since new workers take minutes to start reading.
+func (s *WorkerScaler) observeThroughput(now time.Time, completedCount int64, currentCapacity int64) {
+	if !s.lastSampleTime.IsZero() && now.Sub(s.lastSampleTime) < scaleSampleInterval {
+		return
+	}
+	if !s.lastSampleTime.IsZero() && currentCapacity > 0 && currentCapacity == s.lastWorkerCapacity {
+		completed := completedCount - s.lastCompletedCount
+		if completed > 0 {
+			sample := float64(completed) / now.Sub(s.lastSampleTime).Seconds() / float64(currentCapacity)
+			if s.workerJobsPerWorkerSecond == 0 {
+				s.workerJobsPerWorkerSecond = sample
+			} else {
+				s.workerJobsPerWorkerSecond = throughputSmoothing*sample + (1-throughputSmoothing)*s.workerJobsPerWorkerSecond
+			}
+			log.Info.Printf("observed %.4f worker jobs per worker per second, average %.4f", sample, s.workerJobsPerWorkerSecond)
+		}
+	}
+	s.lastSampleTime = now
+	s.lastCompletedCount = completedCount
+	s.lastWorkerCapacity = currentCapacity
+}
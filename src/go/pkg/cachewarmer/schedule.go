@@ -0,0 +1,538 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// CronSchedule is a standard five field cron expression 'minute hour day-of-month month day-of-week', where each
+// field is '*', or a comma separated list of values or ranges, each optionally followed by a '/step'.  As in cron,
+// when both the day of month and the day of week are restricted, a time matching either one matches, and a field
+// starting with '*', such as '*/2', is not restricted.
+type CronSchedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	anyDay      bool
+	anyWeekday  bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCronSchedule parses the cron expression, or one of the @yearly, @monthly, @weekly, @daily, or @hourly aliases
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	if alias, ok := cronAliases[strings.TrimSpace(expression)]; ok {
+		expression = alias
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' does not have 5 fields", expression)
+	}
+	var err error
+	c := &CronSchedule{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+	if c.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron expression '%s' minute: %v", expression, err)
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron expression '%s' hour: %v", expression, err)
+	}
+	if c.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron expression '%s' day of month: %v", expression, err)
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron expression '%s' month: %v", expression, err)
+	}
+	if c.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron expression '%s' day of week: %v", expression, err)
+	}
+	// both 0 and 7 are Sunday
+	if c.daysOfWeek&(1<<7) != 0 {
+		c.daysOfWeek |= 1
+	}
+	return c, nil
+}
+
+// parseCronField returns the bit set of the values of the field
+func parseCronField(field string, min int, max int) (uint64, error) {
+	result := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.Split(part, "-")
+			if len(bounds) > 2 {
+				return 0, fmt.Errorf("invalid range '%s'", part)
+			}
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", bounds[0])
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value '%s'", bounds[1])
+				}
+			} else if step > 1 {
+				// 'n/step' runs from n to the maximum
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("'%s' is not within %d-%d", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			result |= 1 << uint(v)
+		}
+	}
+	return result, nil
+}
+
+// Matches returns true if the minute of the time matches the schedule
+func (c *CronSchedule) Matches(t time.Time) bool {
+	if c.minutes&(1<<uint(t.Minute())) == 0 || c.hours&(1<<uint(t.Hour())) == 0 || c.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayMatches := c.daysOfMonth&(1<<uint(t.Day())) != 0
+	weekdayMatches := c.daysOfWeek&(1<<uint(t.Weekday())) != 0
+	if !c.anyDay && !c.anyWeekday {
+		return dayMatches || weekdayMatches
+	}
+	return dayMatches && weekdayMatches
+}
+
+// ScheduledJob is a warm job definition of the schedule file, the fields match the job submitter options.  The
+// blackout windows are 'HH:MM-HH:MM' times of day in the local time of the manager, and a run starting within a
+// blackout window is skipped.  ModifiedAfter and ModifiedBefore are RFC3339 times or durations before the run.
+type ScheduledJob struct {
+	Name                     string   `json:"name"`
+	Cron                     string   `json:"cron"`
+	BlackoutWindows          []string `json:"blackoutWindows"`
+	MaxRunAge                string   `json:"maxRunAge"`
+	WarmTargetMountAddresses string   `json:"warmTargetMountAddresses"`
+	WarmTargetExportPath     string   `json:"warmTargetExportPath"`
+	WarmTargetPath           string   `json:"warmTargetPath"`
+	Priority                 string   `json:"priority"`
+	FullWarm                 bool     `json:"full"`
+	MetadataOnly             bool     `json:"metadataOnly"`
+	MetadataReadBytes        int64    `json:"metadataReadBytes"`
+	MaxMBPerSecond           int64    `json:"maxMBPerSecond"`
+	MaxFilesPerSecond        int64    `json:"maxFilesPerSecond"`
+	ThrottleSchedule         string   `json:"throttleSchedule"`
+	Checksum                 bool     `json:"checksum"`
+	ReferenceChecksumPath    string   `json:"referenceChecksumPath"`
+	FileListPath             string   `json:"fileListPath"`
+	DistributedEnumeration   bool     `json:"distributedEnumeration"`
+	ReportBlobContainer      string   `json:"reportBlobContainer"`
+	ManifestBlobContainer    string   `json:"manifestBlobContainer"`
+	Deadline                 string   `json:"deadline"`
+	SymlinkPolicy            string   `json:"symlinkPolicy"`
+	MountType                string   `json:"mountType"`
+	MountOptions             string   `json:"mountOptions"`
+	InclusionCsv             string   `json:"inclusionCsv"`
+	ExclusionCsv             string   `json:"exclusionCsv"`
+	PathInclusionCsv         string   `json:"pathInclusionCsv"`
+	PathExclusionCsv         string   `json:"pathExclusionCsv"`
+	InclusionRegex           string   `json:"inclusionRegex"`
+	ExclusionRegex           string   `json:"exclusionRegex"`
+	MinFileSizeBytes         int64    `json:"minFileSizeBytes"`
+	MaxFileSizeBytes         int64    `json:"maxFileSizeBytes"`
+	ModifiedAfter            string   `json:"modifiedAfter"`
+	ModifiedBefore           string   `json:"modifiedBefore"`
+	cron                     *CronSchedule
+	maxRunAge                time.Duration
+}
+
+// JobSchedule is the json schedule file of the manager
+type JobSchedule struct {
+	Jobs []*ScheduledJob `json:"jobs"`
+}
+
+var scheduledJobNameRegexp = regexp.MustCompile("^[A-Za-z0-9._-]+$")
+
+// ReadJobSchedule reads and validates the schedule file
+func ReadJobSchedule(scheduleFile string) (*JobSchedule, error) {
+	data, err := ioutil.ReadFile(scheduleFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schedule file '%s': %v", scheduleFile, err)
+	}
+	var schedule JobSchedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("error parsing schedule file '%s': %v", scheduleFile, err)
+	}
+	names := make(map[string]bool)
+	for i, job := range schedule.Jobs {
+		if job == nil {
+			return nil, fmt.Errorf("schedule job %d is empty", i)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("schedule job name '%s' is not unique", job.Name)
+		}
+		names[job.Name] = true
+		if err := job.validate(); err != nil {
+			return nil, fmt.Errorf("schedule job '%s': %v", job.Name, err)
+		}
+	}
+	return &schedule, nil
+}
+
+func (j *ScheduledJob) validate() error {
+	if !scheduledJobNameRegexp.MatchString(j.Name) {
+		return fmt.Errorf("the name must only contain letters, digits, '.', '_', or '-'")
+	}
+	cron, err := ParseCronSchedule(j.Cron)
+	if err != nil {
+		return err
+	}
+	j.cron = cron
+	j.maxRunAge = scheduledRunMaxAge
+	if len(j.MaxRunAge) > 0 {
+		if j.maxRunAge, err = time.ParseDuration(j.MaxRunAge); err != nil || j.maxRunAge <= 0 {
+			return fmt.Errorf("maxRunAge '%s' is not a positive duration", j.MaxRunAge)
+		}
+	}
+	for _, window := range j.BlackoutWindows {
+		if _, _, err := parseBlackoutWindow(window); err != nil {
+			return err
+		}
+	}
+	if len(j.WarmTargetMountAddresses) == 0 || len(j.WarmTargetExportPath) == 0 || len(j.WarmTargetPath) == 0 {
+		return fmt.Errorf("warmTargetMountAddresses, warmTargetExportPath, and warmTargetPath must be specified")
+	}
+	if len(j.Priority) > 0 && !IsValidPriority(j.Priority) {
+		return fmt.Errorf("priority '%s' is not valid", j.Priority)
+	}
+	if j.MetadataReadBytes < 0 || (j.MetadataReadBytes > 0 && !j.MetadataOnly) {
+		return fmt.Errorf("metadataReadBytes must not be negative, and requires metadataOnly")
+	}
+	if j.Checksum && j.MetadataOnly {
+		return fmt.Errorf("checksum may not be combined with metadataOnly")
+	}
+	if len(j.ReferenceChecksumPath) > 0 && !j.Checksum {
+		return fmt.Errorf("referenceChecksumPath requires checksum")
+	}
+	if j.DistributedEnumeration && len(j.FileListPath) > 0 {
+		return fmt.Errorf("distributedEnumeration may not be combined with a file list")
+	}
+	warmPathJob, err := j.InitializeWarmPathJob(time.Now())
+	if err != nil {
+		return err
+	}
+	return warmPathJob.ValidateManifest()
+}
+
+// InitializeWarmPathJob initializes a new warm path job of the definition for a run at the time
+func (j *ScheduledJob) InitializeWarmPathJob(now time.Time) (*WarmPathJob, error) {
+	modifiedAfter, err := ParseModifiedTime(j.ModifiedAfter, now)
+	if err != nil {
+		return nil, fmt.Errorf("modifiedAfter is not valid: %v", err)
+	}
+	modifiedBefore, err := ParseModifiedTime(j.ModifiedBefore, now)
+	if err != nil {
+		return nil, fmt.Errorf("modifiedBefore is not valid: %v", err)
+	}
+	fileFilter, err := InitializeFileFilter(
+		j.WarmTargetPath,
+		j.InclusionCsv,
+		j.ExclusionCsv,
+		j.PathInclusionCsv,
+		j.PathExclusionCsv,
+		j.InclusionRegex,
+		j.ExclusionRegex,
+		j.MinFileSizeBytes,
+		j.MaxFileSizeBytes,
+		modifiedAfter,
+		modifiedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("file filter is not valid: %v", err)
+	}
+	throttleSettings, err := InitializeThrottleSettings(j.MaxMBPerSecond, j.MaxFilesPerSecond, j.ThrottleSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("throttle is not valid: %v", err)
+	}
+	mountSettings, err := InitializeMountSettings(j.MountType, j.MountOptions)
+	if err != nil {
+		return nil, fmt.Errorf("mount settings are not valid: %v", err)
+	}
+	deadline, err := ParseDeadline(j.Deadline, now)
+	if err != nil {
+		return nil, fmt.Errorf("deadline is not valid: %v", err)
+	}
+	if err := ValidateSymlinkPolicy(j.SymlinkPolicy); err != nil {
+		return nil, err
+	}
+	priority := j.Priority
+	if len(priority) == 0 {
+		priority = PriorityNormal
+	}
+	return InitializeWarmPathJob(
+		j.WarmTargetMountAddresses,
+		j.WarmTargetExportPath,
+		j.WarmTargetPath,
+		j.FileListPath,
+		"",
+		j.DistributedEnumeration,
+		j.ReportBlobContainer,
+		JobSettings{
+			Priority:              priority,
+			FullWarm:              j.FullWarm,
+			ManifestBlobContainer: j.ManifestBlobContainer,
+			MetadataOnly:          j.MetadataOnly,
+			MetadataReadBytes:     j.MetadataReadBytes,
+			Throttle:              throttleSettings,
+			Checksum:              ChecksumSettings{Enabled: j.Checksum, ReferencePath: j.ReferenceChecksumPath},
+			Mount:                 mountSettings,
+			Deadline:              deadline,
+			SymlinkPolicy:         j.SymlinkPolicy,
+			FileFilter:            *fileFilter,
+		}), nil
+}
+
+// IsBlackedOut returns true if the time of day of the time is within a blackout window
+func (j *ScheduledJob) IsBlackedOut(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range j.BlackoutWindows {
+		start, end, err := parseBlackoutWindow(window)
+		if err != nil {
+			continue
+		}
+		if (start <= end && minute >= start && minute < end) || (start > end && (minute >= start || minute < end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBlackoutWindow returns the start and end minute of day of the 'HH:MM-HH:MM' window, an end time before the
+// start time wraps past midnight
+func parseBlackoutWindow(window string) (int, int, error) {
+	times := strings.Split(window, "-")
+	if len(times) != 2 {
+		return 0, 0, fmt.Errorf("blackout window '%s' is not of the form 'HH:MM-HH:MM'", window)
+	}
+	start, err := parseMinuteOfDay(times[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("blackout window '%s': %v", window, err)
+	}
+	end, err := parseMinuteOfDay(times[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("blackout window '%s': %v", window, err)
+	}
+	return start, end, nil
+}
+
+// ScheduledRun records the last job submitted for a schedule job definition
+type ScheduledRun struct {
+	Name       string
+	JobID      string
+	SubmitTime time.Time
+}
+
+// readScheduledRun reads the last run of the definition from its warm target export, nil if it has never run
+func readScheduledRun(mountAddress string, exportPath string, name string) (*ScheduledRun, error) {
+	schedulePath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, ScheduleDirectory, true)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schedule directory '%s': %v", schedulePath, err)
+	}
+	data, err := ioutil.ReadFile(path.Join(schedulePath, fmt.Sprintf("%s%s", name, JobProgressFileSuffix)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var run ScheduledRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("error parsing scheduled run '%s': %v", name, err)
+	}
+	return &run, nil
+}
+
+func writeScheduledRun(mountAddress string, exportPath string, run *ScheduledRun) error {
+	schedulePath, err := ensureJobPath(mountAddress, exportPath, JobProgressDirectory, ScheduleDirectory, true)
+	if err != nil {
+		return fmt.Errorf("error creating schedule directory '%s': %v", schedulePath, err)
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename so readers never see a partial record
+	filename := path.Join(schedulePath, fmt.Sprintf("%s%s", run.Name, JobProgressFileSuffix))
+	tmpFilename := fmt.Sprintf("%s.tmp", filename)
+	if err := ioutil.WriteFile(tmpFilename, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// JobScheduler submits the warm jobs of the schedule file at the times of their cron expressions.  The schedule file
+// is re-read when it changes, and an invalid schedule file leaves the previous schedule in place.
+type JobScheduler struct {
+	Queues          *CacheWarmerQueues
+	scheduleFile    string
+	scheduleModTime time.Time
+	schedule        *JobSchedule
+}
+
+// InitializeJobScheduler initializes the job scheduler, and returns an error if the schedule file is invalid
+func InitializeJobScheduler(queues *CacheWarmerQueues, scheduleFile string) (*JobScheduler, error) {
+	s := &JobScheduler{
+		Queues:       queues,
+		scheduleFile: scheduleFile,
+	}
+	if err := s.loadSchedule(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JobScheduler) loadSchedule() error {
+	fileInfo, err := os.Stat(s.scheduleFile)
+	if err != nil {
+		return fmt.Errorf("error reading schedule file '%s': %v", s.scheduleFile, err)
+	}
+	if s.schedule != nil && fileInfo.ModTime().Equal(s.scheduleModTime) {
+		return nil
+	}
+	schedule, err := ReadJobSchedule(s.scheduleFile)
+	if err != nil {
+		return err
+	}
+	s.schedule = schedule
+	s.scheduleModTime = fileInfo.ModTime()
+	log.Status.Printf("loaded %d scheduled jobs from '%s'", len(schedule.Jobs), s.scheduleFile)
+	return nil
+}
+
+// RunScheduler checks the schedule every minute until cancelled
+func (s *JobScheduler) RunScheduler(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	defer syncWaitGroup.Done()
+	log.Debug.Printf("[JobScheduler.RunScheduler")
+	defer log.Debug.Printf("JobScheduler.RunScheduler]")
+
+	lastMinute := time.Now().Truncate(time.Minute)
+	ticker := time.NewTicker(timeBetweenScheduleCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			currentMinute := time.Now().Truncate(time.Minute)
+			if !currentMinute.After(lastMinute) {
+				continue
+			}
+			if err := s.loadSchedule(); err != nil {
+				log.Error.Printf("error reloading schedule, keeping the previous schedule: %v", err)
+			}
+			// a minute missed while submitting jobs still runs, up to the catch up limit
+			if currentMinute.Sub(lastMinute) > scheduleCatchUp {
+				log.Warning.Printf("skipping the scheduled runs between %v and %v", lastMinute, currentMinute.Add(-scheduleCatchUp))
+				lastMinute = currentMinute.Add(-scheduleCatchUp)
+			}
+			for minute := lastMinute.Add(time.Minute); !minute.After(currentMinute); minute = minute.Add(time.Minute) {
+				for _, job := range s.schedule.Jobs {
+					if isCancelled(ctx) {
+						return
+					}
+					if job.cron.Matches(minute) {
+						s.runScheduledJob(job, minute)
+					}
+				}
+			}
+			lastMinute = currentMinute
+		}
+	}
+}
+
+// runScheduledJob submits a job for the definition, unless blacked out or the previous run has not finished.  A
+// previous run not finished within the maximum run age is abandoned and cancelled, so a run that never completes,
+// for example because its messages were purged, does not block the definition forever.
+func (s *JobScheduler) runScheduledJob(job *ScheduledJob, runTime time.Time) {
+	if job.IsBlackedOut(runTime) {
+		log.Status.Printf("skipping scheduled job '%s' at %v, within a blackout window", job.Name, runTime)
+		Metrics.ScheduledJobsSkipped.Inc()
+		return
+	}
+	warmPathJob, err := job.InitializeWarmPathJob(runTime)
+	if err != nil {
+		log.Error.Printf("error initializing scheduled job '%s': %v", job.Name, err)
+		return
+	}
+	mountAddress := warmPathJob.WarmTargetMountAddresses[0]
+	// the runs of the definition are kept on the export, mounted with the settings of the job if not yet mounted
+	RegisterMountSettings(warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmPathJob.Mount)
+
+	previousRun, err := readScheduledRun(mountAddress, warmPathJob.WarmTargetExportPath, job.Name)
+	if err != nil {
+		log.Error.Printf("error reading the previous run of scheduled job '%s', skipping: %v", job.Name, err)
+		Metrics.ScheduledJobsSkipped.Inc()
+		return
+	}
+	if previousRun != nil {
+		status, err := ReadJobStatus(mountAddress, warmPathJob.WarmTargetExportPath, previousRun.JobID)
+		if err != nil {
+			log.Error.Printf("error reading the status of job '%s' of scheduled job '%s', skipping: %v", previousRun.JobID, job.Name, err)
+			Metrics.ScheduledJobsSkipped.Inc()
+			return
+		}
+		if !status.IsComplete() && !status.Cancelled {
+			if time.Since(previousRun.SubmitTime) <= job.maxRunAge {
+				log.Status.Printf("skipping scheduled job '%s' at %v, the previous job '%s' is %s", job.Name, runTime, previousRun.JobID, status.State())
+				Metrics.ScheduledJobsSkipped.Inc()
+				return
+			}
+			// cancel the abandoned job, so its remaining work does not compete with the new run
+			log.Status.Printf("abandoning the previous job '%s' of scheduled job '%s', still %s after %v", previousRun.JobID, job.Name, status.State(), job.maxRunAge)
+			if err := CancelJob(mountAddress, warmPathJob.WarmTargetExportPath, previousRun.JobID); err != nil {
+				log.Error.Printf("error cancelling the abandoned job '%s' of scheduled job '%s', skipping: %v", previousRun.JobID, job.Name, err)
+				Metrics.ScheduledJobsSkipped.Inc()
+				return
+			}
+		}
+	}
+
+	if err := s.Queues.WriteWarmPathJob(*warmPathJob); err != nil {
+		log.Error.Printf("error submitting scheduled job '%s': %v", job.Name, err)
+		return
+	}
+	if err := writeScheduledRun(mountAddress, warmPathJob.WarmTargetExportPath, &ScheduledRun{Name: job.Name, JobID: warmPathJob.JobID, SubmitTime: time.Now()}); err != nil {
+		log.Error.Printf("error recording the run of scheduled job '%s': %v", job.Name, err)
+	}
+	log.Status.Printf("submitted job '%s' for scheduled job '%s'", warmPathJob.JobID, job.Name)
+	Metrics.ScheduledJobsSubmitted.Inc()
+}
@@ -0,0 +1,40 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleDayOfMonthStep(t *testing.T) {
+	c, err := ParseCronSchedule("0 0 */2 * *")
+	if err != nil {
+		t.Fatalf("error parsing cron expression: %v", err)
+	}
+	// as in cron, the step starts from the first day of the month
+	for day := 1; day <= 31; day++ {
+		expected := day%2 == 1
+		if matches := c.Matches(time.Date(2021, time.January, day, 0, 0, 0, 0, time.Local)); matches != expected {
+			t.Errorf("day %d: expected match %v, got %v", day, expected, matches)
+		}
+	}
+}
+
+func TestCronScheduleStepIsNotRestricted(t *testing.T) {
+	// a day of month starting with '*' is not restricted, so both day fields must match
+	c, err := ParseCronSchedule("0 0 */2 * 1")
+	if err != nil {
+		t.Fatalf("error parsing cron expression: %v", err)
+	}
+	// January 4th 2021 is an even Monday, and January 5th is an odd Tuesday
+	if c.Matches(time.Date(2021, time.January, 4, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("expected an even Monday not to match")
+	}
+	if c.Matches(time.Date(2021, time.January, 5, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("expected an odd Tuesday not to match")
+	}
+	if !c.Matches(time.Date(2021, time.January, 11, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("expected an odd Monday to match")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// ScheduledEvent is an event of the Scheduled Events endpoint of the instance metadata service
+type ScheduledEvent struct {
+	EventId      string   `json:"EventId"`
+	EventType    string   `json:"EventType"`
+	ResourceType string   `json:"ResourceType"`
+	Resources    []string `json:"Resources"`
+	EventStatus  string   `json:"EventStatus"`
+	NotBefore    string   `json:"NotBefore"`
+}
+
+// ScheduledEvents is the document returned by the Scheduled Events endpoint
+type ScheduledEvents struct {
+	DocumentIncarnation int              `json:"DocumentIncarnation"`
+	Events              []ScheduledEvent `json:"Events"`
+}
+
+// GetScheduledEvents returns the events scheduled for the VM
+func GetScheduledEvents(scheduledEventsUrl string) (*ScheduledEvents, error) {
+	client := &http.Client{Timeout: instanceMetadataTimeout}
+
+	req, err := http.NewRequest("GET", scheduledEventsUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Metadata", "true")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	resp_body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scheduled events returned status %d: %s", resp.StatusCode, string(resp_body))
+	}
+
+	var scheduledEvents ScheduledEvents
+	if err := json.Unmarshal(resp_body, &scheduledEvents); err != nil {
+		return nil, fmt.Errorf("error parsing scheduled events: %v", err)
+	}
+	return &scheduledEvents, nil
+}
+
+// isPreemptOf returns true if the event preempts the VM, an event without resources, or any event when the VM name
+// is unknown, is taken to preempt the VM
+func (e *ScheduledEvent) isPreemptOf(vmName string) bool {
+	if e.EventType != ScheduledEventPreempt {
+		return false
+	}
+	if len(vmName) == 0 || len(e.Resources) == 0 {
+		return true
+	}
+	for _, resource := range e.Resources {
+		if resource == vmName {
+			return true
+		}
+	}
+	return false
+}
+
+// EvictionWatcher polls the Scheduled Events endpoint, and calls the evict function once when the VM is preempted
+type EvictionWatcher struct {
+	scheduledEventsUrl string
+	evict              func()
+	vmName             string
+	vmNameChecked      bool
+	lastCheckFailed    bool
+}
+
+// InitializeEvictionWatcher initializes the eviction watcher of a worker
+func InitializeEvictionWatcher(scheduledEventsUrl string, evict func()) *EvictionWatcher {
+	return &EvictionWatcher{
+		scheduledEventsUrl: scheduledEventsUrl,
+		evict:              evict,
+	}
+}
+
+// checkForEviction returns true if a preempt event of the VM is scheduled
+func (e *EvictionWatcher) checkForEviction() (bool, error) {
+	scheduledEvents, err := GetScheduledEvents(e.scheduledEventsUrl)
+	if err != nil {
+		return false, err
+	}
+	// the events list the VM by name, an unknown name acts on every preempt event, such as those of a local stub
+	if !e.vmNameChecked {
+		e.vmNameChecked = true
+		if computeMetadata, err := GetComputeMetadata(); err == nil {
+			e.vmName = computeMetadata.Name
+		} else {
+			log.Warning.Printf("unable to get the VM name, acting on all preempt events: %v", err)
+		}
+	}
+	for _, event := range scheduledEvents.Events {
+		if event.isPreemptOf(e.vmName) {
+			log.Warning.Printf("preempt event '%s' scheduled for %v, not before '%s'", event.EventId, event.Resources, event.NotBefore)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RunEvictionWatcher polls the Scheduled Events endpoint until the VM is preempted, or the context is cancelled
+func (e *EvictionWatcher) RunEvictionWatcher(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	log.Debug.Printf("[EvictionWatcher.RunEvictionWatcher")
+	defer log.Debug.Printf("EvictionWatcher.RunEvictionWatcher]")
+	defer syncWaitGroup.Done()
+
+	ticker := time.NewTicker(timeBetweenScheduledEventsCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			evicted, err := e.checkForEviction()
+			if err != nil {
+				// only the first of consecutive failures is an error, the endpoint is polled every second
+				if !e.lastCheckFailed {
+					log.Error.Printf("error checking scheduled events at '%s': %v", e.scheduledEventsUrl, err)
+				} else {
+					log.Debug.Printf("error checking scheduled events at '%s': %v", e.scheduledEventsUrl, err)
+				}
+				e.lastCheckFailed = true
+				continue
+			}
+			e.lastCheckFailed = false
+			if evicted {
+				e.evict()
+				return
+			}
+		}
+	}
+}
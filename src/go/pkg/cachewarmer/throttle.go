@@ -0,0 +1,386 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// ThrottleLimit is a read rate limit, where zero is unlimited
+type ThrottleLimit struct {
+	MaxMBPerSecond    int64
+	MaxFilesPerSecond int64
+}
+
+// ThrottleScheduleEntry applies the limit between the start and end times of day, in the local time of the worker.
+// The times are formatted "HH:MM", and an end time before the start time wraps past midnight.
+type ThrottleScheduleEntry struct {
+	StartTime string
+	EndTime   string
+	ThrottleLimit
+}
+
+// ThrottleSettings is the limit applied outside of the scheduled windows, and the schedule of limits
+type ThrottleSettings struct {
+	ThrottleLimit
+	Schedule []ThrottleScheduleEntry
+}
+
+// InitializeThrottleSettings initializes the throttle settings, and returns an error if the schedule is invalid
+func InitializeThrottleSettings(maxMBPerSecond int64, maxFilesPerSecond int64, scheduleCsv string) (ThrottleSettings, error) {
+	if maxMBPerSecond < 0 || maxFilesPerSecond < 0 {
+		return ThrottleSettings{}, fmt.Errorf("the throttle limits must not be negative")
+	}
+	schedule, err := ParseThrottleSchedule(scheduleCsv)
+	if err != nil {
+		return ThrottleSettings{}, err
+	}
+	return ThrottleSettings{
+		ThrottleLimit: ThrottleLimit{
+			MaxMBPerSecond:    maxMBPerSecond,
+			MaxFilesPerSecond: maxFilesPerSecond,
+		},
+		Schedule: schedule,
+	}, nil
+}
+
+// ParseThrottleSchedule parses a comma separated schedule of the form "08:00-18:00=100/50", where the
+// limit is the MB per second followed by the files per second
+func ParseThrottleSchedule(scheduleCsv string) ([]ThrottleScheduleEntry, error) {
+	schedule := []ThrottleScheduleEntry{}
+	for _, entryStr := range prepareCsvList(scheduleCsv) {
+		parts := strings.Split(entryStr, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("throttle schedule entry '%s' is not of the form 'HH:MM-HH:MM=MB/files'", entryStr)
+		}
+		times := strings.Split(parts[0], "-")
+		limits := strings.Split(parts[1], "/")
+		if len(times) != 2 || len(limits) != 2 {
+			return nil, fmt.Errorf("throttle schedule entry '%s' is not of the form 'HH:MM-HH:MM=MB/files'", entryStr)
+		}
+		for _, t := range times {
+			if _, err := parseMinuteOfDay(t); err != nil {
+				return nil, fmt.Errorf("throttle schedule entry '%s': %v", entryStr, err)
+			}
+		}
+		maxMBPerSecond, err := strconv.ParseInt(strings.TrimSpace(limits[0]), 10, 64)
+		if err != nil || maxMBPerSecond < 0 {
+			return nil, fmt.Errorf("throttle schedule entry '%s' has an invalid MB per second", entryStr)
+		}
+		maxFilesPerSecond, err := strconv.ParseInt(strings.TrimSpace(limits[1]), 10, 64)
+		if err != nil || maxFilesPerSecond < 0 {
+			return nil, fmt.Errorf("throttle schedule entry '%s' has an invalid files per second", entryStr)
+		}
+		schedule = append(schedule, ThrottleScheduleEntry{
+			StartTime: strings.TrimSpace(times[0]),
+			EndTime:   strings.TrimSpace(times[1]),
+			ThrottleLimit: ThrottleLimit{
+				MaxMBPerSecond:    maxMBPerSecond,
+				MaxFilesPerSecond: maxFilesPerSecond,
+			},
+		})
+	}
+	return schedule, nil
+}
+
+// CurrentLimit returns the limit of the first schedule entry covering the time, or the default limit
+func (s *ThrottleSettings) CurrentLimit(now time.Time) ThrottleLimit {
+	minute := now.Hour()*60 + now.Minute()
+	for _, entry := range s.Schedule {
+		start, err := parseMinuteOfDay(entry.StartTime)
+		if err != nil {
+			continue
+		}
+		end, err := parseMinuteOfDay(entry.EndTime)
+		if err != nil {
+			continue
+		}
+		if (start <= end && minute >= start && minute < end) || (start > end && (minute >= start || minute < end)) {
+			return entry.ThrottleLimit
+		}
+	}
+	return s.ThrottleLimit
+}
+
+// Divide returns the share of the settings for each of the workers, a limited rate never drops to unlimited
+func (s ThrottleSettings) Divide(workerCount int64) ThrottleSettings {
+	if workerCount <= 1 {
+		return s
+	}
+	result := ThrottleSettings{
+		ThrottleLimit: s.ThrottleLimit.divide(workerCount),
+		Schedule:      make([]ThrottleScheduleEntry, 0, len(s.Schedule)),
+	}
+	for _, entry := range s.Schedule {
+		result.Schedule = append(result.Schedule, ThrottleScheduleEntry{
+			StartTime:     entry.StartTime,
+			EndTime:       entry.EndTime,
+			ThrottleLimit: entry.ThrottleLimit.divide(workerCount),
+		})
+	}
+	return result
+}
+
+// isUnlimited returns true if neither the limit nor any scheduled limit restricts the reads
+func (s *ThrottleSettings) isUnlimited() bool {
+	if s.MaxMBPerSecond > 0 || s.MaxFilesPerSecond > 0 {
+		return false
+	}
+	for _, entry := range s.Schedule {
+		if entry.MaxMBPerSecond > 0 || entry.MaxFilesPerSecond > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l ThrottleLimit) divide(workerCount int64) ThrottleLimit {
+	divideRate := func(rate int64) int64 {
+		if rate == 0 {
+			return 0
+		}
+		if share := rate / workerCount; share > 0 {
+			return share
+		}
+		return 1
+	}
+	return ThrottleLimit{
+		MaxMBPerSecond:    divideRate(l.MaxMBPerSecond),
+		MaxFilesPerSecond: divideRate(l.MaxFilesPerSecond),
+	}
+}
+
+func parseMinuteOfDay(timeOfDay string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(timeOfDay))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", timeOfDay)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// rateLimiter is a token bucket allowing one second of burst.  A request larger than the available
+// tokens is allowed to overdraw the bucket, and later requests wait until the debt is repaid.
+type rateLimiter struct {
+	mux        sync.Mutex
+	tokens     float64
+	lastUpdate time.Time
+}
+
+// wait blocks until count tokens are available at the rate per second, and returns false if cancelled
+func (l *rateLimiter) wait(ctx context.Context, rate float64, count int64) bool {
+	for {
+		l.mux.Lock()
+		if rate <= 0 {
+			l.mux.Unlock()
+			return true
+		}
+		now := time.Now()
+		if l.lastUpdate.IsZero() {
+			l.tokens = rate
+		} else {
+			l.tokens += now.Sub(l.lastUpdate).Seconds() * rate
+			if l.tokens > rate {
+				l.tokens = rate
+			}
+		}
+		l.lastUpdate = now
+		if l.tokens >= 0 {
+			l.tokens -= float64(count)
+			l.mux.Unlock()
+			return true
+		}
+		waitTime := time.Duration(-l.tokens / rate * float64(time.Second))
+		l.mux.Unlock()
+
+		timer := time.NewTimer(waitTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+type rateLimiterPair struct {
+	mux      sync.Mutex
+	settings ThrottleSettings
+	bytes    rateLimiter
+	files    rateLimiter
+}
+
+func (p *rateLimiterPair) currentLimit() ThrottleLimit {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.settings.CurrentLimit(time.Now())
+}
+
+func (p *rateLimiterPair) waitForFile(ctx context.Context) bool {
+	limit := p.currentLimit()
+	return p.files.wait(ctx, float64(limit.MaxFilesPerSecond), 1)
+}
+
+func (p *rateLimiterPair) waitForBytes(ctx context.Context, byteCount int64) bool {
+	limit := p.currentLimit()
+	return p.bytes.wait(ctx, float64(limit.MaxMBPerSecond*MB), byteCount)
+}
+
+// jobThrottle enforces the share of the job limit of this worker process, the share is recomputed from the count of
+// workers reading the job
+type jobThrottle struct {
+	rateLimiterPair
+	jobSettings  ThrottleSettings
+	mountAddress string
+	exportPath   string
+	lastUsed     time.Time
+	workerCount  int64
+}
+
+// Throttle enforces the worker process limit and the limit of each job across all worker goroutines of the process
+type Throttle struct {
+	mux    sync.Mutex
+	writer string
+	worker *rateLimiterPair
+	jobs   map[string]*jobThrottle
+}
+
+// InitializeThrottle initializes the throttle with the limits of the worker process, the writer is the progress
+// writer of the worker process
+func InitializeThrottle(settings ThrottleSettings, writer string) *Throttle {
+	return &Throttle{
+		writer: writer,
+		worker: &rateLimiterPair{settings: settings},
+		jobs:   make(map[string]*jobThrottle),
+	}
+}
+
+// RegisterJob sets the limits of the job across all workers, the limits of a job never change.  Each worker enforces
+// the share of the limits for the count of workers that recently wrote progress for the job.
+func (t *Throttle) RegisterJob(jobID string, mountAddresses []string, exportPath string, settings ThrottleSettings) {
+	if len(mountAddresses) == 0 {
+		return
+	}
+	t.mux.Lock()
+	if job, ok := t.jobs[jobID]; ok {
+		job.lastUsed = time.Now()
+		t.mux.Unlock()
+		return
+	}
+	job := &jobThrottle{
+		rateLimiterPair: rateLimiterPair{settings: settings},
+		jobSettings:     settings,
+		mountAddress:    mountAddresses[0],
+		exportPath:      exportPath,
+		lastUsed:        time.Now(),
+		workerCount:     1,
+	}
+	t.jobs[jobID] = job
+	t.mux.Unlock()
+	t.refreshJob(jobID, job)
+}
+
+func (t *Throttle) getJob(jobID string) *jobThrottle {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	job := t.jobs[jobID]
+	if job != nil {
+		job.lastUsed = time.Now()
+	}
+	return job
+}
+
+// WaitForFile blocks until a file of the job may be opened, and returns false if cancelled
+func (t *Throttle) WaitForFile(ctx context.Context, jobID string) bool {
+	if job := t.getJob(jobID); job != nil && !job.waitForFile(ctx) {
+		return false
+	}
+	return t.worker.waitForFile(ctx)
+}
+
+// WaitForBytes blocks until the bytes of the job may be read, and returns false if cancelled
+func (t *Throttle) WaitForBytes(ctx context.Context, jobID string, byteCount int64) bool {
+	if job := t.getJob(jobID); job != nil && !job.waitForBytes(ctx, byteCount) {
+		return false
+	}
+	return t.worker.waitForBytes(ctx, byteCount)
+}
+
+// Refresh recomputes the share of each job limit from the live count of workers reading the job, and forgets the
+// jobs without tracked worker jobs that were not read for the throttle idle timeout.  The progress of the jobs read
+// since the last refresh, or still tracked, is marked for writing, so the other workers count this worker.
+func (t *Throttle) Refresh(progress *ProgressTracker, workerJobs *WorkerJobTracker) {
+	t.mux.Lock()
+	jobs := make(map[string]*jobThrottle, len(t.jobs))
+	lastUsed := make(map[string]time.Time, len(t.jobs))
+	for jobID, job := range t.jobs {
+		jobs[jobID] = job
+		lastUsed[jobID] = job.lastUsed
+	}
+	t.mux.Unlock()
+
+	for jobID, job := range jobs {
+		isTracked := workerJobs.IsTrackingJob(jobID)
+		if !isTracked && time.Since(lastUsed[jobID]) > throttleJobIdleTimeout {
+			t.removeJob(jobID, job)
+			continue
+		}
+		if isTracked || time.Since(lastUsed[jobID]) < throttleRefreshInterval {
+			progress.markDirty(jobID)
+		}
+		t.refreshJob(jobID, job)
+	}
+}
+
+// removeJob forgets the throttle of the job, unless the job was registered or read again
+func (t *Throttle) removeJob(jobID string, job *jobThrottle) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.jobs[jobID] == job && time.Since(job.lastUsed) > throttleJobIdleTimeout {
+		delete(t.jobs, jobID)
+	}
+}
+
+// refreshJob divides the limit of the job by the count of workers reading the job
+func (t *Throttle) refreshJob(jobID string, job *jobThrottle) {
+	if job.jobSettings.isUnlimited() {
+		return
+	}
+	workerCount, err := countActiveWorkers(job.mountAddress, job.exportPath, jobID, t.writer)
+	job.mux.Lock()
+	defer job.mux.Unlock()
+	if err != nil {
+		log.Error.Printf("error counting the workers of job '%s', keeping the throttle share of %d workers: %v", jobID, job.workerCount, err)
+		return
+	}
+	if workerCount != job.workerCount {
+		log.Info.Printf("throttling job '%s' to its share of %d workers", jobID, workerCount)
+		job.workerCount = workerCount
+	}
+	job.settings = job.jobSettings.Divide(workerCount)
+}
+
+// RunThrottleRefresher periodically refreshes the job throttles until cancelled
+func (t *Throttle) RunThrottleRefresher(ctx context.Context, syncWaitGroup *sync.WaitGroup, progress *ProgressTracker, workerJobs *WorkerJobTracker) {
+	defer syncWaitGroup.Done()
+	log.Debug.Printf("[Throttle.RunThrottleRefresher")
+	defer log.Debug.Printf("Throttle.RunThrottleRefresher]")
+
+	ticker := time.NewTicker(throttleRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Refresh(progress, workerJobs)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// ValidateSymlinkPolicy returns an error if the symlink policy is unknown, the empty string skips the symlinks
+func ValidateSymlinkPolicy(symlinkPolicy string) error {
+	if symlinkPolicy != "" && symlinkPolicy != SymlinkPolicySkip && symlinkPolicy != SymlinkPolicyFollow {
+		return fmt.Errorf("symlink policy '%s' is neither '%s' nor '%s'", symlinkPolicy, SymlinkPolicySkip, SymlinkPolicyFollow)
+	}
+	return nil
+}
+
+// fileID identifies a file by its device and inode, so a file reached through several hard links or symlinks is
+// only read once
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// Traversal applies the symlink policy of a job to the directory entries it walks, and skips the files already
+// selected through another hard link or symlink.  A traversal is the whole walk of a job enumerated by the manager,
+// or a single directory of a distributed enumeration, whose files are also deduplicated across the workers by
+// claiming them in the claim path.
+type Traversal struct {
+	followSymlinks     bool
+	localExportPath    string
+	realExportPath     string
+	realWarmTargetPath string
+	claimPath          string
+	files              map[fileID]bool
+	fileSystems        map[uint64]string
+}
+
+// InitializeTraversal initializes the traversal of the warm target path of the export mounted at the local path, an
+// empty claim path only deduplicates the files within the traversal
+func InitializeTraversal(symlinkPolicy string, localExportPath string, warmTargetPath string, claimPath string) *Traversal {
+	return &Traversal{
+		followSymlinks:     symlinkPolicy == SymlinkPolicyFollow,
+		localExportPath:    localExportPath,
+		realExportPath:     getRealPath(localExportPath),
+		realWarmTargetPath: getRealPath(path.Join(localExportPath, warmTargetPath)),
+		claimPath:          claimPath,
+		files:              make(map[fileID]bool),
+		fileSystems:        make(map[uint64]string),
+	}
+}
+
+// resolveSymlink returns the target of a followed symlink, named as the symlink, or the reason the symlink is
+// skipped.  The target must be within the export, and must not be walked already: a file target below the warm
+// target path is read through its own directory entry, and a directory target is a loop if it is below the warm
+// target path, or is the directory of the symlink or one of its parents.
+func (t *Traversal) resolveSymlink(warmFolder string, dirEntry os.FileInfo) (os.FileInfo, string) {
+	if !t.followSymlinks {
+		return nil, SkipReasonSymlink
+	}
+	localPath := path.Join(t.localExportPath, warmFolder, dirEntry.Name())
+	realPath, err := filepath.EvalSymlinks(localPath)
+	if err != nil {
+		return nil, SkipReasonBrokenSymlink
+	}
+	if !isPathBelow(realPath, t.realExportPath) {
+		return nil, SkipReasonSymlinkOutsideExport
+	}
+	target, err := os.Stat(localPath)
+	if err != nil {
+		return nil, SkipReasonBrokenSymlink
+	}
+	if !target.IsDir() && isPathBelow(realPath, t.realWarmTargetPath) {
+		return nil, SkipReasonDuplicateFile
+	}
+	if target.IsDir() && (isPathBelow(realPath, t.realWarmTargetPath) || t.isParentDirectory(warmFolder, realPath)) {
+		return nil, SkipReasonSymlinkLoop
+	}
+	return target, ""
+}
+
+// isBelowSymlink returns true if the warm folder was reached through a followed directory symlink, so its files may
+// also be reached through another symlink
+func (t *Traversal) isBelowSymlink(warmFolder string) bool {
+	return t.followSymlinks && !isPathBelow(getRealPath(path.Join(t.localExportPath, warmFolder)), t.realWarmTargetPath)
+}
+
+// isParentDirectory returns true if the real path is the warm folder or one of its parents up to the export
+func (t *Traversal) isParentDirectory(warmFolder string, realPath string) bool {
+	for folder := path.Clean(warmFolder); ; folder = path.Dir(folder) {
+		if getRealPath(path.Join(t.localExportPath, folder)) == realPath {
+			return true
+		}
+		if folder == "/" || folder == "." {
+			return false
+		}
+	}
+}
+
+// isDuplicate returns true if the file at the path relative to the export was already selected through another hard
+// link or symlink.  Only the files with several hard links, or reached through a symlink, are recorded, since any
+// other file has a single path.
+func (t *Traversal) isDuplicate(filePath string, fileInfo os.FileInfo, viaSymlink bool) bool {
+	id, linkCount, ok := getFileID(fileInfo)
+	if !ok || (linkCount < 2 && !viaSymlink) {
+		return false
+	}
+	if t.files[id] {
+		return true
+	}
+	t.files[id] = true
+	return !t.claim(filePath, id)
+}
+
+// claim records the file in the claim path, and returns false if another path of the file claimed it first.  The
+// claim is named by the device and inode of the file, and is a symlink to the claiming path, so a retried
+// enumeration of the directory keeps its claims.  Claiming stops if the export does not support symlinks, and the
+// files are then only deduplicated within the traversal.
+func (t *Traversal) claim(filePath string, id fileID) bool {
+	if len(t.claimPath) == 0 {
+		return true
+	}
+	claimFilename := path.Join(t.claimPath, fmt.Sprintf("%s-%d", t.getFileSystem(filePath, id), id.inode))
+	err := os.Symlink(filePath, claimFilename)
+	if os.IsNotExist(err) {
+		// the claim directory is created by the first claim of the job
+		if err = os.MkdirAll(t.claimPath, os.ModePerm); err == nil {
+			err = os.Symlink(filePath, claimFilename)
+		}
+	}
+	if err == nil {
+		return true
+	}
+	if !os.IsExist(err) {
+		log.Error.Printf("error claiming '%s', deduplicating within the directory: %v", filePath, err)
+		t.claimPath = ""
+		return true
+	}
+	// a claim that cannot be read is treated as this path's, since reading a file twice is better than never
+	claimingPath, err := os.Readlink(claimFilename)
+	return err != nil || claimingPath == filePath
+}
+
+// getFileSystem returns the name of the file system of the file, in place of its device, since the device number of
+// a file system differs between the mounts of the export on the workers.  The file systems of an export, such as the
+// junctions of a cache, are named by a hash of the path of their root below the export, the topmost directory of the
+// file with the device of the file.
+func (t *Traversal) getFileSystem(filePath string, id fileID) string {
+	if name, ok := t.fileSystems[id.device]; ok {
+		return name
+	}
+	root := t.realExportPath
+	for dir := path.Dir(getRealPath(path.Join(t.localExportPath, filePath))); isPathBelow(dir, t.realExportPath); dir = path.Dir(dir) {
+		dirInfo, err := os.Stat(dir)
+		if err != nil {
+			break
+		}
+		if dirID, _, ok := getFileID(dirInfo); !ok || dirID.device != id.device {
+			break
+		}
+		root = dir
+		if dir == t.realExportPath {
+			break
+		}
+	}
+	name := shortHash(strings.TrimPrefix(root, t.realExportPath))
+	t.fileSystems[id.device] = name
+	return name
+}
+
+// getRealPath returns the path with its symlinks resolved, or the cleaned path if it cannot be resolved
+func getRealPath(localPath string) string {
+	realPath, err := filepath.EvalSymlinks(localPath)
+	if err != nil {
+		return path.Clean(localPath)
+	}
+	return realPath
+}
+
+// isPathBelow returns true if the path is the parent path or below it
+func isPathBelow(p string, parent string) bool {
+	return p == parent || strings.HasPrefix(p, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// isSymlink returns true if the directory entry is a symlink
+func isSymlink(dirEntry os.FileInfo) bool {
+	return dirEntry.Mode()&os.ModeSymlink != 0
+}
@@ -0,0 +1,16 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+
+//go:build !linux
+// +build !linux
+
+package cachewarmer
+
+import (
+	"os"
+)
+
+// getFileID is only supported on linux, elsewhere the hard links are not deduplicated
+func getFileID(fileInfo os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
@@ -26,6 +26,7 @@ type CacheWarmerCloudInit struct {
 	BootstrapAddress    string
 	BootstrapExportPath string
 	BootstrapScriptPath string
+	MountOptions        string
 	EnvVars             string
 }
 
@@ -37,26 +38,47 @@ func InitializeCloutInit(
 	bootstrapExportPath string,
 	bootstrapScriptPath string,
 	storageAccount string,
-	storageKey string,
-	queueNamePrefix string) *CacheWarmerCloudInit {
+	queueAuth string,
+	queueNamePrefix string,
+	queueType string,
+	queueMountAddress string,
+	queueExportPath string,
+	queueDirectoryPath string,
+	metricsAddress string,
+	mountOptions string) *CacheWarmerCloudInit {
 
 	localMountPath := "/b" // this is a temporary mount on the filesystem
 	envVars := fmt.Sprintf(
-		" %s %s %s BOOTSTRAP_PATH='%s' BOOTSTRAP_SCRIPT='%s' STORAGE_ACCOUNT='%s' STORAGE_KEY='%s' QUEUE_PREFIX='%s' ",
+		" %s %s %s BOOTSTRAP_PATH='%s' BOOTSTRAP_SCRIPT='%s' STORAGE_ACCOUNT='%s' QUEUE_AUTH='%s' QUEUE_SAS_MOUNT_ADDRESS='%s' QUEUE_SAS_EXPORT_PATH='%s' QUEUE_PREFIX='%s' QUEUE_TYPE='%s' QUEUE_MOUNT_ADDRESS='%s' QUEUE_EXPORT_PATH='%s' QUEUE_DIRECTORY_PATH='%s' METRICS_ADDRESS='%s' MOUNT_OPTIONS='%s' ",
 		httpProxyStr,
 		httpsProxyStr,
 		noProxyStr,
 		localMountPath,
 		bootstrapScriptPath,
 		storageAccount,
-		storageKey,
-		queueNamePrefix)
+		queueAuth,
+		bootstrapAddress,
+		bootstrapExportPath,
+		queueNamePrefix,
+		queueType,
+		queueMountAddress,
+		queueExportPath,
+		queueDirectoryPath,
+		metricsAddress,
+		mountOptions)
+
+	// the bootstrap export is always mounted with nfs
+	bootstrapMountOptions := mountOptions
+	if len(bootstrapMountOptions) == 0 {
+		bootstrapMountOptions = DefaultNfsMountOptions
+	}
 
 	return &CacheWarmerCloudInit{
 		LocalMountPath:      localMountPath,
 		BootstrapAddress:    bootstrapAddress,
 		BootstrapExportPath: bootstrapExportPath,
 		BootstrapScriptPath: bootstrapScriptPath,
+		MountOptions:        bootstrapMountOptions,
 		EnvVars:             envVars,
 	}
 }
@@ -77,7 +99,7 @@ func (c *CacheWarmerCloudInit) getCacheWarmerRawCloudInitTemplateString() string
 	return `#cloud-config
 #
 runcmd:
- - bash -c "set -x && ((apt-get update && apt-get install -y nfs-common) || (sleep 10 && apt-get update && apt-get install -y nfs-common) || (sleep 10 && apt-get update && apt-get install -y nfs-common)) && mkdir -p {{.LocalMountPath}} && r=5 && for i in $(seq 1 $r); do mount -o 'hard,nointr,proto=tcp,mountproto=tcp,retry=30' {{.BootstrapAddress}}:{{.BootstrapExportPath}} {{.LocalMountPath}} && break || [ $i == $r ] && break 0 || sleep 1; done && while [ ! -f {{.LocalMountPath}}{{.BootstrapScriptPath}} ]; do sleep 10; done && {{.EnvVars}} /bin/bash {{.LocalMountPath}}{{.BootstrapScriptPath}} 2>&1 | tee -a /var/log/bootstrap.log && umount {{.LocalMountPath}} && rmdir {{.LocalMountPath}}"`
+ - bash -c "set -x && ((apt-get update && apt-get install -y nfs-common cifs-utils) || (sleep 10 && apt-get update && apt-get install -y nfs-common cifs-utils) || (sleep 10 && apt-get update && apt-get install -y nfs-common cifs-utils)) && mkdir -p {{.LocalMountPath}} && r=5 && for i in $(seq 1 $r); do mount -o '{{.MountOptions}}' {{.BootstrapAddress}}:{{.BootstrapExportPath}} {{.LocalMountPath}} && break || [ $i == $r ] && break 0 || sleep 1; done && while [ ! -f {{.LocalMountPath}}{{.BootstrapScriptPath}} ]; do sleep 10; done && {{.EnvVars}} /bin/bash {{.LocalMountPath}}{{.BootstrapScriptPath}} 2>&1 | tee -a /var/log/bootstrap.log && umount {{.LocalMountPath}} && rmdir {{.LocalMountPath}}"`
 }
 
 type ComputeMetadata struct {
@@ -88,7 +110,7 @@ type ComputeMetadata struct {
 }
 
 func GetComputeMetadata() (*ComputeMetadata, error) {
-	client := &http.Client{}
+	client := &http.Client{Timeout: instanceMetadataTimeout}
 
 	req, err := http.NewRequest("GET", "http://169.254.169.254/metadata/instance/compute", nil)
 	if err != nil {
@@ -139,6 +161,7 @@ func SwapResourceName(id string, resourceName string) string {
 type AzureClients struct {
 	VMClient      compute.VirtualMachinesClient
 	VMSSClient    compute.VirtualMachineScaleSetsClient
+	VMSizesClient compute.VirtualMachineSizesClient
 	NICClient     network.InterfacesClient
 	LocalMetadata ComputeMetadata
 }
@@ -158,12 +181,15 @@ func InitializeAzureClients() (*AzureClients, error) {
 	vmClient.Authorizer = authorizer
 	vmssClient := compute.NewVirtualMachineScaleSetsClient(computeMetadata.SubscriptionId)
 	vmssClient.Authorizer = authorizer
+	vmSizesClient := compute.NewVirtualMachineSizesClient(computeMetadata.SubscriptionId)
+	vmSizesClient.Authorizer = authorizer
 	nicClient := network.NewInterfacesClient(computeMetadata.SubscriptionId)
 	nicClient.Authorizer = authorizer
 
 	return &AzureClients{
 		VMClient:      vmClient,
 		VMSSClient:    vmssClient,
+		VMSizesClient: vmSizesClient,
 		NICClient:     nicClient,
 		LocalMetadata: *computeMetadata,
 	}, nil
@@ -209,19 +235,11 @@ func GetSubnetId(ctx context.Context, azureClients *AzureClients) (string, error
 func createCacheWarmerVmssModel(
 	vmssName string,
 	location string,
-	vmssSKU string,
 	nodeCount int64,
 	userName string,
 	password string,
 	sshKeyData string,
-	publisher string,
-	offer string,
-	sku string,
-	planName string,
-	planPublisher string,
-	planProduct string,
-	priority compute.VirtualMachinePriorityTypes,
-	evictionPolicy compute.VirtualMachineEvictionPolicyTypes,
+	settings VmssSettings,
 	subnetId string,
 	customData string) compute.VirtualMachineScaleSet {
 	passwordPtr := to.StringPtr(password)
@@ -247,11 +265,62 @@ func createCacheWarmerVmssModel(
 
 	var computePlan *compute.Plan
 	computePlan = nil
-	if len(planName) > 0 && len(planPublisher) > 0 && len(planProduct) > 0 {
+	if len(settings.PlanName) > 0 && len(settings.PlanPublisher) > 0 && len(settings.PlanProduct) > 0 {
 		computePlan = &compute.Plan{
-			Name:      to.StringPtr(planName),
-			Publisher: to.StringPtr(planPublisher),
-			Product:   to.StringPtr(planProduct),
+			Name:      to.StringPtr(settings.PlanName),
+			Publisher: to.StringPtr(settings.PlanPublisher),
+			Product:   to.StringPtr(settings.PlanProduct),
+		}
+	}
+
+	imageReference := &compute.ImageReference{
+		ID: to.StringPtr(settings.ImageID),
+	}
+	if len(settings.ImageID) == 0 {
+		imageReference = &compute.ImageReference{
+			Offer:     to.StringPtr(settings.ImageOffer),
+			Publisher: to.StringPtr(settings.ImagePublisher),
+			Sku:       to.StringPtr(settings.ImageSku),
+			Version:   to.StringPtr(settings.ImageVersion),
+		}
+	}
+
+	// only set the os disk to override the size or type of the image defaults
+	var osDisk *compute.VirtualMachineScaleSetOSDisk
+	if settings.OsDiskSizeGB > 0 || len(settings.OsDiskType) > 0 {
+		osDisk = &compute.VirtualMachineScaleSetOSDisk{
+			CreateOption: compute.DiskCreateOptionTypesFromImage,
+		}
+		if settings.OsDiskSizeGB > 0 {
+			osDisk.DiskSizeGB = to.Int32Ptr(settings.OsDiskSizeGB)
+		}
+		if len(settings.OsDiskType) > 0 {
+			osDisk.ManagedDisk = &compute.VirtualMachineScaleSetManagedDiskParameters{
+				StorageAccountType: settings.OsDiskType,
+			}
+		}
+	}
+
+	var billingProfile *compute.BillingProfile
+	if settings.Priority == compute.Spot {
+		billingProfile = &compute.BillingProfile{
+			MaxPrice: to.Float64Ptr(settings.MaxPrice),
+		}
+	}
+
+	var zones *[]string
+	if len(settings.Zones) > 0 {
+		zones = &settings.Zones
+	}
+
+	// the workers authorize to the queues with the user assigned identity instead of a storage key
+	var identity *compute.VirtualMachineScaleSetIdentity
+	if len(settings.UserAssignedIdentity) > 0 {
+		identity = &compute.VirtualMachineScaleSetIdentity{
+			Type: compute.ResourceIdentityTypeUserAssigned,
+			UserAssignedIdentities: map[string]*compute.VirtualMachineScaleSetIdentityUserAssignedIdentitiesValue{
+				settings.UserAssignedIdentity: {},
+			},
 		}
 	}
 
@@ -260,10 +329,12 @@ func createCacheWarmerVmssModel(
 		Name:     to.StringPtr(vmssName),
 		Location: to.StringPtr(location),
 		Sku: &compute.Sku{
-			Name:     to.StringPtr(vmssSKU),
+			Name:     to.StringPtr(settings.SKU),
 			Capacity: to.Int64Ptr(nodeCount),
 		},
-		Plan: computePlan,
+		Plan:     computePlan,
+		Zones:    zones,
+		Identity: identity,
 		VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
 			Overprovision: to.BoolPtr(false),
 			UpgradePolicy: &compute.UpgradePolicy{
@@ -271,8 +342,9 @@ func createCacheWarmerVmssModel(
 			},
 			SinglePlacementGroup: to.BoolPtr(false),
 			VirtualMachineProfile: &compute.VirtualMachineScaleSetVMProfile{
-				Priority:       priority,
-				EvictionPolicy: evictionPolicy,
+				Priority:       settings.Priority,
+				EvictionPolicy: settings.EvictionPolicy,
+				BillingProfile: billingProfile,
 				OsProfile: &compute.VirtualMachineScaleSetOSProfile{
 					ComputerNamePrefix: to.StringPtr(vmssName),
 					AdminUsername:      to.StringPtr(userName),
@@ -281,20 +353,16 @@ func createCacheWarmerVmssModel(
 					LinuxConfiguration: linuxConfiguration,
 				},
 				StorageProfile: &compute.VirtualMachineScaleSetStorageProfile{
-					ImageReference: &compute.ImageReference{
-						Offer:     to.StringPtr(offer),
-						Publisher: to.StringPtr(publisher),
-						Sku:       to.StringPtr(sku),
-						Version:   to.StringPtr("latest"),
-					},
+					ImageReference: imageReference,
+					OsDisk:         osDisk,
 				},
 				NetworkProfile: &compute.VirtualMachineScaleSetNetworkProfile{
 					NetworkInterfaceConfigurations: &[]compute.VirtualMachineScaleSetNetworkConfiguration{
 						{
 							Name: to.StringPtr(vmssName),
 							VirtualMachineScaleSetNetworkConfigurationProperties: &compute.VirtualMachineScaleSetNetworkConfigurationProperties{
-								Primary: to.BoolPtr(true),
-								//EnableAcceleratedNetworking: to.BoolPtr(true),
+								Primary:                     to.BoolPtr(true),
+								EnableAcceleratedNetworking: to.BoolPtr(settings.AcceleratedNetworking),
 								//EnableIPForwarding:          to.BoolPtr(false),
 								IPConfigurations: &[]compute.VirtualMachineScaleSetIPConfiguration{
 									{
@@ -364,3 +432,50 @@ func DeleteVmss(ctx context.Context, azureClients *AzureClients, name string) er
 	log.Info.Printf("DeleteVmss(%s, %s) took %.2f seconds", azureClients.LocalMetadata.ResourceGroup, name, time.Now().Sub(start).Seconds())
 	return nil
 }
+
+// GetVmssCapacity returns the capacity of the VMSS, and false if the VMSS does not exist
+func GetVmssCapacity(ctx context.Context, azureClients *AzureClients, name string) (int64, bool, error) {
+	vmss, err := azureClients.VMSSClient.Get(ctx, azureClients.LocalMetadata.ResourceGroup, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "Code=\"ResourceNotFound\"") || strings.Contains(err.Error(), "Code=\"NotFound\"") {
+			return 0, false, nil
+		} else {
+			return 0, false, err
+		}
+	}
+	if vmss.Sku == nil || vmss.Sku.Capacity == nil {
+		return 0, true, nil
+	}
+	return *vmss.Sku.Capacity, true, nil
+}
+
+func ScaleVmss(ctx context.Context, azureClients *AzureClients, name string, capacity int64) error {
+	log.Info.Printf("[ScaleVmss %s %s %d", azureClients.LocalMetadata.ResourceGroup, name, capacity)
+	start := time.Now()
+	defer log.Info.Printf(" %s %s %d ScaleVmss]", azureClients.LocalMetadata.ResourceGroup, name, capacity)
+
+	vmss, err := azureClients.VMSSClient.Get(ctx, azureClients.LocalMetadata.ResourceGroup, name)
+	if err != nil {
+		return fmt.Errorf("cannot get vmss (%s %s): %v", azureClients.LocalMetadata.ResourceGroup, name, err)
+	}
+	if vmss.Sku == nil {
+		return fmt.Errorf("vmss (%s %s) has no sku", azureClients.LocalMetadata.ResourceGroup, name)
+	}
+	sku := *vmss.Sku
+	sku.Capacity = to.Int64Ptr(capacity)
+
+	future, err := azureClients.VMSSClient.Update(ctx, azureClients.LocalMetadata.ResourceGroup, name, compute.VirtualMachineScaleSetUpdate{
+		Sku: &sku,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot scale vmss (%s %s) to %d: %v", azureClients.LocalMetadata.ResourceGroup, name, capacity, err)
+	}
+
+	err = future.WaitForCompletionRef(ctx, azureClients.VMSSClient.Client)
+	if err != nil {
+		return fmt.Errorf("cannot get the vmss update future response(%s %s): %v", azureClients.LocalMetadata.ResourceGroup, name, err)
+	}
+
+	log.Info.Printf("ScaleVmss(%s, %s, %d) took %.2f seconds", azureClients.LocalMetadata.ResourceGroup, name, capacity, time.Now().Sub(start).Seconds())
+	return nil
+}
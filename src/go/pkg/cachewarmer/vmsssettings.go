@@ -0,0 +1,175 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+	"github.com/Azure/azure-sdk-for-go/profiles/2020-09-01/compute/mgmt/compute"
+)
+
+const (
+	VmssPriorityRegular = "regular"
+	VmssPrioritySpot    = "spot"
+
+	// a max price of -1 evicts spot workers only for capacity, and never for price
+	VmssDefaultMaxPrice = -1
+)
+
+// VmssSettings are the SKU, image, priority, network and disk settings of the worker VMSS.  The image is either a
+// custom image ID, or a marketplace image with an optional plan, and the zero value of the disk settings uses the
+// defaults of the image.
+type VmssSettings struct {
+	SKU                   string
+	ImageID               string
+	ImagePublisher        string
+	ImageOffer            string
+	ImageSku              string
+	ImageVersion          string
+	PlanName              string
+	PlanPublisher         string
+	PlanProduct           string
+	Priority              compute.VirtualMachinePriorityTypes
+	EvictionPolicy        compute.VirtualMachineEvictionPolicyTypes
+	MaxPrice              float64
+	AcceleratedNetworking bool
+	Zones                 []string
+	OsDiskSizeGB          int32
+	OsDiskType            compute.StorageAccountTypes
+	UserAssignedIdentity  string
+}
+
+// InitializeVmssSettings initializes the VMSS settings, and returns an error if a setting is invalid.  The marketplace
+// image is 'publisher:offer:sku[:version]', and the plan is 'name:publisher:product'.  If neither the image ID nor the
+// marketplace image is specified, the default marketplace image and plan are used.  The user assigned identity is the
+// resource ID of the identity the workers use to authorize to the queues.
+func InitializeVmssSettings(
+	sku string,
+	imageID string,
+	marketplaceImage string,
+	plan string,
+	priority string,
+	maxPrice float64,
+	acceleratedNetworking bool,
+	zonesCsv string,
+	osDiskSizeGB int64,
+	osDiskType string,
+	userAssignedIdentity string) (VmssSettings, error) {
+
+	settings := VmssSettings{
+		SKU:                   sku,
+		ImageID:               imageID,
+		MaxPrice:              maxPrice,
+		AcceleratedNetworking: acceleratedNetworking,
+		Zones:                 prepareCsvList(zonesCsv),
+		OsDiskSizeGB:          int32(osDiskSizeGB),
+		OsDiskType:            compute.StorageAccountTypes(osDiskType),
+		UserAssignedIdentity:  userAssignedIdentity,
+	}
+
+	if len(sku) == 0 {
+		return settings, fmt.Errorf("the VMSS SKU is not specified")
+	}
+
+	switch {
+	case len(imageID) > 0 && len(marketplaceImage) > 0:
+		return settings, fmt.Errorf("only one of the image ID and the marketplace image may be specified")
+	case len(imageID) > 0:
+		if !strings.HasPrefix(strings.ToLower(imageID), "/subscriptions/") {
+			return settings, fmt.Errorf("image ID '%s' is not a resource ID", imageID)
+		}
+	case len(marketplaceImage) > 0:
+		image := strings.Split(marketplaceImage, ":")
+		if len(image) < 3 || len(image) > 4 {
+			return settings, fmt.Errorf("marketplace image '%s' is not of the form 'publisher:offer:sku[:version]'", marketplaceImage)
+		}
+		settings.ImagePublisher, settings.ImageOffer, settings.ImageSku = image[0], image[1], image[2]
+		settings.ImageVersion = "latest"
+		if len(image) == 4 {
+			settings.ImageVersion = image[3]
+		}
+	default:
+		if len(plan) > 0 {
+			return settings, fmt.Errorf("a plan requires the image ID or the marketplace image")
+		}
+		settings.ImagePublisher, settings.ImageOffer, settings.ImageSku, settings.ImageVersion = MarketPlacePublisher, MarketPlaceOffer, MarketPlaceSku, "latest"
+		settings.PlanName, settings.PlanPublisher, settings.PlanProduct = PlanName, PlanPublisherName, PlanProductName
+	}
+
+	if len(plan) > 0 {
+		planParts := strings.Split(plan, ":")
+		if len(planParts) != 3 {
+			return settings, fmt.Errorf("plan '%s' is not of the form 'name:publisher:product'", plan)
+		}
+		settings.PlanName, settings.PlanPublisher, settings.PlanProduct = planParts[0], planParts[1], planParts[2]
+		// the plan of a marketplace image is always of the same publisher and offer
+		if len(settings.ImagePublisher) > 0 && (!strings.EqualFold(settings.PlanPublisher, settings.ImagePublisher) || !strings.EqualFold(settings.PlanProduct, settings.ImageOffer)) {
+			return settings, fmt.Errorf("plan '%s' does not match the publisher and offer of marketplace image '%s'", plan, marketplaceImage)
+		}
+	}
+
+	switch priority {
+	case VmssPrioritySpot:
+		settings.Priority = compute.Spot
+		settings.EvictionPolicy = compute.Delete
+		if maxPrice != VmssDefaultMaxPrice && maxPrice <= 0 {
+			return settings, fmt.Errorf("max price %g must be -1 or greater than 0", maxPrice)
+		}
+	case VmssPriorityRegular:
+		settings.Priority = compute.Regular
+		if maxPrice != VmssDefaultMaxPrice {
+			return settings, fmt.Errorf("a max price is only valid for spot priority")
+		}
+	default:
+		return settings, fmt.Errorf("priority '%s' is neither '%s' nor '%s'", priority, VmssPrioritySpot, VmssPriorityRegular)
+	}
+
+	for _, zone := range settings.Zones {
+		if zone != "1" && zone != "2" && zone != "3" {
+			return settings, fmt.Errorf("zone '%s' is not one of 1, 2, or 3", zone)
+		}
+	}
+
+	if osDiskSizeGB < 0 || osDiskSizeGB > 4095 {
+		return settings, fmt.Errorf("os disk size %d GB is not between 0 and 4095", osDiskSizeGB)
+	}
+
+	if len(osDiskType) > 0 {
+		isValidType := false
+		for _, t := range []compute.StorageAccountTypes{compute.StorageAccountTypesStandardLRS, compute.StorageAccountTypesStandardSSDLRS, compute.StorageAccountTypesPremiumLRS} {
+			if settings.OsDiskType == t {
+				isValidType = true
+			}
+		}
+		if !isValidType {
+			return settings, fmt.Errorf("os disk type '%s' is not one of %s, %s, or %s", osDiskType, compute.StorageAccountTypesStandardLRS, compute.StorageAccountTypesStandardSSDLRS, compute.StorageAccountTypesPremiumLRS)
+		}
+	}
+
+	if len(userAssignedIdentity) > 0 && !strings.HasPrefix(strings.ToLower(userAssignedIdentity), "/subscriptions/") {
+		return settings, fmt.Errorf("user assigned identity '%s' is not a resource ID", userAssignedIdentity)
+	}
+
+	return settings, nil
+}
+
+// ValidateVmssSettings verifies the SKU is available in the location of the manager
+func ValidateVmssSettings(ctx context.Context, azureClients *AzureClients, settings VmssSettings) error {
+	location := azureClients.LocalMetadata.Location
+	sizes, err := azureClients.VMSizesClient.List(ctx, location)
+	if err != nil {
+		return fmt.Errorf("error listing the VM sizes of location '%s': %v", location, err)
+	}
+	if sizes.Value != nil {
+		for _, size := range *sizes.Value {
+			if size.Name != nil && strings.EqualFold(*size.Name, settings.SKU) {
+				log.Info.Printf("VMSS SKU '%s' is available in location '%s'", settings.SKU, location)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("VMSS SKU '%s' is not available in location '%s'", settings.SKU, location)
+}
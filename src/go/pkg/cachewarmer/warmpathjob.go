@@ -4,25 +4,43 @@ package cachewarmer
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
+	"time"
 
-	"github.com/Azure/azure-storage-queue-go/azqueue"
+	"github.com/google/uuid"
 )
 
+// JobSettings are the options of a job, copied from the WarmPathJob to each of its WorkerJobs, and embedded in both.
+// The manifest options are only used by the manager.
+type JobSettings struct {
+	Priority              string
+	FullWarm              bool
+	ManifestBlobContainer string
+	MetadataOnly          bool
+	MetadataReadBytes     int64
+	Throttle              ThrottleSettings
+	Checksum              ChecksumSettings
+	Mount                 MountSettings
+	Deadline              time.Time
+	SymlinkPolicy         string
+	FileFilter
+}
+
 // WarmPathJob contains the information for a new job item
 type WarmPathJob struct {
+	JobID                    string
 	WarmTargetMountAddresses []string
 	WarmTargetExportPath     string
 	WarmTargetPath           string
-	InclusionList            []string
-	ExclusionList            []string
-	MaxFileSizeBytes         int64
-	queueMessageID           azqueue.MessageID
-	queuePopReceipt          azqueue.PopReceipt
-}
-
-func (j *WarmPathJob) FileMatches(filename string, filesize int64) bool {
-	return FileMatches(j.InclusionList, j.ExclusionList, j.MaxFileSizeBytes, filename, filesize)
+	FileListPath             string
+	FileListBlob             string
+	DistributedEnumeration   bool
+	ReportBlobContainer      string
+	JobSettings
+	ReportPending   bool
+	queueMessageID  string
+	queuePopReceipt string
 }
 
 // InitializeWarmPathJob initializes the job submitter structure
@@ -30,17 +48,25 @@ func InitializeWarmPathJob(
 	warmTargetMountAddresses string,
 	warmTargetExportPath string,
 	warmTargetPath string,
-	inclusionCsv string,
-	exclusionCsv string,
-	maxFileSizeBytes int64) *WarmPathJob {
+	fileListPath string,
+	fileListBlob string,
+	distributedEnumeration bool,
+	reportBlobContainer string,
+	settings JobSettings) *WarmPathJob {
+
+	// the path patterns of the filter are relative to the warm target path
+	settings.FilterRootPath = warmTargetPath
 
 	return &WarmPathJob{
+		JobID:                    uuid.New().String(),
 		WarmTargetMountAddresses: prepareCsvList(warmTargetMountAddresses),
 		WarmTargetExportPath:     warmTargetExportPath,
 		WarmTargetPath:           warmTargetPath,
-		InclusionList:            prepareCsvList(inclusionCsv),
-		ExclusionList:            prepareCsvList(exclusionCsv),
-		MaxFileSizeBytes:         maxFileSizeBytes,
+		FileListPath:             fileListPath,
+		FileListBlob:             fileListBlob,
+		DistributedEnumeration:   distributedEnumeration,
+		ReportBlobContainer:      reportBlobContainer,
+		JobSettings:              settings,
 	}
 }
 
@@ -63,12 +89,41 @@ func (j *WarmPathJob) GetWarmPathJobFileContents() (string, error) {
 	return string(data), nil
 }
 
-func (j *WarmPathJob) SetQueueMessageInfo(id azqueue.MessageID, popReceipt azqueue.PopReceipt) {
+// IsExpired returns true once the deadline of the job has passed, a job without a deadline never expires
+func (j *WarmPathJob) IsExpired() bool {
+	return isDeadlineExpired(j.Deadline)
+}
+
+// UsesManifest returns true if the job is limited to the files changed since the previous job, and updates the
+// manifest of the warm target path.  Only the jobs enumerated by the manager use the manifest, since the file list
+// and distributed enumeration jobs are not walked by a single process, and metadata only jobs do not read the data.
+func (j *WarmPathJob) UsesManifest() bool {
+	return !j.MetadataOnly && !j.HasFileList() && !j.DistributedEnumeration
+}
+
+// ValidateManifest returns an error if the job would silently ignore the incremental manifest, a file list or
+// distributed enumeration job must warm all files
+func (j *WarmPathJob) ValidateManifest() error {
+	if (j.HasFileList() || j.DistributedEnumeration) && !j.FullWarm && !j.MetadataOnly {
+		return fmt.Errorf("file list and distributed enumeration jobs do not use the incremental manifest, specify full to warm all files")
+	}
+	if len(j.ManifestBlobContainer) > 0 && !j.UsesManifest() {
+		return fmt.Errorf("manifestBlobContainer requires a job that uses the incremental manifest")
+	}
+	return nil
+}
+
+// HasFileList returns true if the job warms the files of a file list instead of walking the warm target path
+func (j *WarmPathJob) HasFileList() bool {
+	return len(j.FileListPath) > 0 || len(j.FileListBlob) > 0
+}
+
+func (j *WarmPathJob) SetQueueMessageInfo(id string, popReceipt string) {
 	j.queueMessageID = id
 	j.queuePopReceipt = popReceipt
 }
 
-func (j *WarmPathJob) GetQueueMessageInfo() (azqueue.MessageID, azqueue.PopReceipt) {
+func (j *WarmPathJob) GetQueueMessageInfo() (string, string) {
 	return j.queueMessageID, j.queuePopReceipt
 }
 
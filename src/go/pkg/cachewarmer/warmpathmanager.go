@@ -11,11 +11,11 @@ import (
 	"path"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/Avere/src/go/pkg/log"
 	"github.com/Azure/Avere/src/go/pkg/stats"
-	"github.com/Azure/azure-sdk-for-go/profiles/2020-09-01/compute/mgmt/compute"
 )
 
 // WarmPathManager contains the information for the manager
@@ -30,15 +30,28 @@ type WarmPathManager struct {
 	vmssPassword          string
 	vmssSshPublicKey      string
 	vmssSubnet            string
+	vmssSettings          VmssSettings
 	storageAccount        string
 	storageKey            string
+	workerQueueAuth       string
 	queueNamePrefix       string
+	queueType             string
+	queueMountAddress     string
+	queueExportPath       string
+	queueDirectoryPath    string
+	workerMetricsAddress  string
+	progress              *ProgressTracker
+	scaler                *WorkerScaler
+	// the worker jobs the workers reported done for each job waiting for its report, and the total since the manager started
+	jobWorkerJobsDone map[string]int64
+	workerJobsDone    int64
 }
 
 // InitializeWarmPathManager initializes the job submitter structure
 func InitializeWarmPathManager(
 	azureClients *AzureClients,
 	workerCount int64,
+	minWorkerCount int64,
 	queues *CacheWarmerQueues,
 	bootstrapMountAddress string,
 	bootstrapExportPath string,
@@ -47,9 +60,16 @@ func InitializeWarmPathManager(
 	vmssPassword string,
 	vmssSshPublicKey string,
 	vmssSubnet string,
+	vmssSettings VmssSettings,
 	storageAccount string,
 	storageKey string,
-	queueNamePrefix string) *WarmPathManager {
+	workerQueueAuth string,
+	queueNamePrefix string,
+	queueType string,
+	queueMountAddress string,
+	queueExportPath string,
+	queueDirectoryPath string,
+	workerMetricsAddress string) *WarmPathManager {
 	return &WarmPathManager{
 		AzureClients:          azureClients,
 		WorkerCount:           workerCount,
@@ -61,9 +81,19 @@ func InitializeWarmPathManager(
 		vmssPassword:          vmssPassword,
 		vmssSshPublicKey:      vmssSshPublicKey,
 		vmssSubnet:            vmssSubnet,
+		vmssSettings:          vmssSettings,
 		storageAccount:        storageAccount,
 		storageKey:            storageKey,
+		workerQueueAuth:       workerQueueAuth,
 		queueNamePrefix:       queueNamePrefix,
+		queueType:             queueType,
+		queueMountAddress:     queueMountAddress,
+		queueExportPath:       queueExportPath,
+		queueDirectoryPath:    queueDirectoryPath,
+		workerMetricsAddress:  workerMetricsAddress,
+		progress:              InitializeProgressTracker(ManagerProgressWriter),
+		scaler:                InitializeWorkerScaler(minWorkerCount, workerCount),
+		jobWorkerJobsDone:     make(map[string]int64),
 	}
 }
 
@@ -117,19 +147,86 @@ func (m *WarmPathManager) processJob(ctx context.Context, warmPathJob *WarmPathJ
 	defer log.Info.Printf("WarmPathManager.processJob '%s' '%s']", id, popReceipt)
 
 	if len(warmPathJob.WarmTargetMountAddresses) == 0 {
+		// without a mount address there is no export to record the progress of the job
 		if err := m.Queues.DeleteWarmPathJob(warmPathJob); err != nil {
 			log.Error.Printf("error removing job: %v", err)
 		}
 		return fmt.Errorf("there are no mount addresses specified in the job")
 	}
 
-	localMountPath := GetLocalMountPath(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath)
+	// the progress, cancellation, and report of the job are kept on the export mounted with the settings of the
+	// first job of the export, for example to mount a cifs share as cifs
+	if warmPathJob.Mount.Validate() == nil {
+		RegisterMountSettings(warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmPathJob.Mount)
+	}
+
+	if warmPathJob.ReportPending {
+		m.reportJob(ctx, warmPathJob)
+		return nil
+	}
+
+	if err := warmPathJob.Mount.Validate(); err != nil {
+		m.failJob(warmPathJob, fmt.Errorf("the mount settings of the job are not valid: %v", err))
+		return nil
+	}
+
+	// the workers read the files of the job on a mount with the same settings as the manager
+	if warmPathJob.Mount.IsEmpty() {
+		warmPathJob.Mount = GetDefaultMountSettings()
+	}
+	localMountPath := GetJobMountPath(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.Mount)
 	if err := MountPath(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, localMountPath); err != nil {
 		return fmt.Errorf("error trying to mount %s:%s: %v", warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, err)
 	}
 
-	log.Status.Printf("start processing %s", warmPathJob.WarmTargetPath)
-	defer log.Status.Printf("stop processing %s", warmPathJob.WarmTargetPath)
+	if m.stopCancelledJob(warmPathJob) {
+		return nil
+	}
+
+	log.Status.Printf("start processing %s (job '%s', priority '%s')", warmPathJob.WarmTargetPath, warmPathJob.JobID, warmPathJob.Priority)
+	defer log.Status.Printf("stop processing %s (job '%s')", warmPathJob.WarmTargetPath, warmPathJob.JobID)
+
+	// the enumeration starts over if the job was previously interrupted, and the worker jobs written before the
+	// interruption are still counted, since the workers complete them
+	m.progress.Register(warmPathJob.JobID, warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath)
+	m.progress.Update(warmPathJob.JobID, func(p *JobProgress) {
+		if p.StartTime.IsZero() {
+			p.StartTime = time.Now()
+		}
+		p.EnumerationComplete = false
+		p.Deadline = warmPathJob.Deadline
+	})
+	m.progress.Flush()
+
+	if warmPathJob.IsExpired() {
+		m.expireJob(warmPathJob, []string{warmPathJob.WarmTargetPath}, nil)
+		return nil
+	}
+
+	if warmPathJob.HasFileList() {
+		return m.processFileListJob(ctx, warmPathJob, localMountPath)
+	}
+
+	if warmPathJob.DistributedEnumeration {
+		return m.processDistributedJob(warmPathJob)
+	}
+
+	// the manifest of the previous jobs limits the warming to the new or changed files, and
+	// is not used for metadata only jobs, since those do not warm the file data
+	var manifest *Manifest
+	if warmPathJob.UsesManifest() {
+		var err error
+		manifest, err = LoadManifest(ctx, m.storageAccount, m.storageKey, warmPathJob)
+		if err != nil {
+			log.Error.Printf("error loading manifest for '%s', warming all files: %v", warmPathJob.WarmTargetPath, err)
+		}
+	}
+	unchangedFileCount := 0
+
+	jobSettings := InitializeWorkerJob(warmPathJob.JobID, warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmPathJob.WarmTargetPath, warmPathJob.JobSettings)
+
+	// the files of the whole job are deduplicated, since the manager walks every directory
+	traversal := InitializeTraversal(warmPathJob.SymlinkPolicy, localMountPath, warmPathJob.WarmTargetPath, "")
 
 	lastRefreshVisibility := time.Now()
 	folderSlice := []string{warmPathJob.WarmTargetPath}
@@ -139,9 +236,18 @@ func (m *WarmPathManager) processJob(ctx context.Context, warmPathJob *WarmPathJ
 			log.Info.Printf("cancelation occurred while processing job files")
 			return nil
 		}
+		if warmPathJob.IsExpired() {
+			m.expireJob(warmPathJob, folderSlice, nil)
+			return nil
+		}
 		if time.Since(lastRefreshVisibility) > refreshWorkInterval {
 			lastRefreshVisibility = time.Now()
+			if m.stopCancelledJob(warmPathJob) {
+				m.progress.Flush()
+				return nil
+			}
 			m.Queues.StillProcessingWarmPathJob(warmPathJob)
+			m.progress.Flush()
 		}
 
 		// dequeue the next folder
@@ -154,82 +260,424 @@ func (m *WarmPathManager) processJob(ctx context.Context, warmPathJob *WarmPathJ
 		dirEntries, err := ioutil.ReadDir(fullWarmPath)
 		if err != nil {
 			log.Error.Printf("error encountered reading directory '%s': %v", warmFolder, err)
+			m.progress.Update(warmPathJob.JobID, func(p *JobProgress) { p.RecordFailure(path.Join(warmPathJob.WarmTargetExportPath, warmFolder), err) })
 			continue
 		}
-
-		files, largeFiles, dirs := processDirEntries(dirEntries, warmPathJob)
+		m.progress.Update(warmPathJob.JobID, func(p *JobProgress) { p.DirectoriesListed++ })
+		Metrics.DirectoriesWalked.Inc()
+
+		files, largeFiles, dirs, linkCount := processDirEntries(warmPathJob.JobID, m.progress, warmFolder, dirEntries, &warmPathJob.FileFilter, warmPathJob.MetadataOnly || warmPathJob.Checksum.Enabled, traversal)
+		allFileCount := len(files)
+		allLargeFileCount := len(largeFiles)
+		if manifest != nil {
+			// a checksum job reads every file, so every file has a checksum
+			fullWarm := warmPathJob.FullWarm || warmPathJob.Checksum.Enabled
+			files = filterChangedFiles(manifest, warmFolder, files, fullWarm)
+			largeFiles = filterChangedFiles(manifest, warmFolder, largeFiles, fullWarm)
+			unchangedFileCount += allFileCount - len(files)
+			unchangedCount := int64(allFileCount - len(files) + allLargeFileCount - len(largeFiles))
+			m.progress.Update(warmPathJob.JobID, func(p *JobProgress) { p.RecordSkipped(SkipReasonUnchanged, unchangedCount) })
+		}
 
 		// queue the directories
 		for _, dir := range dirs {
-			folderSlice = append(folderSlice, path.Join(warmFolder, dir.Name()))
+			dirPath := path.Join(warmFolder, dir.Name())
+			if isJobProgressPath(dirPath) {
+				continue
+			}
+			folderSlice = append(folderSlice, dirPath)
 		}
 
-		// write a job for each large file
-		for _, largeFile := range largeFiles {
-			fullPath := path.Join(warmFolder, largeFile.Name())
-
-			fileSize := largeFile.Size()
-			for i := int64(0); i < fileSize; i += MaximumJobSize {
-				end := i + MaximumJobSize
-				if end > fileSize {
-					end = fileSize
-				}
-				log.Info.Printf("queuing worker job for file %s [%d,%d)", fullPath, i, end)
-				workerJob := InitializeWorkerJobForLargeFile(warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, fullPath, i, end, warmPathJob.InclusionList, warmPathJob.ExclusionList, warmPathJob.MaxFileSizeBytes)
-				if err := m.Queues.WriteWorkerJob(workerJob); err != nil {
-					log.Error.Printf("error encountered writing worker job '%s': %v", fullPath, err)
-				}
+		// a worker listing the directory would also find the symlinks and duplicate files, so those directories
+		// are given the list of files to read
+		for _, workerJob := range getDirectoryWorkerJobs(jobSettings, warmFolder, files, largeFiles, allFileCount+linkCount) {
+			if err := m.writeWorkerJob(workerJob); err != nil {
+				log.Error.Printf("error encountered writing worker job '%s': %v", workerJob.WarmTargetPath, err)
 			}
 		}
+	}
 
-		// write a job for each group of files
-		if len(files) > 0 {
-			if len(files) < MaximumFilesToRead {
-				log.Info.Printf("queuing job for path %s", warmFolder)
-				workerJob := InitializeWorkerJob(warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmFolder, warmPathJob.InclusionList, warmPathJob.ExclusionList, warmPathJob.MaxFileSizeBytes)
-				if err := m.Queues.WriteWorkerJob(workerJob); err != nil {
-					log.Error.Printf("error encountered writing worker job '%s': %v", warmFolder, err)
-				}
-			} else {
-				for i := 0; i < len(files); i += MaximumFilesToRead {
-					end := i + MaximumFilesToRead
-					if end >= len(files) {
-						end = len(files) - 1
-					}
-					log.Info.Printf("queuing job for path %s [%s,%s]", warmFolder, files[i].Name(), files[end].Name())
-					workerJob := InitializeWorkerJobWithFilter(warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmFolder, files[i].Name(), files[end].Name(), warmPathJob.InclusionList, warmPathJob.ExclusionList, warmPathJob.MaxFileSizeBytes)
-					if err := m.Queues.WriteWorkerJob(workerJob); err != nil {
-						log.Error.Printf("error encountered writing worker job %s [%s,%s]: %v", warmFolder, files[i].Name(), files[end].Name(), err)
+	m.progress.Update(warmPathJob.JobID, func(p *JobProgress) { p.EnumerationComplete = true })
+	m.progress.Flush()
+
+	if manifest != nil {
+		log.Status.Printf("skipped %d unchanged files of %s (job '%s')", unchangedFileCount, warmPathJob.WarmTargetPath, warmPathJob.JobID)
+		// the manifest is committed once the queued files are read
+		if err := manifest.SavePending(&warmPathJob.FileFilter); err != nil {
+			log.Error.Printf("error saving pending manifest for '%s': %v", warmPathJob.WarmTargetPath, err)
+		}
+	}
+
+	m.deferJobReport(warmPathJob)
+
+	return nil
+}
+
+// deferJobReport marks the job as waiting for its report, and hides the job message until the next report check.
+// The job message stays in the job queue until the report is written, so a restarted manager, or the manager that
+// takes over the lead, still writes the report.
+func (m *WarmPathManager) deferJobReport(warmPathJob *WarmPathJob) {
+	warmPathJob.ReportPending = true
+	if err := m.Queues.HideWarmPathJob(warmPathJob, timeBetweenReportCheck); err != nil {
+		log.Error.Printf("error deferring the report of job '%s': %v", warmPathJob.JobID, err)
+	}
+}
+
+// reportJob writes the report of a job waiting for its report once the job is complete or cancelled, and deletes
+// the job from the job queue, otherwise, or if the report cannot be written, the report is deferred to the next
+// report check
+func (m *WarmPathManager) reportJob(ctx context.Context, warmPathJob *WarmPathJob) {
+	jobID := warmPathJob.JobID
+	status, err := ReadJobStatus(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, jobID)
+	if err != nil {
+		log.Error.Printf("error reading status of job '%s': %v", jobID, err)
+		m.deferJobReport(warmPathJob)
+		return
+	}
+	m.observeWorkerJobsDone(jobID, status)
+	if !status.IsComplete() && !status.Cancelled {
+		m.deferJobReport(warmPathJob)
+		return
+	}
+	// the job is kept until its report is written, so the report is tried again
+	report := InitializeJobReport(warmPathJob.WarmTargetExportPath, warmPathJob.WarmTargetPath, status)
+	if err := WriteJobReport(warmPathJob.WarmTargetMountAddresses[0], report); err != nil {
+		log.Error.Printf("error writing report of job '%s': %v", jobID, err)
+		m.deferJobReport(warmPathJob)
+		return
+	}
+	log.Status.Printf("wrote report of job '%s' (%s)", jobID, report.State)
+	delete(m.jobWorkerJobsDone, jobID)
+	m.completeManifest(ctx, warmPathJob, status)
+	if len(warmPathJob.ReportBlobContainer) > 0 {
+		if err := UploadJobReport(ctx, m.storageAccount, m.storageKey, warmPathJob.ReportBlobContainer, report); err != nil {
+			log.Error.Printf("error uploading report of job '%s' to blob container '%s': %v", jobID, warmPathJob.ReportBlobContainer, err)
+		}
+	}
+	if err := m.Queues.DeleteWarmPathJob(warmPathJob); err != nil {
+		log.Error.Printf("error removing reported job '%s': %v", jobID, err)
+	}
+	m.progress.Remove(jobID)
+}
+
+// observeWorkerJobsDone adds the worker jobs the workers reported done for the job since its last status to the
+// total observed by the scaler.  The first status of a job is the baseline, so a restarted manager does not count
+// the worker jobs done before the restart.
+func (m *WarmPathManager) observeWorkerJobsDone(jobID string, status *JobStatus) {
+	workerJobsDone := status.WorkerJobsCompleted + status.WorkerJobsDeadLettered + status.WorkerJobsExpired + status.WorkerJobsCancelled
+	if lastWorkerJobsDone, ok := m.jobWorkerJobsDone[jobID]; ok && workerJobsDone > lastWorkerJobsDone {
+		atomic.AddInt64(&m.workerJobsDone, workerJobsDone-lastWorkerJobsDone)
+	}
+	m.jobWorkerJobsDone[jobID] = workerJobsDone
+}
+
+// completeManifest commits the pending manifest of a job that read all of its queued files, otherwise the pending
+// manifest is discarded, and the next job warms the files changed since the previous manifest
+func (m *WarmPathManager) completeManifest(ctx context.Context, warmPathJob *WarmPathJob, status *JobStatus) {
+	if status.IsComplete() && !status.Cancelled && !status.IsPartial() && !status.HasFailures() {
+		if err := CommitManifest(ctx, m.storageAccount, m.storageKey, warmPathJob); err != nil {
+			log.Error.Printf("error committing manifest of job '%s' for '%s': %v", warmPathJob.JobID, warmPathJob.WarmTargetPath, err)
+		}
+		return
+	}
+	if err := DiscardManifest(warmPathJob); err != nil {
+		log.Error.Printf("error discarding manifest of job '%s' for '%s': %v", warmPathJob.JobID, warmPathJob.WarmTargetPath, err)
+	}
+}
+
+// failJob records the error of a job that fails before any of its work is queued as the final progress of the job,
+// and defers the report of the job, so the status and the report show the failed job
+func (m *WarmPathManager) failJob(warmPathJob *WarmPathJob, jobErr error) {
+	log.Error.Printf("job '%s' for %s failed: %v", warmPathJob.JobID, warmPathJob.WarmTargetPath, jobErr)
+	m.finishJobProgress(warmPathJob, func(p *JobProgress) { p.JobError = jobErr.Error() })
+	m.deferJobReport(warmPathJob)
+}
+
+// finishJobProgress writes the final progress of a job stopped before its enumeration completes, so the job is not
+// shown as queued or enumerating
+func (m *WarmPathManager) finishJobProgress(warmPathJob *WarmPathJob, update func(p *JobProgress)) {
+	m.progress.Register(warmPathJob.JobID, warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath)
+	m.progress.Update(warmPathJob.JobID, func(p *JobProgress) {
+		if p.StartTime.IsZero() {
+			p.StartTime = time.Now()
+		}
+		p.EnumerationComplete = true
+		update(p)
+	})
+	m.progress.Flush()
+}
+
+// stopCancelledJob writes the final progress of the job, and defers its report, if it has been cancelled, and
+// returns true if cancelled
+func (m *WarmPathManager) stopCancelledJob(warmPathJob *WarmPathJob) bool {
+	cancelled, err := IsJobCancelled(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.JobID)
+	if err != nil {
+		log.Error.Printf("error checking if job '%s' is cancelled: %v", warmPathJob.JobID, err)
+		return false
+	}
+	if !cancelled {
+		return false
+	}
+	log.Status.Printf("job '%s' for %s is cancelled", warmPathJob.JobID, warmPathJob.WarmTargetPath)
+	m.finishJobProgress(warmPathJob, func(p *JobProgress) {})
+	m.deferJobReport(warmPathJob)
+	return true
+}
+
+// expireJob stops the job at its deadline, counting the directories not yet enumerated and the file list entries not
+// yet queued as expired, and defers the report of the job.  The entries are not counted as expired files, since
+// those are the queued files, and the job would otherwise complete before its queued files are done.  The paths are
+// relative to the export.
+func (m *WarmPathManager) expireJob(warmPathJob *WarmPathJob, expiredDirectories []string, expiredEntries []string) {
+	log.Status.Printf("the deadline %s of job '%s' for %s has passed, dropping %d directories and %d file list entries", warmPathJob.Deadline.Format(time.RFC3339), warmPathJob.JobID, warmPathJob.WarmTargetPath, len(expiredDirectories), len(expiredEntries))
+	m.progress.Update(warmPathJob.JobID, func(p *JobProgress) {
+		p.DirectoriesExpired += int64(len(expiredDirectories))
+		p.FileListEntriesExpired += int64(len(expiredEntries))
+		for _, expiredPath := range append(expiredDirectories, expiredEntries...) {
+			p.RecordExpired(path.Join(warmPathJob.WarmTargetExportPath, expiredPath))
+		}
+		p.EnumerationComplete = true
+	})
+	m.progress.Flush()
+	m.deferJobReport(warmPathJob)
+}
+
+// processFileListJob writes worker jobs for the entries of the file list of the job, without walking the warm target
+// path.  The incremental manifest is not used, since the listed files are always warmed.
+func (m *WarmPathManager) processFileListJob(ctx context.Context, warmPathJob *WarmPathJob, localMountPath string) error {
+	var entries []FileListEntry
+	var err error
+	if len(warmPathJob.FileListPath) > 0 {
+		entries, err = ReadFileListFromExport(warmPathJob.WarmTargetMountAddresses[0], warmPathJob.WarmTargetExportPath, warmPathJob.FileListPath)
+	} else {
+		entries, err = ReadFileListFromBlob(ctx, m.storageAccount, m.storageKey, warmPathJob.FileListBlob)
+	}
+	if isFileListError(err) {
+		// the job would otherwise be retried until the file list is fixed
+		m.failJob(warmPathJob, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading the file list of job '%s': %v", warmPathJob.JobID, err)
+	}
+	log.Status.Printf("queuing %d file list entries of %s (job '%s')", len(entries), warmPathJob.WarmTargetPath, warmPathJob.JobID)
+
+	// metadata only jobs never split the data reads of large files, and checksum jobs read each file whole
+	if !warmPathJob.MetadataOnly && !warmPathJob.Checksum.Enabled {
+		entries = splitFileListEntries(entries, path.Join(localMountPath, warmPathJob.WarmTargetPath))
+	}
+
+	lastRefreshVisibility := time.Now()
+	groups := groupFileListEntries(entries)
+	for i, group := range groups {
+		if isCancelled(ctx) {
+			log.Info.Printf("cancelation occurred while processing job files")
+			return nil
+		}
+		if warmPathJob.IsExpired() {
+			expiredEntries := make([]string, 0)
+			for _, expiredGroup := range groups[i:] {
+				for _, entry := range expiredGroup {
+					// the split ranges of a file are counted as one expired entry
+					expiredPath := path.Join(warmPathJob.WarmTargetPath, entry.Path)
+					if entry.Continued && len(expiredEntries) > 0 && expiredEntries[len(expiredEntries)-1] == expiredPath {
+						continue
 					}
+					expiredEntries = append(expiredEntries, expiredPath)
 				}
 			}
+			m.expireJob(warmPathJob, nil, expiredEntries)
+			return nil
+		}
+		if time.Since(lastRefreshVisibility) > refreshWorkInterval {
+			lastRefreshVisibility = time.Now()
+			if m.stopCancelledJob(warmPathJob) {
+				m.progress.Flush()
+				return nil
+			}
+			m.Queues.StillProcessingWarmPathJob(warmPathJob)
+			m.progress.Flush()
+		}
+
+		log.Info.Printf("queuing job for %d file list entries of path %s", len(group), warmPathJob.WarmTargetPath)
+		workerJob := InitializeWorkerJobWithFileListEntries(warmPathJob.JobID, warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmPathJob.WarmTargetPath, group, warmPathJob.JobSettings)
+		if err := m.writeWorkerJob(workerJob); err != nil {
+			log.Error.Printf("error encountered writing worker job for file list entries of '%s': %v", warmPathJob.WarmTargetPath, err)
 		}
 	}
 
-	// remove the job file
-	if err := m.Queues.DeleteWarmPathJob(warmPathJob); err != nil {
-		log.Error.Printf("error removing job '%s' '%s' at end of processing: %v", id, popReceipt, err)
+	m.progress.Update(warmPathJob.JobID, func(p *JobProgress) { p.EnumerationComplete = true })
+	m.progress.Flush()
+
+	m.deferJobReport(warmPathJob)
+
+	return nil
+}
+
+// processDistributedJob writes the enumeration worker job of the warm target path, and the workers enumerate the
+// tree in parallel.  The incremental manifest is not used, since no single process sees every file.
+func (m *WarmPathManager) processDistributedJob(warmPathJob *WarmPathJob) error {
+	log.Info.Printf("queuing enumeration job for path %s", warmPathJob.WarmTargetPath)
+	workerJob := InitializeWorkerJobForEnumeration(warmPathJob.JobID, warmPathJob.WarmTargetMountAddresses, warmPathJob.WarmTargetExportPath, warmPathJob.WarmTargetPath, warmPathJob.JobSettings)
+	if err := m.writeWorkerJob(workerJob); err != nil {
+		return fmt.Errorf("error encountered writing enumeration job '%s': %v", warmPathJob.WarmTargetPath, err)
 	}
 
+	m.progress.Update(warmPathJob.JobID, func(p *JobProgress) { p.EnumerationComplete = true })
+	m.progress.Flush()
+
+	m.deferJobReport(warmPathJob)
+
+	return nil
+}
+
+// writeWorkerJob writes the worker job and counts it against the job progress
+func (m *WarmPathManager) writeWorkerJob(workerJob *WorkerJob) error {
+	if err := m.Queues.WriteWorkerJob(workerJob); err != nil {
+		return err
+	}
+	m.progress.Update(workerJob.JobID, func(p *JobProgress) { p.WorkerJobsEnqueued++ })
+	Metrics.WorkerJobsEnqueued.Inc()
 	return nil
 }
 
-func processDirEntries(dirEntries []os.FileInfo, warmPathJob *WarmPathJob) ([]os.FileInfo, []os.FileInfo, []os.FileInfo) {
+// filterChangedFiles records the files in the manifest and returns the new or changed files, or all files for a full warm
+func filterChangedFiles(manifest *Manifest, warmFolder string, files []os.FileInfo, fullWarm bool) []os.FileInfo {
+	changedFiles := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		filePath := path.Join(warmFolder, file.Name())
+		if fullWarm || manifest.IsChanged(filePath, file) {
+			changedFiles = append(changedFiles, file)
+		}
+		manifest.Record(filePath, file)
+	}
+	return changedFiles
+}
+
+// getDirectoryWorkerJobs returns the worker jobs to read the files of the directory, splitting the large files, and
+// listing the files to read when only some of the files of the directory are to be read.  The job id, priority, warm
+// target, and read settings are copied from the job settings.
+func getDirectoryWorkerJobs(jobSettings *WorkerJob, warmFolder string, files []os.FileInfo, largeFiles []os.FileInfo, allFileCount int) []*WorkerJob {
+	j := jobSettings
+	workerJobs := []*WorkerJob{}
+
+	// a job for each large file
+	for _, largeFile := range largeFiles {
+		fullPath := path.Join(warmFolder, largeFile.Name())
+
+		fileSize := largeFile.Size()
+		for i := int64(0); i < fileSize; i += MaximumJobSize {
+			end := i + MaximumJobSize
+			if end > fileSize {
+				end = fileSize
+			}
+			log.Info.Printf("queuing worker job for file %s [%d,%d)", fullPath, i, end)
+			workerJobs = append(workerJobs, InitializeWorkerJobForLargeFile(j.JobID, j.WarmTargetMountAddresses, j.WarmTargetExportPath, fullPath, i, end, j.JobSettings))
+		}
+	}
+
+	// a job for each group of files
+	if len(files) > 0 {
+		if len(files) < allFileCount {
+			// only some files changed, so list the files to read
+			for _, fileList := range groupFileList(files) {
+				log.Info.Printf("queuing job for %d changed files of path %s", len(fileList), warmFolder)
+				workerJobs = append(workerJobs, InitializeWorkerJobWithFileList(j.JobID, j.WarmTargetMountAddresses, j.WarmTargetExportPath, warmFolder, fileList, j.JobSettings))
+			}
+		} else if len(files) < MaximumFilesToRead {
+			log.Info.Printf("queuing job for path %s", warmFolder)
+			workerJobs = append(workerJobs, InitializeWorkerJob(j.JobID, j.WarmTargetMountAddresses, j.WarmTargetExportPath, warmFolder, j.JobSettings))
+		} else {
+			for i := 0; i < len(files); i += MaximumFilesToRead {
+				end := i + MaximumFilesToRead
+				if end >= len(files) {
+					end = len(files) - 1
+				}
+				log.Info.Printf("queuing job for path %s [%s,%s]", warmFolder, files[i].Name(), files[end].Name())
+				workerJobs = append(workerJobs, InitializeWorkerJobWithFilter(j.JobID, j.WarmTargetMountAddresses, j.WarmTargetExportPath, warmFolder, files[i].Name(), files[end].Name(), j.JobSettings))
+			}
+		}
+	}
+
+	return workerJobs
+}
+
+// groupFileList splits the filenames into lists small enough to fit in a worker job
+func groupFileList(files []os.FileInfo) [][]string {
+	result := [][]string{}
+	fileList := []string{}
+	fileListBytes := 0
+	for _, file := range files {
+		if len(fileList) >= MaximumFilesToRead || (len(fileList) > 0 && fileListBytes+len(file.Name()) > MaximumFileListBytes) {
+			result = append(result, fileList)
+			fileList = []string{}
+			fileListBytes = 0
+		}
+		fileList = append(fileList, file.Name())
+		// allow for the quotes and separator of each json string
+		fileListBytes += len(file.Name()) + 3
+	}
+	if len(fileList) > 0 {
+		result = append(result, fileList)
+	}
+	return result
+}
+
+// isJobProgressPath returns true for the progress directory at the root of the export
+func isJobProgressPath(dirPath string) bool {
+	return path.Join("/", dirPath) == path.Join("/", JobProgressDirectory)
+}
+
+// processDirEntries filters and buckets the directory entries, and records the skipped files and the file sizes in
+// the job progress for the job report.  The traversal skips or follows the symlinks, and skips the files already
+// selected through another link.  The returned count of selected symlinks and skipped duplicate files is non-zero
+// when a worker listing the directory again would not find the same files, since a worker skips the symlinks it
+// lists, so the files to read must be listed.
+func processDirEntries(jobID string, progress *ProgressTracker, warmFolder string, dirEntries []os.FileInfo, fileFilter *FileFilter, readWholeFiles bool, traversal *Traversal) ([]os.FileInfo, []os.FileInfo, []os.FileInfo, int) {
 	// bucketize the files into files, largeFiles, and dirs
 	fileSizes := make([]int64, 0, len(dirEntries))
 	files := make([]os.FileInfo, 0, len(dirEntries))
 	largeFiles := make([]os.FileInfo, 0, len(dirEntries))
 	dirs := make([]os.FileInfo, 0, len(dirEntries))
+	skippedFiles := make(map[string]int64)
+	skippedDirs := int64(0)
+	linkCount := 0
 
+	inSymlinkDirectory := traversal.isBelowSymlink(warmFolder)
 	for _, dirEntry := range dirEntries {
+		viaSymlink := isSymlink(dirEntry)
+		if viaSymlink {
+			target, reason := traversal.resolveSymlink(warmFolder, dirEntry)
+			if len(reason) > 0 {
+				skippedFiles[reason]++
+				continue
+			}
+			dirEntry = target
+		}
 		if dirEntry.IsDir() {
+			// prune the excluded directories before they are enumerated
+			if !fileFilter.DirectoryMatches(path.Join(warmFolder, dirEntry.Name())) {
+				skippedDirs++
+				continue
+			}
 			dirs = append(dirs, dirEntry)
 		} else { /* !dirEntry.IsDir() */
-			if !warmPathJob.FileMatches(dirEntry.Name(), dirEntry.Size()) {
+			if reason := fileFilter.FileSkipReason(path.Join(warmFolder, dirEntry.Name()), dirEntry.Size(), dirEntry.ModTime()); len(reason) > 0 {
+				skippedFiles[reason]++
+				continue
+			}
+			if traversal.isDuplicate(path.Join(warmFolder, dirEntry.Name()), dirEntry, viaSymlink || inSymlinkDirectory) {
+				skippedFiles[SkipReasonDuplicateFile]++
+				if !viaSymlink {
+					linkCount++
+				}
 				continue
 			}
+			if viaSymlink {
+				linkCount++
+			}
 			fileSizes = append(fileSizes, dirEntry.Size())
-			if dirEntry.Size() >= MinimumSingleFileSize {
+			// metadata only jobs never split the data reads of large files, and checksum jobs read each file whole
+			if dirEntry.Size() >= MinimumSingleFileSize && !readWholeFiles {
 				largeFiles = append(largeFiles, dirEntry)
 			} else {
 				files = append(files, dirEntry)
@@ -238,8 +686,17 @@ func processDirEntries(dirEntries []os.FileInfo, warmPathJob *WarmPathJob) ([]os
 	}
 
 	printStats(fileSizes, len(dirs))
+	progress.Update(jobID, func(p *JobProgress) {
+		p.DirectoriesSkipped += skippedDirs
+		for reason, count := range skippedFiles {
+			p.RecordSkipped(reason, count)
+		}
+		for _, fileSize := range fileSizes {
+			p.FileSizes.Record(fileSize)
+		}
+	})
 
-	return files, largeFiles, dirs
+	return files, largeFiles, dirs, linkCount
 }
 
 func printStats(fileSizes []int64, dirCount int) {
@@ -274,6 +731,7 @@ func (m *WarmPathManager) RunVMSSManager(ctx context.Context, syncWaitGroup *syn
 	defer syncWaitGroup.Done()
 
 	lastWorkerJobCheckTime := time.Now().Add(-timeBetweenJobCheck)
+	lastScaleCheckTime := time.Now().Add(-timeBetweenScaleCheck)
 	lastReadQueueSuccess := time.Now()
 	lastJobSeen := time.Now()
 	ticker := time.NewTicker(tick)
@@ -299,9 +757,14 @@ func (m *WarmPathManager) RunVMSSManager(ctx context.Context, syncWaitGroup *syn
 						continue
 					}
 				} else if isEmpty == true {
-					// jobs do not exist, delete vmss if not already deleted
+					// jobs do not exist, delete vmss if not already deleted, or scale down to the minimum workers
 					if time.Since(lastJobSeen) > timeToDeleteVMSSAfterNoJobs {
-						m.EnsureVmssDeleted(ctx)
+						if m.scaler.MinWorkerCount == 0 {
+							m.EnsureVmssDeleted(ctx)
+						} else if time.Since(lastScaleCheckTime) > timeBetweenScaleCheck {
+							lastScaleCheckTime = time.Now()
+							m.EnsureVmssCapacity(ctx)
+						}
 					}
 				} else {
 					// jobs exist
@@ -313,8 +776,11 @@ func (m *WarmPathManager) RunVMSSManager(ctx context.Context, syncWaitGroup *syn
 					if workerJob == nil {
 						continue
 					}
-					m.EnsureVmssRunning(ctx)
 					lastJobSeen = time.Now()
+					if time.Since(lastScaleCheckTime) > timeBetweenScaleCheck {
+						lastScaleCheckTime = time.Now()
+						m.EnsureVmssCapacity(ctx)
+					}
 				}
 				lastReadQueueSuccess = time.Now()
 			}
@@ -322,7 +788,40 @@ func (m *WarmPathManager) RunVMSSManager(ctx context.Context, syncWaitGroup *syn
 	}
 }
 
-func (m *WarmPathManager) EnsureVmssRunning(ctx context.Context) {
+// EnsureVmssCapacity creates, scales, or deletes the VMSS to match the work queue depth and the observed worker throughput
+func (m *WarmPathManager) EnsureVmssCapacity(ctx context.Context) {
+	depth, err := m.Queues.GetWorkQueueDepth()
+	if err != nil {
+		log.Error.Printf("error getting work queue depth: %v", err)
+		return
+	}
+	capacity, vmssExists, err := GetVmssCapacity(ctx, m.AzureClients, VmssName)
+	if err != nil {
+		log.Error.Printf("checking VMSS capacity failed with error %v", err)
+		return
+	}
+	desiredCapacity := m.scaler.GetDesiredCapacity(time.Now(), depth, atomic.LoadInt64(&m.workerJobsDone), capacity)
+	log.Info.Printf("work queue depth %d, vmss capacity %d, desired capacity %d", depth, capacity, desiredCapacity)
+
+	switch {
+	case !vmssExists && desiredCapacity > 0:
+		m.EnsureVmssRunning(ctx, desiredCapacity)
+	case vmssExists && desiredCapacity == 0:
+		m.EnsureVmssDeleted(ctx)
+	case vmssExists && desiredCapacity != capacity:
+		if err := ScaleVmss(ctx, m.AzureClients, VmssName, desiredCapacity); err != nil {
+			log.Error.Printf("error scaling vmss: %v", err)
+			return
+		}
+		m.setVmssCapacity(desiredCapacity)
+	}
+}
+
+func (m *WarmPathManager) setVmssCapacity(capacity int64) {
+	Metrics.VmssCapacity.SetFunc(func() int64 { return capacity })
+}
+
+func (m *WarmPathManager) EnsureVmssRunning(ctx context.Context, workerCount int64) {
 	vmssExists, err := VmssExists(ctx, m.AzureClients, VmssName)
 	if err != nil {
 		log.Error.Printf("checking VMSS existence failed with error %v", err)
@@ -358,6 +857,12 @@ func (m *WarmPathManager) EnsureVmssRunning(ctx context.Context) {
 		noProxyStr = fmt.Sprintf("no_proxy=%s", e)
 	}
 
+	// the workers mount the queue export with the nfs options of the manager
+	workerMountOptions := ""
+	if defaultMountSettings := GetDefaultMountSettings(); defaultMountSettings.Type != MountTypeCifs {
+		workerMountOptions = defaultMountSettings.Options
+	}
+
 	cacheWarmerCloudInit := InitializeCloutInit(
 		httpProxyStr,            // httpProxyStr string,
 		httpsProxyStr,           // httpsProxyStr string,
@@ -366,8 +871,14 @@ func (m *WarmPathManager) EnsureVmssRunning(ctx context.Context) {
 		m.bootstrapExportPath,   // exportPath string,
 		m.bootstrapScriptPath,   // bootstrapScriptPath string,
 		m.storageAccount,        // storageAccount string,
-		m.storageKey,            // storageKey string,
+		m.workerQueueAuth,       // queueAuth string,
 		m.queueNamePrefix,       // queueNamePrefix string
+		m.queueType,             // queueType string
+		m.queueMountAddress,     // queueMountAddress string
+		m.queueExportPath,       // queueExportPath string
+		m.queueDirectoryPath,    // queueDirectoryPath string
+		m.workerMetricsAddress,  // metricsAddress string
+		workerMountOptions,      // mountOptions string
 	)
 
 	customData, err := cacheWarmerCloudInit.GetCacheWarmerCloudInit()
@@ -379,28 +890,22 @@ func (m *WarmPathManager) EnsureVmssRunning(ctx context.Context) {
 	cacheWarmerVmss := createCacheWarmerVmssModel(
 		VmssName,                              // vmssName string,
 		m.AzureClients.LocalMetadata.Location, // location string,
-		VMSSNodeSize,                          // vmssSKU string,
-		m.WorkerCount,                         // nodeCount int64,
+		workerCount,                           // nodeCount int64,
 		m.vmssUserName,                        // userName string,
 		m.vmssPassword,                        // password string,
 		m.vmssSshPublicKey,                    // sshKeyData string,
-		MarketPlacePublisher,                  // publisher string,
-		MarketPlaceOffer,                      // offer string,
-		MarketPlaceSku,                        // sku string,
-		PlanName,                              // planName string,
-		PlanPublisherName,                     // planPublisherName string,
-		PlanProductName,                       // planProductName string,
-		compute.Spot,                          // priority compute.VirtualMachinePriorityTypes,
-		compute.Delete,                        // evictionPolicy compute.VirtualMachineEvictionPolicyTypes
+		m.vmssSettings,                        // settings VmssSettings,
 		vmssSubnetId,                          // subnetId string
 		customData,
 	)
 
-	log.Info.Printf("create VMSS with %d workers", m.WorkerCount)
+	log.Info.Printf("create VMSS with %d workers", workerCount)
 	if _, err := CreateVmss(ctx, m.AzureClients, cacheWarmerVmss); err != nil {
 		log.Error.Printf("error creating vmss: %v", err)
 		return
 	}
+	Metrics.VmssCreated.Inc()
+	m.setVmssCapacity(workerCount)
 }
 
 func (m *WarmPathManager) EnsureVmssDeleted(ctx context.Context) {
@@ -417,4 +922,6 @@ func (m *WarmPathManager) EnsureVmssDeleted(ctx context.Context) {
 		log.Error.Printf("error deleting vmss: %v", err)
 		return
 	}
+	Metrics.VmssDeleted.Inc()
+	m.setVmssCapacity(0)
 }
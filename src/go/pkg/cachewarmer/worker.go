@@ -4,13 +4,19 @@ package cachewarmer
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/Avere/src/go/pkg/log"
@@ -18,15 +24,36 @@ import (
 
 // Worker contains the information for the worker
 type Worker struct {
-	Queues    *CacheWarmerQueues
-	workQueue *WorkQueue
+	Queues             *CacheWarmerQueues
+	workQueue          *WorkQueue
+	workerJobs         *WorkerJobTracker
+	progress           *ProgressTracker
+	checksums          *ChecksumTracker
+	throttle           *Throttle
+	cancellations      *JobCancellations
+	deadlines          *JobDeadlines
+	mountHealth        *MountHealth
+	maxDequeueCount    int64
+	scheduledEventsUrl string
+	// set to 1 once the VM is preempted
+	evicted int32
 }
 
 // InitializeWorker initializes the job submitter structure
-func InitializeWorker(queues *CacheWarmerQueues) *Worker {
+func InitializeWorker(queues *CacheWarmerQueues, maxDequeueCount int64, throttleSettings ThrottleSettings, scheduledEventsUrl string) *Worker {
+	writer := GetWorkerProgressWriter()
 	return &Worker{
-		Queues:    queues,
-		workQueue: InitializeWorkQueue(),
+		Queues:             queues,
+		workQueue:          InitializeWorkQueue(),
+		workerJobs:         InitializeWorkerJobTracker(queues),
+		progress:           InitializeProgressTracker(writer),
+		checksums:          InitializeChecksumTracker(writer),
+		throttle:           InitializeThrottle(throttleSettings, writer),
+		cancellations:      InitializeJobCancellations(),
+		deadlines:          InitializeJobDeadlines(),
+		mountHealth:        InitializeMountHealth(),
+		maxDequeueCount:    maxDequeueCount,
+		scheduledEventsUrl: scheduledEventsUrl,
 	}
 }
 
@@ -42,6 +69,26 @@ func (w *Worker) RunWorkerManager(ctx context.Context, syncWaitGroup *sync.WaitG
 	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
+	syncWaitGroup.Add(1)
+	go w.progress.RunProgressFlusher(ctx, syncWaitGroup)
+
+	syncWaitGroup.Add(1)
+	go w.checksums.RunChecksumFlusher(ctx, syncWaitGroup)
+
+	syncWaitGroup.Add(1)
+	go w.workerJobs.RunWorkerJobRefresher(ctx, syncWaitGroup)
+
+	syncWaitGroup.Add(1)
+	go w.throttle.RunThrottleRefresher(ctx, syncWaitGroup, w.progress, w.workerJobs)
+
+	if len(w.scheduledEventsUrl) > 0 {
+		syncWaitGroup.Add(1)
+		go InitializeEvictionWatcher(w.scheduledEventsUrl, w.Evict).RunEvictionWatcher(ctx, syncWaitGroup)
+	}
+
+	Metrics.WorkQueueDepth.SetFunc(func() int64 { return int64(w.workQueue.WorkItemCount()) })
+	Metrics.UnhealthyMountAddresses.SetFunc(w.mountHealth.UnhealthyCount)
+
 	workerCount := WorkerMultiplier * runtime.NumCPU()
 	log.Info.Printf("starting %d orchestrator goroutines", workerCount)
 	for i := 0; i < workerCount; i++ {
@@ -49,6 +96,7 @@ func (w *Worker) RunWorkerManager(ctx context.Context, syncWaitGroup *sync.WaitG
 		go w.worker(ctx, syncWaitGroup)
 	}
 
+	lastIdleJobCheckTime := time.Now()
 	workAvailable := false
 	// run the infinite loop
 	for {
@@ -56,11 +104,15 @@ func (w *Worker) RunWorkerManager(ctx context.Context, syncWaitGroup *sync.WaitG
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			if time.Since(lastIdleJobCheckTime) > timeBetweenIdleJobCheck {
+				lastIdleJobCheckTime = time.Now()
+				w.forgetIdleJobs()
+			}
 			if time.Since(lastJobCheckTime) > timeBetweenWorkerJobCheck || workAvailable {
 				lastJobCheckTime = time.Now()
 				workItemCount := w.workQueue.WorkItemCount()
 
-				if workItemCount < MinimumJobsBeforeRefill {
+				if workItemCount < MinimumJobsBeforeRefill && !w.isEvicted() {
 					workerJob, err := w.Queues.GetWorkerJob()
 					if err != nil {
 						log.Error.Printf("error checking worker job queue: %v", err)
@@ -69,13 +121,56 @@ func (w *Worker) RunWorkerManager(ctx context.Context, syncWaitGroup *sync.WaitG
 					if workerJob == nil {
 						continue
 					}
+					// the exports are mounted with the settings of the job, before the first progress or cancel check
+					mountErr := workerJob.Mount.Validate()
+					if mountErr == nil {
+						RegisterMountSettings(workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath, workerJob.Mount)
+					}
+					w.progress.Register(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath)
+					// a worker job that crashed or hung the workers that took it never reaches the failure paths below,
+					// so it is dead lettered once dequeued more than the max dequeue count
+					if workerJob.GetDequeueCount() > w.maxDequeueCount {
+						w.deadLetterWorkerJob(workerJob, fmt.Errorf("the worker job was not completed by the workers that took it"))
+						continue
+					}
+					if mountErr != nil {
+						// no worker can mount the export with settings that are not valid
+						w.deadLetterWorkerJob(workerJob, fmt.Errorf("the mount settings of the worker job are not valid: %v", mountErr))
+						continue
+					}
+					w.throttle.RegisterJob(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath, workerJob.Throttle)
+					w.checksums.Register(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath, workerJob.Checksum)
+					w.cancellations.Register(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath)
+					if w.cancellations.IsCancelled(workerJob.JobID) {
+						w.dropCancelledJob(workerJob.JobID)
+						if err := w.Queues.DeleteWorkerJob(workerJob); err != nil {
+							log.Error.Printf("error deleting cancelled worker job: %v", err)
+							continue
+						}
+						w.progress.Update(workerJob.JobID, func(p *JobProgress) { p.WorkerJobsCancelled++ })
+						continue
+					}
+					w.deadlines.Register(workerJob.JobID, workerJob.Deadline)
+					if w.deadlines.IsExpired(workerJob.JobID) {
+						w.expireWorkerJob(workerJob)
+						continue
+					}
+					messageID, _ := workerJob.GetQueueMessageInfo()
+					w.workerJobs.Track(workerJob)
 					if err := w.processWorkerJob(ctx, workerJob); err != nil {
 						log.Error.Printf("error processing worker job: %v", err)
+						w.progress.Update(workerJob.JobID, func(p *JobProgress) { p.WorkerJobsFailed++ })
+						Metrics.WorkerJobsFailed.Inc()
+						// a released worker job is already visible to the other workers
+						if trackedJob := w.workerJobs.Untrack(messageID); trackedJob != nil && trackedJob.GetDequeueCount() >= w.maxDequeueCount {
+							w.deadLetterWorkerJob(trackedJob, err)
+						}
 						continue
 					}
-					if err := w.Queues.DeleteWorkerJob(workerJob); err != nil {
-						log.Error.Printf("error deleting worker job: %v", err)
-						continue
+					// an enumeration is also only complete once the files of its directory are read, since a retry
+					// after an eviction only reads those files again
+					if w.workerJobs.Queued(messageID) {
+						w.completeWorkerJob(messageID)
 					}
 				}
 			}
@@ -83,20 +178,175 @@ func (w *Worker) RunWorkerManager(ctx context.Context, syncWaitGroup *sync.WaitG
 	}
 }
 
+// forgetIdleJobs forgets the progress, checksums, cancel state, deadline, and mount addresses of the jobs without
+// tracked worker jobs that were not updated within the idle timeout, so a long running worker does not keep the state
+// of every job it ever read.  A job taken again is registered again, and continues from its written progress.
+func (w *Worker) forgetIdleJobs() {
+	for _, jobID := range w.progress.IdleJobs(jobIdleTimeout) {
+		if w.workerJobs.IsTrackingJob(jobID) {
+			continue
+		}
+		log.Info.Printf("forgetting idle job '%s'", jobID)
+		w.progress.Remove(jobID)
+		w.checksums.Remove(jobID)
+		w.cancellations.Remove(jobID)
+		w.deadlines.Remove(jobID)
+		w.mountHealth.RemoveJob(jobID)
+	}
+}
+
+// dropCancelledJob removes the work items of the cancelled job that are already in the work queue, and deletes the
+// worker jobs of the dropped work items, counting them as cancelled
+func (w *Worker) dropCancelledJob(jobID string) {
+	removedCount := w.workQueue.RemoveJobWorkItems(jobID)
+	if removedCount > 0 {
+		log.Info.Printf("dropped %d work items of cancelled job '%s'", removedCount, jobID)
+	}
+	deletedCount := 0
+	for _, workerJob := range w.workerJobs.UntrackJob(jobID) {
+		if err := w.Queues.DeleteWorkerJob(workerJob); err != nil {
+			log.Error.Printf("error deleting cancelled worker job: %v", err)
+			continue
+		}
+		deletedCount++
+	}
+	w.progress.Update(jobID, func(p *JobProgress) {
+		p.FilesCancelled += int64(removedCount)
+		p.WorkerJobsCancelled += int64(deletedCount)
+	})
+}
+
+// expireWorkerJob deletes the worker job dequeued after the deadline of its job, counting it as expired
+func (w *Worker) expireWorkerJob(workerJob *WorkerJob) {
+	if err := w.Queues.DeleteWorkerJob(workerJob); err != nil {
+		log.Error.Printf("error deleting expired worker job: %v", err)
+		return
+	}
+	w.progress.Update(workerJob.JobID, func(p *JobProgress) {
+		p.WorkerJobsExpired++
+		p.RecordExpired(path.Join(workerJob.WarmTargetExportPath, workerJob.WarmTargetPath))
+	})
+	Metrics.WorkerJobsExpired.Inc()
+}
+
+// expireFileListEntries counts the file list entries of the worker job not queued before the deadline as expired
+func (w *Worker) expireFileListEntries(workerJob *WorkerJob, entries []FileListEntry) {
+	w.progress.Update(workerJob.JobID, func(p *JobProgress) {
+		for i, entry := range entries {
+			// the split ranges of a file are counted as one expired entry
+			if entry.Continued && i > 0 && entries[i-1].Path == entry.Path {
+				continue
+			}
+			p.FileListEntriesExpired++
+			p.RecordExpired(path.Join(workerJob.WarmTargetExportPath, workerJob.WarmTargetPath, entry.Path))
+		}
+	})
+}
+
+// completeWorkerJob deletes the message of the worker job, once all of its work items are done
+func (w *Worker) completeWorkerJob(messageID string) {
+	workerJob := w.workerJobs.Untrack(messageID)
+	if workerJob == nil {
+		return
+	}
+	if err := w.Queues.DeleteWorkerJob(workerJob); err != nil {
+		log.Error.Printf("error deleting worker job: %v", err)
+		return
+	}
+	w.progress.Update(workerJob.JobID, func(p *JobProgress) { p.WorkerJobsCompleted++ })
+	Metrics.WorkerJobsProcessed.Inc()
+}
+
+// addWorkItem queues the file of the worker job, the worker job is complete once all of its files are done
+func (w *Worker) addWorkItem(workerJob *WorkerJob, fileToWarm FileToWarm) {
+	fileToWarm.MessageID, _ = workerJob.GetQueueMessageInfo()
+	w.workerJobs.AddWorkItem(fileToWarm.MessageID)
+	w.workQueue.AddWorkItem(fileToWarm)
+}
+
+// workItemDone completes the worker job of the file once all of its files are done
+func (w *Worker) workItemDone(ctx context.Context, messageID string) {
+	// a file interrupted by the shutdown is not done, so another worker reads it
+	if isCancelled(ctx) {
+		return
+	}
+	if w.workerJobs.WorkItemDone(messageID) {
+		w.completeWorkerJob(messageID)
+	}
+}
+
+// Evict stops the worker taking new worker jobs, and makes the messages of its unfinished worker jobs visible, so the
+// other workers take over the work before the VM is evicted.  The dropped files are no longer counted as queued,
+// since the workers retrying the released worker jobs queue them again.
+func (w *Worker) Evict() {
+	if !atomic.CompareAndSwapInt32(&w.evicted, 0, 1) {
+		return
+	}
+	log.Warning.Printf("the VM is preempted, releasing the unfinished worker jobs")
+	releasedCount := w.workerJobs.ReleaseAll()
+	droppedCount := 0
+	for jobID, count := range w.workQueue.RemoveAllWorkItems() {
+		droppedCount += count
+		w.progress.Update(jobID, func(p *JobProgress) { p.FilesQueued -= int64(count) })
+	}
+	w.progress.Flush()
+	log.Warning.Printf("released %d worker jobs, and dropped %d work items", releasedCount, droppedCount)
+	Metrics.WorkerJobsReleased.Add(int64(releasedCount))
+}
+
+func (w *Worker) isEvicted() bool {
+	return atomic.LoadInt32(&w.evicted) == 1
+}
+
+// deadLetterWorkerJob moves a worker job that has failed too many times to the dead letter queue
+func (w *Worker) deadLetterWorkerJob(workerJob *WorkerJob, processingErr error) {
+	reason := fmt.Sprintf("failed after %d attempts: %v", workerJob.GetDequeueCount(), processingErr)
+	log.Warning.Printf("moving worker job for '%s' to the dead letter queue: %s", workerJob.WarmTargetPath, reason)
+	if err := w.Queues.DeadLetterWorkerJob(workerJob, reason); err != nil {
+		log.Error.Printf("error moving worker job to the dead letter queue: %v", err)
+		return
+	}
+	w.progress.Update(workerJob.JobID, func(p *JobProgress) {
+		p.WorkerJobsDeadLettered++
+		p.RecordFailure(path.Join(workerJob.WarmTargetExportPath, workerJob.WarmTargetPath), processingErr)
+	})
+	Metrics.WorkerJobsDeadLettered.Inc()
+}
+
 func (w *Worker) processWorkerJob(ctx context.Context, workerJob *WorkerJob) error {
 	log.Debug.Printf("[Worker.processWorkerJob")
 	defer log.Debug.Printf("Worker.processWorkerJob]")
 
-	localPaths, err := w.mountAllWorkingPaths(workerJob)
+	if err := workerJob.Mount.Validate(); err != nil {
+		return fmt.Errorf("the mount settings of the worker job are not valid: %v", err)
+	}
+	localPaths, err := w.mountHealth.MountHealthyPaths(workerJob)
 	if err != nil {
 		return fmt.Errorf("error mounting working paths: %v", err)
 	}
 
-	// randomly choose a mount path
-	readPath := localPaths[rand.Intn(len(localPaths))]
+	filesQueued := 0
+	defer func() {
+		w.progress.Update(workerJob.JobID, func(p *JobProgress) { p.FilesQueued += int64(filesQueued) })
+	}()
+
+	if workerJob.EnumerateDirectory {
+		filesQueued, err = w.enumerateDirectory(ctx, workerJob, localPaths)
+		return err
+	}
+
+	if len(workerJob.FileListEntries) > 0 {
+		filesQueued = w.queueFileListEntries(ctx, workerJob, localPaths)
+		log.Info.Printf("add %d jobs to the work queue for %d file list entries [%d mounts]", filesQueued, len(workerJob.FileListEntries), len(localPaths))
+		return nil
+	}
+
+	// randomly choose a mount path, preferring the responsive addresses
+	readPath := w.mountHealth.SelectPath(localPaths)
 
 	// is the workitem a file or directory
 	isDirectory, err := IsDirectory(readPath)
+	w.recordMountResult(readPath, err)
 	if err != nil {
 		return fmt.Errorf("error determining type of path '%s': '%v'", readPath, err)
 	}
@@ -108,17 +358,8 @@ func (w *Worker) processWorkerJob(ctx context.Context, workerJob *WorkerJob) err
 			return fmt.Errorf("error reading files from directory '%s': '%v'", readPath, err)
 		}
 		defer f.Close()
-		lastRefreshVisibility := time.Now()
 		workItemsQueued := 0
 		for {
-			// refresh the invisibility timer, so no-one steals it
-			if time.Since(lastRefreshVisibility) > refreshWorkInterval {
-				lastRefreshVisibility = time.Now()
-				if err := w.Queues.StillProcessingWorkerJob(workerJob); err != nil {
-					log.Error.Printf("error refreshing queue item: '%v'", err)
-				}
-
-			}
 			dirEntries, err := f.Readdir(MinimumJobsOnDirRead)
 
 			if len(dirEntries) == 0 && err == io.EOF {
@@ -127,27 +368,43 @@ func (w *Worker) processWorkerJob(ctx context.Context, workerJob *WorkerJob) err
 			}
 
 			if err != nil && err != io.EOF {
-				log.Error.Printf("error reading directory from directory '%s': '%v'", readPath, err)
-				break
+				// the worker job is retried, and dead lettered if the directory stays unreadable
+				filesQueued = workItemsQueued
+				w.recordMountResult(readPath, err)
+				return fmt.Errorf("error reading directory '%s': '%v'", readPath, err)
 			}
 
 			filteredFilenames := workerJob.FilterFiles(dirEntries)
 			if len(filteredFilenames) > 0 {
-				workItemsQueued += w.QueueWork(localPaths, filteredFilenames)
+				if workerJob.MetadataOnly {
+					workItemsQueued += w.QueueMetadataWork(workerJob, localPaths, filteredFilenames)
+				} else {
+					workItemsQueued += w.QueueWork(workerJob, localPaths, filteredFilenames)
+				}
 			}
 
-			// verify that cancellation has not occurred
-			if isCancelled(ctx) {
+			// verify that cancellation, eviction, or the deadline has not occurred
+			if isCancelled(ctx) || w.isEvicted() {
+				break
+			}
+			if w.deadlines.IsExpired(workerJob.JobID) {
+				// the rest of the listing is never read, so the directory is counted as expired
+				w.progress.Update(workerJob.JobID, func(p *JobProgress) {
+					p.DirectoriesExpired++
+					p.RecordExpired(path.Join(workerJob.WarmTargetExportPath, workerJob.WarmTargetPath))
+				})
 				break
 			}
 		}
 		if workItemsQueued > 0 {
 			log.Info.Printf("add %d jobs to the work queue [%d mounts]", workItemsQueued, len(localPaths))
 		}
+		filesQueued = workItemsQueued
 	} else if workerJob.StartByte == allFilesOrBytes || workerJob.StopByte == allFilesOrBytes {
 		log.Info.Printf("Queueing work item for file %s", readPath)
-		fileToWarm := InitializeFileToWarm(readPath, allFilesOrBytes, allFilesOrBytes)
-		w.workQueue.AddWorkItem(fileToWarm)
+		fileToWarm := workerJob.getFileToWarm(readPath, workerJob.WarmTargetPath)
+		w.addWorkItem(workerJob, fileToWarm)
+		filesQueued = 1
 	} else {
 		// queue the file for read
 		for i := workerJob.StartByte; i < workerJob.StopByte; i += MinimumSingleFileSize {
@@ -156,44 +413,230 @@ func (w *Worker) processWorkerJob(ctx context.Context, workerJob *WorkerJob) err
 				end = workerJob.StopByte
 			}
 			log.Info.Printf("Queueing work item for file %s [%d,%d)", readPath, i, end)
-			fileToWarm := InitializeFileToWarm(readPath, i, end)
-			w.workQueue.AddWorkItem(fileToWarm)
+			fileToWarm := InitializeFileToWarm(workerJob.JobID, readPath, i, end)
+			w.addWorkItem(workerJob, fileToWarm)
+			filesQueued++
 		}
 	}
 	return nil
 }
 
-func (w *Worker) mountAllWorkingPaths(workerJob *WorkerJob) ([]string, error) {
-	localPaths := make([]string, 0, len(workerJob.WarmTargetMountAddresses))
-	for _, warmTargetMountAddress := range workerJob.WarmTargetMountAddresses {
-		if localPath, err := EnsureWarmPath(warmTargetMountAddress, workerJob.WarmTargetExportPath, workerJob.WarmTargetPath); err == nil {
-			localPaths = append(localPaths, localPath)
-		} else {
-			return nil, fmt.Errorf("error warming path '%s' '%s' '%s'", warmTargetMountAddress, workerJob.WarmTargetExportPath, workerJob.WarmTargetPath)
-		}
+// statFileWithHealth stats the file, and records the result with the health of its mount address
+func (w *Worker) statFileWithHealth(fullPath string) (os.FileInfo, error) {
+	statStart := time.Now()
+	fileInfo, err := os.Stat(fullPath)
+	if err == nil {
+		w.mountHealth.RecordSuccess(getMountAddress(fullPath), time.Since(statStart))
+	} else {
+		w.recordMountResult(fullPath, err)
 	}
-	return localPaths, nil
+	return fileInfo, err
 }
 
-func (w *Worker) QueueWork(localPaths []string, filenames []string) int {
+// QueueWork queues the files to read, the large files are read by their own worker jobs, except for checksum jobs
+// that read each file whole
+func (w *Worker) QueueWork(workerJob *WorkerJob, localPaths []string, filenames []string) int {
 	itemsQueued := 0
 	for _, filename := range filenames {
-		randomPath := localPaths[rand.Intn(len(localPaths))]
+		randomPath := w.mountHealth.SelectPath(localPaths)
 		fullPath := path.Join(randomPath, filename)
-		fileInfo, err := os.Stat(fullPath)
+		fileInfo, err := w.statFileWithHealth(fullPath)
 		if err != nil {
 			log.Error.Printf("os.Stat(%s) return error '%v'", fullPath, err)
 			continue
 		}
-		if !fileInfo.IsDir() && fileInfo.Size() < MinimumSingleFileSize {
-			fileToWarm := InitializeFileToWarm(fullPath, allFilesOrBytes, allFilesOrBytes)
-			w.workQueue.AddWorkItem(fileToWarm)
+		if !fileInfo.IsDir() && (fileInfo.Size() < MinimumSingleFileSize || workerJob.Checksum.Enabled) {
+			fileToWarm := workerJob.getFileToWarm(fullPath, path.Join(workerJob.WarmTargetPath, filename))
+			w.addWorkItem(workerJob, fileToWarm)
 			itemsQueued++
 		}
 	}
 	return itemsQueued
 }
 
+// enumerateDirectory lists the directory of an enumeration worker job, queues its files for reading, and writes
+// worker jobs for its subdirectories, large files, and directories with many files.  Once the worker jobs are
+// written, the message of the enumeration records it, so a retried enumeration only queues the files of the
+// directory.  A worker that stops between writing the worker jobs and updating the message writes them again on the
+// retry, and the duplicate worker jobs are counted as enqueued and completed like any other.
+func (w *Worker) enumerateDirectory(ctx context.Context, workerJob *WorkerJob, localPaths []string) (int, error) {
+	warmFolder := workerJob.WarmTargetPath
+
+	// randomly choose a mount path, so the listings are spread across the mount addresses
+	readPath := w.mountHealth.SelectPath(localPaths)
+	log.Info.Printf("Enumerating directory %s", readPath)
+	dirEntries, err := ioutil.ReadDir(readPath)
+	w.recordMountResult(readPath, err)
+	if err != nil {
+		return 0, fmt.Errorf("error reading directory '%s': %v", readPath, err)
+	}
+
+	// the listing of a retried enumeration was already counted, so its counts go to an unregistered tracker
+	listingProgress := w.progress
+	if workerJob.WorkerJobsWritten {
+		listingProgress = InitializeProgressTracker("")
+	} else {
+		w.progress.Update(workerJob.JobID, func(p *JobProgress) { p.DirectoriesListed++ })
+	}
+	Metrics.DirectoriesWalked.Inc()
+
+	// each directory is enumerated by its own worker job, so the files are deduplicated across the workers by the
+	// claims in the job progress directory
+	localExportPath := GetJobMountPath(getMountAddress(readPath), workerJob.WarmTargetExportPath, workerJob.Mount)
+	claimPath := path.Join(localExportPath, JobProgressDirectory, workerJob.JobID, FileClaimDirectory)
+	traversal := InitializeTraversal(workerJob.SymlinkPolicy, localExportPath, workerJob.FilterRootPath, claimPath)
+	files, largeFiles, dirs, linkCount := processDirEntries(workerJob.JobID, listingProgress, warmFolder, dirEntries, &workerJob.FileFilter, workerJob.MetadataOnly || workerJob.Checksum.Enabled, traversal)
+
+	workerJobs := make([]*WorkerJob, 0, len(dirs))
+	for _, dir := range dirs {
+		dirPath := path.Join(warmFolder, dir.Name())
+		if isJobProgressPath(dirPath) {
+			continue
+		}
+		workerJobs = append(workerJobs, InitializeWorkerJobForEnumeration(workerJob.JobID, workerJob.WarmTargetMountAddresses, workerJob.WarmTargetExportPath, dirPath, workerJob.JobSettings))
+	}
+
+	// the files of a small directory are read here, instead of by a worker listing the directory again
+	localFiles := files
+	if len(files) >= MaximumFilesToRead {
+		localFiles = nil
+		workerJobs = append(workerJobs, getDirectoryWorkerJobs(workerJob, warmFolder, files, largeFiles, len(files)+linkCount)...)
+	} else {
+		workerJobs = append(workerJobs, getDirectoryWorkerJobs(workerJob, warmFolder, nil, largeFiles, 0)...)
+	}
+	if !workerJob.WorkerJobsWritten {
+		w.writeWorkerJobs(workerJob.JobID, workerJobs)
+		messageID, _ := workerJob.GetQueueMessageInfo()
+		w.workerJobs.MarkWorkerJobsWritten(messageID)
+	}
+
+	if isCancelled(ctx) {
+		return 0, nil
+	}
+	filenames := make([]string, 0, len(localFiles))
+	for _, file := range localFiles {
+		filenames = append(filenames, file.Name())
+	}
+	if workerJob.MetadataOnly {
+		return w.QueueMetadataWork(workerJob, localPaths, filenames), nil
+	}
+	for _, filename := range filenames {
+		randomPath := w.mountHealth.SelectPath(localPaths)
+		w.addWorkItem(workerJob, workerJob.getFileToWarm(path.Join(randomPath, filename), path.Join(warmFolder, filename)))
+	}
+	return len(filenames), nil
+}
+
+// writeWorkerJobs writes the worker jobs found by a directory enumeration.  The enqueued count is flushed before
+// the worker jobs are written, so the job is never reported complete while any of its worker jobs are queued.
+func (w *Worker) writeWorkerJobs(jobID string, workerJobs []*WorkerJob) {
+	if len(workerJobs) == 0 {
+		return
+	}
+	w.progress.Update(jobID, func(p *JobProgress) { p.WorkerJobsEnqueued += int64(len(workerJobs)) })
+	w.progress.Flush()
+
+	failedCount := 0
+	for _, workerJob := range workerJobs {
+		if err := w.Queues.WriteWorkerJob(workerJob); err != nil {
+			log.Error.Printf("error encountered writing worker job '%s': %v", workerJob.WarmTargetPath, err)
+			failedCount++
+			continue
+		}
+		Metrics.WorkerJobsEnqueued.Inc()
+	}
+	if failedCount > 0 {
+		w.progress.Update(jobID, func(p *JobProgress) { p.WorkerJobsEnqueued -= int64(failedCount) })
+	}
+}
+
+// queueFileListEntries queues the entries of a file list, splitting large files and byte ranges into parts.  An entry
+// that cannot be statted is still queued, so the failed read is counted in the job progress.  A checksum job ignores
+// the byte ranges and reads each file whole.
+func (w *Worker) queueFileListEntries(ctx context.Context, workerJob *WorkerJob, localPaths []string) int {
+	itemsQueued := 0
+	skippedFiles := make(map[string]int64)
+	fileSizes := make([]int64, 0, len(workerJob.FileListEntries))
+	defer func() {
+		w.progress.Update(workerJob.JobID, func(p *JobProgress) {
+			for reason, count := range skippedFiles {
+				p.RecordSkipped(reason, count)
+			}
+			for _, fileSize := range fileSizes {
+				p.FileSizes.Record(fileSize)
+			}
+		})
+	}()
+	for i, entry := range workerJob.FileListEntries {
+		if isCancelled(ctx) || w.isEvicted() {
+			break
+		}
+		if w.deadlines.IsExpired(workerJob.JobID) {
+			w.expireFileListEntries(workerJob, workerJob.FileListEntries[i:])
+			break
+		}
+
+		fullPath := path.Join(w.mountHealth.SelectPath(localPaths), entry.Path)
+		fileInfo, err := w.statFileWithHealth(fullPath)
+		if err != nil {
+			log.Error.Printf("os.Stat(%s) return error '%v'", fullPath, err)
+		} else if fileInfo.IsDir() {
+			continue
+		} else if reason := workerJob.FileFilter.FileSkipReason(path.Join(workerJob.WarmTargetPath, entry.Path), fileInfo.Size(), fileInfo.ModTime()); len(reason) > 0 {
+			if !entry.Continued {
+				skippedFiles[reason]++
+			}
+			continue
+		} else if !entry.Continued {
+			fileSizes = append(fileSizes, fileInfo.Size())
+		}
+
+		if workerJob.MetadataOnly {
+			w.addWorkItem(workerJob, InitializeFileToWarmForMetadata(workerJob.JobID, fullPath, workerJob.MetadataReadBytes))
+			itemsQueued++
+			continue
+		}
+
+		if workerJob.Checksum.Enabled {
+			w.addWorkItem(workerJob, workerJob.getFileToWarm(fullPath, path.Join(workerJob.WarmTargetPath, entry.Path)))
+			itemsQueued++
+			continue
+		}
+
+		startByte, stopByte := entry.StartByte, entry.StopByte
+		if err == nil && startByte == allFilesOrBytes && fileInfo.Size() >= MinimumSingleFileSize {
+			startByte, stopByte = 0, fileInfo.Size()
+		}
+		if err == nil && stopByte > fileInfo.Size() {
+			stopByte = fileInfo.Size()
+		}
+		if startByte == allFilesOrBytes || err != nil {
+			w.addWorkItem(workerJob, InitializeFileToWarm(workerJob.JobID, fullPath, allFilesOrBytes, allFilesOrBytes))
+			itemsQueued++
+			continue
+		}
+		for i := startByte; i < stopByte; i += MinimumSingleFileSize {
+			end := i + MinimumSingleFileSize
+			if end > stopByte {
+				end = stopByte
+			}
+			w.addWorkItem(workerJob, InitializeFileToWarm(workerJob.JobID, fullPath, i, end))
+			itemsQueued++
+		}
+	}
+	return itemsQueued
+}
+
+// QueueMetadataWork queues the files to stat, the stat itself warms the attribute cache so it is not done here
+func (w *Worker) QueueMetadataWork(workerJob *WorkerJob, localPaths []string, filenames []string) int {
+	for _, filename := range filenames {
+		randomPath := w.mountHealth.SelectPath(localPaths)
+		fileToWarm := InitializeFileToWarmForMetadata(workerJob.JobID, path.Join(randomPath, filename), workerJob.MetadataReadBytes)
+		w.addWorkItem(workerJob, fileToWarm)
+	}
+	return len(filenames)
+}
+
 func (w *Worker) worker(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
 	defer syncWaitGroup.Done()
 	log.Info.Printf("[worker")
@@ -235,80 +678,262 @@ func (w *Worker) processWorkItem(ctx context.Context) {
 	if !workExists {
 		return
 	}
-	w.readFile(ctx, fileToWarm)
+	// the worker job of a file queued after the eviction was released, so another worker queues and reads the file
+	if w.isEvicted() {
+		w.progress.Update(fileToWarm.JobID, func(p *JobProgress) { p.FilesQueued-- })
+		return
+	}
+	defer w.workItemDone(ctx, fileToWarm.MessageID)
+	if w.cancellations.IsCancelled(fileToWarm.JobID) {
+		w.progress.Update(fileToWarm.JobID, func(p *JobProgress) { p.FilesCancelled++ })
+		w.dropCancelledJob(fileToWarm.JobID)
+		return
+	}
+	if w.deadlines.IsExpired(fileToWarm.JobID) {
+		w.progress.Update(fileToWarm.JobID, func(p *JobProgress) {
+			p.FilesExpired++
+			p.RecordExpired(getExportFilePath(fileToWarm.WarmFileFullPath))
+		})
+		Metrics.FilesExpired.Inc()
+		return
+	}
+	if !w.throttle.WaitForFile(ctx, fileToWarm.JobID) {
+		// cancelled while throttled
+		return
+	}
+	// the mount address of the file may have become unhealthy since the file was queued
+	fileToWarm.WarmFileFullPath = w.mountHealth.FailoverPath(fileToWarm.JobID, fileToWarm.WarmFileFullPath)
+	if fileToWarm.MetadataOnly {
+		readBytes, err := w.statFile(ctx, fileToWarm)
+		if err == errReadInterrupted {
+			w.recordInterruptedRead(ctx, fileToWarm, readBytes)
+			return
+		}
+		w.recordMountResult(fileToWarm.WarmFileFullPath, err)
+		w.progress.Update(fileToWarm.JobID, func(p *JobProgress) {
+			p.BytesRead += readBytes
+			if err != nil {
+				p.FilesStatFailed++
+				p.RecordFailure(getExportFilePath(fileToWarm.WarmFileFullPath), err)
+			} else {
+				p.FilesStatted++
+			}
+		})
+		Metrics.BytesRead.Add(readBytes)
+		if err != nil {
+			Metrics.FilesFailed.Inc()
+		} else {
+			Metrics.FilesStatted.Inc()
+		}
+		return
+	}
+	var checksum hash.Hash
+	if len(fileToWarm.ChecksumPath) > 0 {
+		checksum = sha256.New()
+	}
+	readStart := time.Now()
+	readBytes, err := w.readFile(ctx, fileToWarm, checksum)
+	w.recordMountResult(fileToWarm.WarmFileFullPath, err)
+	if failoverPath := w.mountHealth.FailoverPath(fileToWarm.JobID, fileToWarm.WarmFileFullPath); isMountError(err) && failoverPath != fileToWarm.WarmFileFullPath {
+		// retry once on a healthy mount address, the checksum starts over
+		log.Warning.Printf("retrying the read of %s as %s: %v", fileToWarm.WarmFileFullPath, failoverPath, err)
+		fileToWarm.WarmFileFullPath = failoverPath
+		if checksum != nil {
+			checksum.Reset()
+		}
+		var retryBytes int64
+		retryBytes, err = w.readFile(ctx, fileToWarm, checksum)
+		w.recordMountResult(fileToWarm.WarmFileFullPath, err)
+		readBytes += retryBytes
+	}
+	if err == errReadInterrupted {
+		w.recordInterruptedRead(ctx, fileToWarm, readBytes)
+		return
+	}
+	mismatch := false
+	if err == nil && checksum != nil {
+		mismatch = w.checksums.Record(fileToWarm.JobID, fileToWarm.ChecksumPath, hex.EncodeToString(checksum.Sum(nil)))
+		if mismatch {
+			log.Warning.Printf("checksum of %s does not match the reference checksum (job '%s')", fileToWarm.ChecksumPath, fileToWarm.JobID)
+		}
+	}
+	w.progress.Update(fileToWarm.JobID, func(p *JobProgress) {
+		p.BytesRead += readBytes
+		if err != nil {
+			p.FilesFailed++
+			p.RecordFailure(getExportFilePath(fileToWarm.WarmFileFullPath), err)
+		} else {
+			p.FilesRead++
+		}
+		if err == nil && checksum != nil {
+			p.FilesChecksummed++
+		}
+		if mismatch {
+			p.ChecksumMismatches++
+		}
+	})
+	Metrics.BytesRead.Add(readBytes)
+	if err != nil {
+		Metrics.FilesFailed.Inc()
+	} else {
+		Metrics.FilesRead.Inc()
+		Metrics.FileReadDurationSeconds.Observe(time.Since(readStart).Seconds())
+	}
+	if err == nil && checksum != nil {
+		Metrics.FilesChecksummed.Inc()
+	}
+	if mismatch {
+		Metrics.ChecksumMismatches.Inc()
+	}
 }
 
-func (w *Worker) readFile(ctx context.Context, fileToWarm FileToWarm) {
+// recordInterruptedRead counts a read stopped before the end of the file, which is neither read nor failed.  A read
+// stopped by the shutdown of the worker is not counted, since its work item is not done and another worker reads the
+// file, and a read stopped by the cancel or the deadline of its job is counted as cancelled or expired.
+func (w *Worker) recordInterruptedRead(ctx context.Context, fileToWarm FileToWarm, readBytes int64) {
+	isShutdown := isCancelled(ctx)
+	isJobCancelled := w.cancellations.IsCancelled(fileToWarm.JobID)
+	w.progress.Update(fileToWarm.JobID, func(p *JobProgress) {
+		p.BytesRead += readBytes
+		if isShutdown {
+			return
+		}
+		if isJobCancelled {
+			p.FilesCancelled++
+		} else {
+			p.FilesExpired++
+			p.RecordExpired(getExportFilePath(fileToWarm.WarmFileFullPath))
+		}
+	})
+	Metrics.BytesRead.Add(readBytes)
+	if !isShutdown && !isJobCancelled {
+		Metrics.FilesExpired.Inc()
+	}
+}
+
+// recordMountResult records the result of a read or stat with the health of the mount address of the path
+func (w *Worker) recordMountResult(fullPath string, err error) {
+	if isMountError(err) {
+		w.mountHealth.RecordFailure(getMountAddress(fullPath), err)
+	} else if err == nil {
+		w.mountHealth.RecordSuccess(getMountAddress(fullPath), 0)
+	}
+}
+
+// errReadInterrupted is returned when a read stops before the end of the file or byte range, because of the shutdown
+// of the worker, or the cancel or deadline of its job
+var errReadInterrupted = errors.New("read interrupted")
+
+// readFile reads the file, and adds the bytes of a whole file read to the checksum if not nil
+func (w *Worker) readFile(ctx context.Context, fileToWarm FileToWarm, checksum hash.Hash) (int64, error) {
 	if fileToWarm.StartByte == allFilesOrBytes || fileToWarm.StopByte == allFilesOrBytes {
-		w.readFileFull(ctx, fileToWarm)
+		return w.readFileFull(ctx, fileToWarm, checksum)
 	} else {
-		w.readFilePartial(ctx, fileToWarm)
+		return w.readFilePartial(ctx, fileToWarm)
 	}
 }
 
-func (w *Worker) readFileFull(ctx context.Context, fileToWarm FileToWarm) {
-	var readBytes int
+// statFile warms the attributes of the file, and optionally reads the first bytes of the file
+func (w *Worker) statFile(ctx context.Context, fileToWarm FileToWarm) (int64, error) {
+	if _, err := os.Stat(fileToWarm.WarmFileFullPath); err != nil {
+		log.Error.Printf("error statting file %s: %v", fileToWarm.WarmFileFullPath, err)
+		return 0, err
+	}
+	if fileToWarm.StopByte <= 0 {
+		return 0, nil
+	}
+	return w.readFilePartial(ctx, fileToWarm)
+}
+
+func (w *Worker) readFileFull(ctx context.Context, fileToWarm FileToWarm, checksum hash.Hash) (int64, error) {
+	var readBytes int64
 	file, err := os.Open(fileToWarm.WarmFileFullPath)
 	if err != nil {
 		log.Error.Printf("error opening file %s: %v", fileToWarm.WarmFileFullPath, err)
-		return
+		return readBytes, err
 	}
 	defer file.Close()
 	buffer := make([]byte, ReadPageSize)
 	lastCancelCheckTime := time.Now()
 	for {
 		count, err := file.Read(buffer)
-		readBytes += count
+		readBytes += int64(count)
+		if checksum != nil {
+			checksum.Write(buffer[:count])
+		}
+		// charge the bytes read, blocking while the rate limit is exceeded
+		if !w.throttle.WaitForBytes(ctx, fileToWarm.JobID, int64(count)) {
+			return readBytes, errReadInterrupted
+		}
 		if err != nil {
 			if err != io.EOF {
 				log.Error.Printf("error reading file %s: %v", fileToWarm.WarmFileFullPath, err)
+				return readBytes, err
 			}
 			log.Info.Printf("read %d bytes from filepath %s", readBytes, fileToWarm.WarmFileFullPath)
-			return
+			return readBytes, nil
 		}
 		// ensure no cancel
 		if time.Since(lastCancelCheckTime) > timeBetweenCancelCheck {
 			lastCancelCheckTime = time.Now()
-			if isCancelled(ctx) {
-				return
+			if isCancelled(ctx) || w.cancellations.IsCancelled(fileToWarm.JobID) || w.deadlines.IsExpired(fileToWarm.JobID) {
+				return readBytes, errReadInterrupted
 			}
 		}
 	}
 }
 
-func (w *Worker) readFilePartial(ctx context.Context, fileToWarm FileToWarm) {
+func (w *Worker) readFilePartial(ctx context.Context, fileToWarm FileToWarm) (int64, error) {
 	if fileToWarm.StartByte == allFilesOrBytes || fileToWarm.StopByte == allFilesOrBytes {
 		log.Error.Printf("error no startbyte or stop byte set for partial read")
-		return
+		return 0, fmt.Errorf("no startbyte or stop byte set for partial read")
 	}
 
-	var readBytes int
+	var readBytes int64
 	file, err := os.Open(fileToWarm.WarmFileFullPath)
 	if err != nil {
 		log.Error.Printf("error opening file %s: %v", fileToWarm.WarmFileFullPath, err)
-		return
+		return readBytes, err
 	}
 	defer file.Close()
-	buffer := make([]byte, ReadPageSize)
+	bufferSize := int64(ReadPageSize)
+	if fileToWarm.StopByte-fileToWarm.StartByte < bufferSize {
+		bufferSize = fileToWarm.StopByte - fileToWarm.StartByte
+	}
+	buffer := make([]byte, bufferSize)
 	lastCancelCheckTime := time.Now()
 
-	for currentByte := fileToWarm.StartByte; currentByte < fileToWarm.StopByte; currentByte = fileToWarm.StartByte + int64(readBytes) {
-		count, err := file.ReadAt(buffer, currentByte)
-		readBytes += count
+	var readErr error
+	for currentByte := fileToWarm.StartByte; currentByte < fileToWarm.StopByte; currentByte = fileToWarm.StartByte + readBytes {
+		// never read past the stop byte
+		readSize := bufferSize
+		if fileToWarm.StopByte-currentByte < readSize {
+			readSize = fileToWarm.StopByte - currentByte
+		}
+		count, err := file.ReadAt(buffer[:readSize], currentByte)
+		readBytes += int64(count)
+		// charge the bytes read, blocking while the rate limit is exceeded
+		if !w.throttle.WaitForBytes(ctx, fileToWarm.JobID, int64(count)) {
+			readErr = errReadInterrupted
+			break
+		}
 		if err != nil {
 			if err != io.EOF {
 				log.Error.Printf("error reading file %s: %v", fileToWarm.WarmFileFullPath, err)
+				readErr = err
 			}
 			break
 		}
 		// ensure no cancel
 		if time.Since(lastCancelCheckTime) > timeBetweenCancelCheck {
 			lastCancelCheckTime = time.Now()
-			if isCancelled(ctx) {
+			if isCancelled(ctx) || w.cancellations.IsCancelled(fileToWarm.JobID) || w.deadlines.IsExpired(fileToWarm.JobID) {
+				readErr = errReadInterrupted
 				break
 			}
 		}
 	}
 
 	log.Info.Printf("read %d bytes from filepath %s [%d,%d)", readBytes, fileToWarm.WarmFileFullPath, fileToWarm.StartByte, fileToWarm.StopByte)
+	return readBytes, readErr
 }
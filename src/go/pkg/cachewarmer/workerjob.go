@@ -5,13 +5,13 @@ package cachewarmer
 import (
 	"encoding/json"
 	"os"
+	"path"
 	"strings"
-
-	"github.com/Azure/azure-storage-queue-go/azqueue"
 )
 
 // WorkerJob contains the information for a worker job item
 type WorkerJob struct {
+	JobID                    string
 	WarmTargetMountAddresses []string
 	WarmTargetExportPath     string
 	WarmTargetPath           string
@@ -20,31 +20,44 @@ type WorkerJob struct {
 	ApplyFilter              bool
 	StartFileFilter          string
 	EndFileFilter            string
-	InclusionList            []string
-	ExclusionList            []string
-	MaxFileSizeBytes         int64
-	queueMessageID           azqueue.MessageID
-	queuePopReceipt          azqueue.PopReceipt
+	FileList                 []string
+	FileListEntries          []FileListEntry
+	EnumerateDirectory       bool
+	WorkerJobsWritten        bool
+	JobSettings
+	fileListSet       map[string]bool
+	queueMessageID    string
+	queuePopReceipt   string
+	queueDequeueCount int64
 }
 
-func (j *WorkerJob) SetQueueMessageInfo(id azqueue.MessageID, popReceipt azqueue.PopReceipt) {
+func (j *WorkerJob) SetQueueMessageInfo(id string, popReceipt string) {
 	j.queueMessageID = id
 	j.queuePopReceipt = popReceipt
 }
 
-func (j *WorkerJob) GetQueueMessageInfo() (azqueue.MessageID, azqueue.PopReceipt) {
+func (j *WorkerJob) GetQueueMessageInfo() (string, string) {
 	return j.queueMessageID, j.queuePopReceipt
 }
 
+func (j *WorkerJob) SetDequeueCount(dequeueCount int64) {
+	j.queueDequeueCount = dequeueCount
+}
+
+// GetDequeueCount returns the number of times the worker job message has been dequeued, including this time
+func (j *WorkerJob) GetDequeueCount() int64 {
+	return j.queueDequeueCount
+}
+
 // InitializeWorkerJob initializes the worker job structure
 func InitializeWorkerJob(
+	jobID string,
 	warmTargetMountAddresses []string,
 	warmTargetExportPath string,
 	warmTargetPath string,
-	inclusionList []string,
-	exclusionList []string,
-	maxFileSizeBytes int64) *WorkerJob {
+	settings JobSettings) *WorkerJob {
 	return &WorkerJob{
+		JobID:                    jobID,
 		WarmTargetMountAddresses: warmTargetMountAddresses,
 		WarmTargetExportPath:     warmTargetExportPath,
 		WarmTargetPath:           warmTargetPath,
@@ -53,60 +66,79 @@ func InitializeWorkerJob(
 		ApplyFilter:              false,
 		StartFileFilter:          "",
 		EndFileFilter:            "",
-		InclusionList:            inclusionList,
-		ExclusionList:            exclusionList,
-		MaxFileSizeBytes:         maxFileSizeBytes,
+		JobSettings:              settings,
 	}
 }
 
 // InitializeWorkerJob initializes the worker job structure
 func InitializeWorkerJobForLargeFile(
+	jobID string,
 	warmTargetMountAddresses []string,
 	warmTargetExportPath string,
 	warmTargetPath string,
 	startByte int64,
 	stopByte int64,
-	inclusionList []string,
-	exclusionList []string,
-	maxFileSizeBytes int64) *WorkerJob {
-	return &WorkerJob{
-		WarmTargetMountAddresses: warmTargetMountAddresses,
-		WarmTargetExportPath:     warmTargetExportPath,
-		WarmTargetPath:           warmTargetPath,
-		StartByte:                startByte,
-		StopByte:                 stopByte,
-		ApplyFilter:              false,
-		StartFileFilter:          "",
-		EndFileFilter:            "",
-		InclusionList:            inclusionList,
-		ExclusionList:            exclusionList,
-		MaxFileSizeBytes:         maxFileSizeBytes,
-	}
+	settings JobSettings) *WorkerJob {
+	workerJob := InitializeWorkerJob(jobID, warmTargetMountAddresses, warmTargetExportPath, warmTargetPath, settings)
+	workerJob.StartByte = startByte
+	workerJob.StopByte = stopByte
+	return workerJob
 }
 
 // InitializeWorkerJobWithFilter initializes the worker job structure
 func InitializeWorkerJobWithFilter(
+	jobID string,
 	warmTargetMountAddresses []string,
 	warmTargetExportPath string,
 	warmTargetPath string,
 	startFileFilter string,
 	endFileFilter string,
-	inclusionList []string,
-	exclusionList []string,
-	maxFileSizeBytes int64) *WorkerJob {
-	return &WorkerJob{
-		WarmTargetMountAddresses: warmTargetMountAddresses,
-		WarmTargetExportPath:     warmTargetExportPath,
-		WarmTargetPath:           warmTargetPath,
-		StartByte:                allFilesOrBytes,
-		StopByte:                 allFilesOrBytes,
-		ApplyFilter:              true,
-		StartFileFilter:          startFileFilter,
-		EndFileFilter:            endFileFilter,
-		InclusionList:            inclusionList,
-		ExclusionList:            exclusionList,
-		MaxFileSizeBytes:         maxFileSizeBytes,
-	}
+	settings JobSettings) *WorkerJob {
+	workerJob := InitializeWorkerJob(jobID, warmTargetMountAddresses, warmTargetExportPath, warmTargetPath, settings)
+	workerJob.ApplyFilter = true
+	workerJob.StartFileFilter = startFileFilter
+	workerJob.EndFileFilter = endFileFilter
+	return workerJob
+}
+
+// InitializeWorkerJobWithFileList initializes the worker job structure to warm only the listed files of the directory
+func InitializeWorkerJobWithFileList(
+	jobID string,
+	warmTargetMountAddresses []string,
+	warmTargetExportPath string,
+	warmTargetPath string,
+	fileList []string,
+	settings JobSettings) *WorkerJob {
+	workerJob := InitializeWorkerJob(jobID, warmTargetMountAddresses, warmTargetExportPath, warmTargetPath, settings)
+	workerJob.FileList = fileList
+	return workerJob
+}
+
+// InitializeWorkerJobForEnumeration initializes the worker job structure to enumerate the directory, queuing
+// worker jobs for its files and subdirectories
+func InitializeWorkerJobForEnumeration(
+	jobID string,
+	warmTargetMountAddresses []string,
+	warmTargetExportPath string,
+	warmTargetPath string,
+	settings JobSettings) *WorkerJob {
+	workerJob := InitializeWorkerJob(jobID, warmTargetMountAddresses, warmTargetExportPath, warmTargetPath, settings)
+	workerJob.EnumerateDirectory = true
+	return workerJob
+}
+
+// InitializeWorkerJobWithFileListEntries initializes the worker job structure to warm the entries of a file list,
+// where the entry paths are relative to the warm target path
+func InitializeWorkerJobWithFileListEntries(
+	jobID string,
+	warmTargetMountAddresses []string,
+	warmTargetExportPath string,
+	warmTargetPath string,
+	fileListEntries []FileListEntry,
+	settings JobSettings) *WorkerJob {
+	workerJob := InitializeWorkerJob(jobID, warmTargetMountAddresses, warmTargetExportPath, warmTargetPath, settings)
+	workerJob.FileListEntries = fileListEntries
+	return workerJob
 }
 
 // InitializeWorkerJobFromString reads warmPathJobContents
@@ -128,20 +160,51 @@ func (j *WorkerJob) GetWorkerJobFileContents() (string, error) {
 	return string(data), nil
 }
 
+// getFileToWarm returns the work item to read the whole file, computing its checksum for a checksum job.  The
+// file path is relative to the export, and the checksum path is relative to the warm target path of the job.
+func (j *WorkerJob) getFileToWarm(localFilePath string, filePath string) FileToWarm {
+	if !j.Checksum.Enabled {
+		return InitializeFileToWarm(j.JobID, localFilePath, allFilesOrBytes, allFilesOrBytes)
+	}
+	checksumPath := j.FileFilter.getRelativePath(filePath)
+	if len(checksumPath) == 0 {
+		// the warm target path is the file itself
+		checksumPath = path.Base(filePath)
+	}
+	return InitializeFileToWarmWithChecksum(j.JobID, localFilePath, checksumPath)
+}
+
 func (j *WorkerJob) FilterFiles(dirEntries []os.FileInfo) []string {
 	filteredFileNames := make([]string, 0, len(dirEntries))
 
+	if len(j.FileList) > 0 && j.fileListSet == nil {
+		j.fileListSet = make(map[string]bool, len(j.FileList))
+		for _, filename := range j.FileList {
+			j.fileListSet[filename] = true
+		}
+	}
+
 	for _, dirEntry := range dirEntries {
 		if !dirEntry.IsDir() {
 			filename := dirEntry.Name()
+			if j.fileListSet != nil && !j.fileListSet[filename] {
+				continue
+			}
+			if isSymlink(dirEntry) {
+				// a symlink is only read when listed by the enumeration, which applied the symlink policy and
+				// matched the filter against the symlink target
+				if j.fileListSet == nil {
+					continue
+				}
+			} else if !j.FileFilter.FileMatches(path.Join(j.WarmTargetPath, filename), dirEntry.Size(), dirEntry.ModTime()) {
+				continue
+			}
 			if j.ApplyFilter == false {
 				filteredFileNames = append(filteredFileNames, filename)
 			} else {
 				compareStart := strings.Compare(filename, j.StartFileFilter)
 				if compareStart == 0 || (compareStart > 0 && strings.Compare(filename, j.EndFileFilter) <= 0) {
-					if FileMatches(j.InclusionList, j.ExclusionList, j.MaxFileSizeBytes, filename, dirEntry.Size()) {
-						filteredFileNames = append(filteredFileNames, filename)
-					}
+					filteredFileNames = append(filteredFileNames, filename)
 				}
 			}
 		}
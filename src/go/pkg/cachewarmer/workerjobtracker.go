@@ -0,0 +1,228 @@
+// Copyright (C) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See LICENSE-CODE in the project root for license information.
+package cachewarmer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/Avere/src/go/pkg/log"
+)
+
+// trackedWorkerJob is a dequeued worker job whose message is kept invisible until its work items are done
+type trackedWorkerJob struct {
+	mux sync.Mutex
+	// a copy of the worker job, holding the pop receipt of the latest visibility refresh
+	workerJob *WorkerJob
+	released  bool
+
+	// guarded by the mutex of the tracker
+	workItems int
+	queued    bool
+}
+
+// WorkerJobTracker tracks the worker jobs whose work items are in the work queue of the worker.  The message of a
+// worker job is only deleted once its work items are done, so the files of a worker lost to an eviction are read by
+// another worker.
+type WorkerJobTracker struct {
+	mux        sync.Mutex
+	queues     *CacheWarmerQueues
+	workerJobs map[string]*trackedWorkerJob
+}
+
+// InitializeWorkerJobTracker initializes the worker job tracker
+func InitializeWorkerJobTracker(queues *CacheWarmerQueues) *WorkerJobTracker {
+	return &WorkerJobTracker{
+		queues:     queues,
+		workerJobs: make(map[string]*trackedWorkerJob),
+	}
+}
+
+// Track starts tracking the dequeued worker job
+func (t *WorkerJobTracker) Track(workerJob *WorkerJob) {
+	id, _ := workerJob.GetQueueMessageInfo()
+	workerJobCopy := *workerJob
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.workerJobs[id] = &trackedWorkerJob{workerJob: &workerJobCopy}
+}
+
+// AddWorkItem counts a work item queued for the worker job
+func (t *WorkerJobTracker) AddWorkItem(messageID string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if trackedJob, ok := t.workerJobs[messageID]; ok {
+		trackedJob.workItems++
+	}
+}
+
+// WorkItemDone counts a done work item, and returns true if the worker job is complete
+func (t *WorkerJobTracker) WorkItemDone(messageID string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	trackedJob, ok := t.workerJobs[messageID]
+	if !ok {
+		return false
+	}
+	trackedJob.workItems--
+	return trackedJob.queued && trackedJob.workItems <= 0
+}
+
+// Queued records that all work items of the worker job are queued, and returns true if the worker job is complete
+func (t *WorkerJobTracker) Queued(messageID string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	trackedJob, ok := t.workerJobs[messageID]
+	if !ok {
+		return false
+	}
+	trackedJob.queued = true
+	return trackedJob.workItems <= 0
+}
+
+// MarkWorkerJobsWritten records in the message of the enumeration worker job that its worker jobs are written, so a
+// retry of the enumeration only queues the files of its directory
+func (t *WorkerJobTracker) MarkWorkerJobsWritten(messageID string) {
+	t.mux.Lock()
+	trackedJob, ok := t.workerJobs[messageID]
+	t.mux.Unlock()
+	if !ok {
+		return
+	}
+	trackedJob.mux.Lock()
+	defer trackedJob.mux.Unlock()
+	trackedJob.workerJob.WorkerJobsWritten = true
+	if trackedJob.released {
+		return
+	}
+	if err := t.queues.StillProcessingWorkerJob(trackedJob.workerJob); err != nil {
+		log.Error.Printf("error recording the written worker jobs of '%s': %v", trackedJob.workerJob.WarmTargetPath, err)
+	}
+}
+
+// Untrack stops tracking the worker job, and returns the copy holding the latest pop receipt, or nil if the worker job
+// was released or untracked
+func (t *WorkerJobTracker) Untrack(messageID string) *WorkerJob {
+	t.mux.Lock()
+	trackedJob, ok := t.workerJobs[messageID]
+	delete(t.workerJobs, messageID)
+	t.mux.Unlock()
+	if !ok {
+		return nil
+	}
+	return trackedJob.release()
+}
+
+// UntrackJob stops tracking the worker jobs of the job, and returns their copies holding the latest pop receipts
+func (t *WorkerJobTracker) UntrackJob(jobID string) []*WorkerJob {
+	t.mux.Lock()
+	trackedJobs := make([]*trackedWorkerJob, 0)
+	for messageID, trackedJob := range t.workerJobs {
+		if trackedJob.workerJob.JobID == jobID {
+			trackedJobs = append(trackedJobs, trackedJob)
+			delete(t.workerJobs, messageID)
+		}
+	}
+	t.mux.Unlock()
+
+	workerJobs := make([]*WorkerJob, 0, len(trackedJobs))
+	for _, trackedJob := range trackedJobs {
+		if workerJob := trackedJob.release(); workerJob != nil {
+			workerJobs = append(workerJobs, workerJob)
+		}
+	}
+	return workerJobs
+}
+
+// IsTrackingJob returns true if any worker job of the job is tracked
+func (t *WorkerJobTracker) IsTrackingJob(jobID string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for _, trackedJob := range t.workerJobs {
+		if trackedJob.workerJob.JobID == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// release marks the worker job released, and returns its copy, or nil if already released
+func (j *trackedWorkerJob) release() *WorkerJob {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.released {
+		return nil
+	}
+	j.released = true
+	return j.workerJob
+}
+
+// snapshot returns the tracked worker jobs
+func (t *WorkerJobTracker) snapshot() []*trackedWorkerJob {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	trackedJobs := make([]*trackedWorkerJob, 0, len(t.workerJobs))
+	for _, trackedJob := range t.workerJobs {
+		trackedJobs = append(trackedJobs, trackedJob)
+	}
+	return trackedJobs
+}
+
+// Refresh extends the visibility timeout of the tracked worker jobs, so no other worker takes them
+func (t *WorkerJobTracker) Refresh() {
+	for _, trackedJob := range t.snapshot() {
+		trackedJob.mux.Lock()
+		if !trackedJob.released {
+			if err := t.queues.StillProcessingWorkerJob(trackedJob.workerJob); err != nil {
+				log.Error.Printf("error refreshing queue item: '%v'", err)
+			}
+		}
+		trackedJob.mux.Unlock()
+	}
+}
+
+// ReleaseAll stops tracking all worker jobs, and makes their messages visible to the other workers, returning the
+// number of released worker jobs
+func (t *WorkerJobTracker) ReleaseAll() int {
+	t.mux.Lock()
+	trackedJobs := make([]*trackedWorkerJob, 0, len(t.workerJobs))
+	for _, trackedJob := range t.workerJobs {
+		trackedJobs = append(trackedJobs, trackedJob)
+	}
+	t.workerJobs = make(map[string]*trackedWorkerJob)
+	t.mux.Unlock()
+
+	releasedCount := 0
+	for _, trackedJob := range trackedJobs {
+		workerJob := trackedJob.release()
+		if workerJob == nil {
+			continue
+		}
+		if err := t.queues.ReleaseWorkerJob(workerJob); err != nil {
+			log.Error.Printf("error releasing worker job '%s': %v", workerJob.WarmTargetPath, err)
+			continue
+		}
+		releasedCount++
+	}
+	return releasedCount
+}
+
+// RunWorkerJobRefresher refreshes the visibility timeout of the tracked worker jobs until the context is cancelled
+func (t *WorkerJobTracker) RunWorkerJobRefresher(ctx context.Context, syncWaitGroup *sync.WaitGroup) {
+	log.Debug.Printf("[WorkerJobTracker.RunWorkerJobRefresher")
+	defer log.Debug.Printf("WorkerJobTracker.RunWorkerJobRefresher]")
+	defer syncWaitGroup.Done()
+
+	ticker := time.NewTicker(refreshWorkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Refresh()
+		}
+	}
+}
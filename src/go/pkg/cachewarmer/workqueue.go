@@ -7,19 +7,48 @@ import (
 )
 
 type FileToWarm struct {
+	JobID            string
 	WarmFileFullPath string
 	StartByte        int64
 	StopByte         int64
+	MetadataOnly     bool
+	// the path relative to the warm target path, set when the checksum of the whole file is computed
+	ChecksumPath string
+	// the queue message id of the worker job that queued the file
+	MessageID string
 }
 
-func InitializeFileToWarm(warmFilePath string, startByte int64, stopByte int64) FileToWarm {
+func InitializeFileToWarm(jobID string, warmFilePath string, startByte int64, stopByte int64) FileToWarm {
 	return FileToWarm{
+		JobID:            jobID,
 		WarmFileFullPath: warmFilePath,
 		StartByte:        startByte,
 		StopByte:         stopByte,
 	}
 }
 
+// InitializeFileToWarmWithChecksum initializes a file to read whole, recording its checksum under the relative path
+func InitializeFileToWarmWithChecksum(jobID string, warmFilePath string, checksumPath string) FileToWarm {
+	return FileToWarm{
+		JobID:            jobID,
+		WarmFileFullPath: warmFilePath,
+		StartByte:        allFilesOrBytes,
+		StopByte:         allFilesOrBytes,
+		ChecksumPath:     checksumPath,
+	}
+}
+
+// InitializeFileToWarmForMetadata initializes a file to stat, reading only the first readBytes bytes
+func InitializeFileToWarmForMetadata(jobID string, warmFilePath string, readBytes int64) FileToWarm {
+	return FileToWarm{
+		JobID:            jobID,
+		WarmFileFullPath: warmFilePath,
+		StartByte:        0,
+		StopByte:         readBytes,
+		MetadataOnly:     true,
+	}
+}
+
 // RoundRobinPathManager round robins among the available paths
 type WorkQueue struct {
 	mux       sync.Mutex
@@ -56,6 +85,36 @@ func (q *WorkQueue) GetNextWorkItem() (FileToWarm, bool) {
 	}
 }
 
+// RemoveJobWorkItems removes all work items of the job, and returns the number removed
+func (q *WorkQueue) RemoveJobWorkItems(jobID string) int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	workItems := q.workItems[:0]
+	for _, workItem := range q.workItems {
+		if workItem.JobID != jobID {
+			workItems = append(workItems, workItem)
+		}
+	}
+	for i := len(workItems); i < len(q.workItems); i++ {
+		q.workItems[i] = FileToWarm{}
+	}
+	removedCount := len(q.workItems) - len(workItems)
+	q.workItems = workItems
+	return removedCount
+}
+
+// RemoveAllWorkItems removes all work items, and returns the number removed of each job
+func (q *WorkQueue) RemoveAllWorkItems() map[string]int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	removedCounts := make(map[string]int)
+	for _, workItem := range q.workItems {
+		removedCounts[workItem.JobID]++
+	}
+	q.workItems = nil
+	return removedCounts
+}
+
 func (q *WorkQueue) AddWorkItem(fileToWarm FileToWarm) {
 	q.mux.Lock()
 	defer q.mux.Unlock()